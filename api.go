@@ -1,7 +1,5 @@
 package mbpqs
 
-import "fmt"
-
 // GenKeyPair generates a keypair for the given parameters.
 func GenKeyPair(n, rtH, chanH uint32, c uint16, w uint16) (*PrivateKey, *PublicKey, error) {
 	return GenerateKeyPair(InitParam(n, rtH, chanH, 0, c, w), 0)
@@ -15,6 +13,9 @@ func (sk *PrivateKey) AddChannel() (uint32, *RootSignature, error) {
 
 // VerifyChannel verifies that a channel is signed by a certain PublicKey.
 func (pk *PublicKey) VerifyChannel(rt *RootSignature) (bool, error) {
+	if err := pk.checkSignature(rt); err != nil {
+		return false, err
+	}
 	return pk.VerifyChannelRoot(rt, rt.rootHash)
 }
 
@@ -42,17 +43,25 @@ func (pk *PublicKey) VerifyMsg(sig *MsgSignature, msg, authNode []byte) (
 // Verify is the generic verification function for all signature types.
 // First parameter: signature of any type
 // Second (optional) parameter: message, plus additionally a authentication node as third.
-// Authnod of growsignature and msgsignature should be CurAuthNode of previous signature.
+// Authnod of growsignature, msgsignature and closesignature should be CurAuthNode of previous signature.
 func (pk *PublicKey) Verify(sig Signature, msgAuthNode ...[]byte) (bool, error) {
+	switch sig.(type) {
+	case *MsgSignature, *GrowSignature, *CloseSignature:
+		if len(msgAuthNode) < 2 {
+			return false, verifyError(ErrMissingAuthNode, "no authentication node for the %T", sig)
+		}
+	}
 	switch t := sig.(type) {
 	case *RootSignature:
-		return pk.VerifyChannel(sig.(*RootSignature))
+		return pk.VerifyChannel(t)
 	case *MsgSignature:
-		return pk.VerifyMsg(sig.(*MsgSignature), msgAuthNode[0], msgAuthNode[1])
+		return pk.VerifyMsg(t, msgAuthNode[0], msgAuthNode[1])
 	case *GrowSignature:
-		return pk.VerifyGrow(sig.(*GrowSignature), msgAuthNode[1])
+		return pk.VerifyGrow(t, msgAuthNode[1])
+	case *CloseSignature:
+		return pk.VerifyClose(t, msgAuthNode[1])
 	default:
-		return false, fmt.Errorf("unknown signature type %T", t)
+		return false, verifyError(ErrMalformedSignature, "unknown signature type %T", t)
 	}
 }
 
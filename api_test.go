@@ -46,7 +46,7 @@ func TestMultiChannels(t *testing.T) {
 
 		// Now, we sign 2^chanH times, and verify the signatures in each channel.
 		for j := 0; j < int(chanH)-1; j++ {
-			msg := []byte("Message" + string(j))
+			msg := []byte("Message" + string(rune(j)))
 			sig, err := sk.SignChannelMsg(chIdx, msg)
 			if err != nil {
 				t.Fatalf("Message signing in channel %d failed with error %s\n", chIdx, err)
@@ -81,7 +81,7 @@ func TestMultiChannels(t *testing.T) {
 		authNode = gs.NextAuthNode()
 		// We have new keys to sign, lets use them!
 		for h := 0; h < int(chanH-1); h++ {
-			msg := []byte("Message after growth" + string(h))
+			msg := []byte("Message after growth" + string(rune(h)))
 			sig, err := sk.SignChannelMsg(chIdx, msg)
 			if err != nil {
 				t.Fatalf("Message signing in channel %d failed with error %s\n", chIdx, err)
@@ -149,7 +149,7 @@ func TestSignStoreVerify(t *testing.T) {
 
 		// Lets sign chanH-1 messages in each channel and add it to its respective blocks.
 		for j := 0; j < int(chanH-1); j++ {
-			msg := []byte("Message in channel" + string(chIdx))
+			msg := []byte("Message in channel" + string(rune(chIdx)))
 			msgSig, err := sk.SignMsg(chIdx, msg)
 			if err != nil {
 				t.Fatalf("Signing message %d in channel %d failed with error %s\n", j, chIdx, err)
@@ -165,7 +165,7 @@ func TestSignStoreVerify(t *testing.T) {
 
 		// Lets add a few more message siganture to test.
 		for k := 0; k < int(chanH-1); k++ {
-			msg := []byte("Message in channel" + string(chIdx))
+			msg := []byte("Message in channel" + string(rune(chIdx)))
 			msgSig, err := sk.SignMsg(chIdx, msg)
 			if err != nil {
 				t.Fatalf("Signing message %d in channel %d failed with error %s\n", k, chIdx, err)
@@ -189,7 +189,7 @@ func TestSignStoreVerify(t *testing.T) {
 		for j := 0; j < int(len(curChan.blocks)); j++ {
 			// Current Signature block
 			curSig := curChan.blocks[j]
-			curMsg := []byte("Message in channel" + string(i))
+			curMsg := []byte("Message in channel" + string(rune(i)))
 			acceptMsg, err := pk.Verify(curSig, curMsg, nextAuthNode)
 			if err != nil {
 				t.Fatalf("Message verification in channel %d failed with error %s", i+1, err)
@@ -0,0 +1,210 @@
+package mbpqs
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+/* A ChannelVerifier checks the signatures of a channel one after another, as
+ * each is verified against the authentication node of the previous one. That
+ * node is carried by the previous signature itself, see NextAuthNode, so the
+ * node of every link in a known history can be derived without verifying
+ * anything. AuditChannel does so in a cheap sequential pass, which also checks
+ * the order of the signatures like a ChannelVerifier, and then verifies all
+ * links in parallel. A link only proves something if the links before it hold,
+ * so the first broken link is reported, and the links after it are not
+ * verified.
+ */
+
+// HistoryItem is a signature in the history of a channel, with the message
+// it signs if it is a MsgSignature.
+type HistoryItem struct {
+	Sig Signature
+	Msg []byte
+}
+
+// BrokenLink is the first signature in a channel history which is rejected.
+type BrokenLink struct {
+	Index int            // The position of the signature in the history.
+	SeqNo SignatureSeqNo // The channel seqNo at the signature.
+	Layer uint32         // The chain tree of the channel at the signature.
+	Err   error          // Why the signature is rejected.
+}
+
+func (bl *BrokenLink) String() string {
+	return fmt.Sprintf("signature %d at seqNo %d in chain tree %d: %s", bl.Index, bl.SeqNo, bl.Layer, bl.Err)
+}
+
+// A link in a channel history: the authentication node its signature is
+// verified against, and the position of the channel at it.
+type historyLink struct {
+	authNode []byte
+	seqNo    SignatureSeqNo
+	layer    uint32
+}
+
+// AuditChannel verifies the history of channel chIdx, which starts with its
+// RootSignature and holds the later signatures in the order they were
+// created. The signatures are verified in parallel on the threads of the
+// PublicKey. Returns the first signature which is rejected, or nil if the
+// whole history is accepted.
+func (pk *PublicKey) AuditChannel(chIdx uint32, history []HistoryItem) *BrokenLink {
+	links, broken := pk.historyLinks(chIdx, history)
+
+	// Verify the links before the first one which is out of order. Links
+	// after a broken one are skipped, as they are not reported anyway.
+	first := len(links)
+	errs := make([]error, len(links))
+	mux := &sync.Mutex{}
+	pk.ctx.forEachParallel(len(links), func(pad scratchPad, i int) {
+		mux.Lock()
+		skip := i > first
+		mux.Unlock()
+		if skip {
+			return
+		}
+		if err := pk.verifyLink(pad, history[i], links[i].authNode); err != nil {
+			mux.Lock()
+			errs[i] = err
+			if i < first {
+				first = i
+			}
+			mux.Unlock()
+		}
+	})
+	if first < len(links) {
+		err := errs[first]
+		// The first link after the RootSignature checks their pairing.
+		if first == 1 {
+			err = firstTreeError(history[0].Sig.(*RootSignature), chIdx, err)
+		}
+		return &BrokenLink{Index: first, SeqNo: links[first].seqNo, Layer: links[first].layer, Err: err}
+	}
+	return broken
+}
+
+// Derives the links of the channel history, and checks the order of its
+// signatures like a ChannelVerifier. Returns the links up to the first
+// signature which is out of order, and that signature as BrokenLink.
+func (pk *PublicKey) historyLinks(chIdx uint32, history []HistoryItem) ([]historyLink, *BrokenLink) {
+	if len(history) == 0 {
+		return nil, &BrokenLink{Err: verifyError(ErrMalformedSignature, "the history has no RootSignature")}
+	}
+	links := make([]historyLink, 0, len(history))
+	var authNode []byte
+	var layer, chainSeqNo uint32
+	var seqNo SignatureSeqNo
+	closed := false
+	for i, item := range history {
+		broken := func(err error) ([]historyLink, *BrokenLink) {
+			return links, &BrokenLink{Index: i, SeqNo: seqNo, Layer: layer, Err: err}
+		}
+		link := historyLink{authNode: authNode, seqNo: seqNo, layer: layer}
+		if err := pk.checkSignature(item.Sig); err != nil {
+			return broken(err)
+		}
+		if i == 0 {
+			rtSig, ok := item.Sig.(*RootSignature)
+			if !ok {
+				return broken(verifyError(ErrOutOfOrder, "history starts with a %T instead of the RootSignature", item.Sig))
+			}
+			if err := checkRootLeaf(rtSig, chIdx); err != nil {
+				return broken(err)
+			}
+			links = append(links, link)
+			authNode, layer = rtSig.GetSignedRoot(), 1
+			continue
+		}
+		if closed {
+			return broken(verifyError(ErrChannelClosed, "channel %d is closed at seqNo %d", chIdx, seqNo))
+		}
+		var sigChIdx, sigLayer, sigChainSeqNo uint32
+		switch sig := item.Sig.(type) {
+		case *MsgSignature:
+			sigChIdx, sigLayer, sigChainSeqNo = sig.chIdx, sig.layer, sig.chainSeqNo
+		case *GrowSignature:
+			sigChIdx, sigLayer, sigChainSeqNo = sig.chIdx, sig.layer, sig.chainSeqNo
+		case *CloseSignature:
+			sigChIdx, sigLayer, sigChainSeqNo = sig.chIdx, sig.layer, sig.chainSeqNo
+		default:
+			return broken(verifyError(ErrOutOfOrder, "unexpected %T in the history", item.Sig))
+		}
+		if sigChIdx != chIdx {
+			return broken(verifyError(ErrWrongChannel, "signature is for channel %d instead of %d", sigChIdx, chIdx))
+		}
+		if sigLayer != layer {
+			return broken(verifyError(ErrWrongLayer, "signature is from chain tree %d, but the channel is at chain tree %d", sigLayer, layer))
+		}
+		if sigChainSeqNo != chainSeqNo {
+			return broken(verifyError(ErrOutOfOrder, "signature has chainSeqNo %d, but %d is expected", sigChainSeqNo, chainSeqNo))
+		}
+		lastKey := pk.ctx.chainTreeHeight(layer) - 1
+		switch sig := item.Sig.(type) {
+		case *MsgSignature:
+			if chainSeqNo == lastKey {
+				return broken(verifyError(ErrOutOfOrder, "the last key of chain tree %d can only sign a GrowSignature", layer))
+			}
+			if sig.seqNo != seqNo {
+				return broken(verifyError(ErrOutOfOrder, "signature has seqNo %d, but %d is expected", sig.seqNo, seqNo))
+			}
+			authNode = sig.NextAuthNode(authNode)
+			chainSeqNo++
+			seqNo++
+		case *GrowSignature:
+			if chainSeqNo != lastKey {
+				return broken(verifyError(ErrOutOfOrder, "chain tree %d is grown before all its keys are used", layer))
+			}
+			authNode = sig.NextAuthNode()
+			layer++
+			chainSeqNo = 0
+		case *CloseSignature:
+			if sig.seqNo != seqNo {
+				return broken(verifyError(ErrOutOfOrder, "channel is closed at seqNo %d, but %d is expected", sig.seqNo, seqNo))
+			}
+			authNode = nil
+			closed = true
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// Returns the Context of the signature, or nil if it has none.
+func signatureContext(sig Signature) *Context {
+	switch sig := sig.(type) {
+	case *RootSignature:
+		if sig != nil {
+			return sig.ctx
+		}
+	case *MsgSignature:
+		if sig != nil {
+			return sig.ctx
+		}
+	case *GrowSignature:
+		if sig != nil {
+			return sig.ctx
+		}
+	case *CloseSignature:
+		if sig != nil {
+			return sig.ctx
+		}
+	}
+	return nil
+}
+
+// Verifies the signature of a link in a channel history against authNode.
+func (pk *PublicKey) verifyLink(pad scratchPad, item HistoryItem, authNode []byte) error {
+	var err error
+	switch sig := item.Sig.(type) {
+	case *RootSignature:
+		_, err = pk.VerifyChannelRoot(sig, sig.rootHash)
+	case *MsgSignature:
+		_, err = pk.verifyMsgSig(pad, sig, bytes.NewReader(item.Msg), authNode)
+	case *GrowSignature:
+		err = pk.verifyGrowSig(pad, sig, authNode)
+	case *CloseSignature:
+		_, err = pk.VerifyClose(sig, authNode)
+	}
+	return err
+}
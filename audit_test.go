@@ -0,0 +1,94 @@
+package mbpqs
+
+import (
+	"errors"
+	"testing"
+)
+
+// Returns the history of a channel with signatures in three chain trees,
+// which ends with a CloseSignature.
+func channelHistory(t *testing.T, sk *PrivateKey) []HistoryItem {
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	history := []HistoryItem{{Sig: rtSig}}
+	for i := 0; i < 6; i++ {
+		msg := []byte{'B', byte(i)}
+		sig, err := sk.SignChannelMsgAutoGrow(chIdx, msg)
+		if err != nil {
+			t.Fatalf("Signing message failed with error %s", err)
+		}
+		if sig.Grow != nil {
+			history = append(history, HistoryItem{Sig: sig.Grow})
+		}
+		history = append(history, HistoryItem{Sig: sig.Msg, Msg: msg})
+	}
+	closeSig, err := sk.CloseChannel(chIdx)
+	if err != nil {
+		t.Fatalf("Closing channel failed with error %s", err)
+	}
+	return append(history, HistoryItem{Sig: closeSig})
+}
+
+func TestAuditChannel(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 3, 1, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	history := channelHistory(t, sk)
+	// Root, 2 messages, grow, 3 messages, grow, a message and the closure.
+	if len(history) != 10 {
+		t.Fatalf("History has %d signatures", len(history))
+	}
+	for _, threads := range []int{1, 0} {
+		pk.ctx.threads = threads
+		if bl := pk.AuditChannel(0, history); bl != nil {
+			t.Fatalf("Correct history is rejected at %s", bl)
+		}
+	}
+
+	// A forged message in chain tree 2, and a forged one after it.
+	forged := append([]HistoryItem{}, history...)
+	forged[5].Msg = []byte("Forged block")
+	forged[8].Msg = []byte("Forged block")
+	bl := pk.AuditChannel(0, forged)
+	if bl == nil || bl.Index != 5 || bl.SeqNo != 3 || bl.Layer != 2 {
+		t.Fatalf("Forged history is rejected at %v", bl)
+	}
+
+	// A missing signature breaks the order.
+	skipped := append(append([]HistoryItem{}, history[:2]...), history[3:]...)
+	if bl = pk.AuditChannel(0, skipped); bl == nil || bl.Index != 2 || bl.SeqNo != 1 {
+		t.Fatalf("History with a missing signature is rejected at %v", bl)
+	}
+	// A forged link before the order breaks is reported first.
+	skipped[1].Msg = []byte("Forged block")
+	if bl = pk.AuditChannel(0, skipped); bl == nil || bl.Index != 1 {
+		t.Fatalf("History with a forged and a missing signature is rejected at %v", bl)
+	}
+	// The RootSignature of channel 0 can not sign channel 1.
+	if bl = pk.AuditChannel(1, history); bl == nil || bl.Index != 0 || !errors.Is(bl.Err, ErrWrongChannel) {
+		t.Fatalf("History of another channel is rejected at %v", bl)
+	}
+	if bl = pk.AuditChannel(0, append(history[:len(history):len(history)], history[1])); bl == nil || bl.Index != 10 {
+		t.Fatalf("History with a signature after the closure is rejected at %v", bl)
+	}
+	if bl = pk.AuditChannel(0, history[1:]); bl == nil || bl.Index != 0 {
+		t.Fatalf("History without RootSignature is rejected at %v", bl)
+	}
+	if bl = pk.AuditChannel(0, nil); bl == nil {
+		t.Fatal("Empty history is accepted")
+	}
+
+	// The RootSignature of another channel, before or after it.
+	other := channelHistory(t, sk)
+	mixed := append([]HistoryItem{other[0]}, history[1:]...)
+	if bl = pk.AuditChannel(0, mixed); bl == nil || bl.Index != 1 || !errors.Is(bl.Err, ErrWrongChannel) {
+		t.Fatalf("History with the RootSignature of a later channel is rejected at %v", bl)
+	}
+	mixed = append([]HistoryItem{history[0]}, other[1:]...)
+	if bl = pk.AuditChannel(1, mixed); bl == nil || bl.Index != 0 || !errors.Is(bl.Err, ErrWrongChannel) {
+		t.Fatalf("History with the RootSignature of an earlier channel is rejected at %v", bl)
+	}
+}
@@ -0,0 +1,76 @@
+package mbpqs
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+)
+
+// BatchItem is a MsgSignature to verify with BatchVerify, with the message
+// it signs and the authentication node of the previous signature in its
+// channel.
+type BatchItem struct {
+	Sig      *MsgSignature
+	Msg      []byte
+	AuthNode []byte
+}
+
+// BatchResult is the result of verifying a BatchItem.
+type BatchResult struct {
+	Valid bool  // Whether the signature is accepted.
+	Err   error // Why the signature is rejected, if it is.
+}
+
+// Calls fn for every index below count, spread over the threads of the
+// Context. Every thread has its own scratchpad, which fn may use.
+func (ctx *Context) forEachParallel(count int, fn func(pad scratchPad, i int)) {
+	threads := ctx.threads
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+	if threads > count {
+		threads = count
+	}
+	if threads <= 1 {
+		pad := ctx.newScratchPad()
+		for i := 0; i < count; i++ {
+			fn(pad, i)
+		}
+		return
+	}
+
+	wg := &sync.WaitGroup{}
+	mux := &sync.Mutex{}
+	next := 0
+	wg.Add(threads)
+	for t := 0; t < threads; t++ {
+		go func() {
+			pad := ctx.newScratchPad()
+			for {
+				mux.Lock()
+				i := next
+				next++
+				mux.Unlock()
+				if i >= count {
+					break
+				}
+				fn(pad, i)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+}
+
+// BatchVerify verifies the MsgSignatures of items, which may belong to
+// different channels, in parallel on the threads of the PublicKey. Returns
+// the result of every item, in the order of items.
+func (pk *PublicKey) BatchVerify(items []BatchItem) []BatchResult {
+	results := make([]BatchResult, len(items))
+	pk.ctx.forEachParallel(len(items), func(pad scratchPad, i int) {
+		item := &items[i]
+		_, err := pk.verifyMsgSig(pad, item.Sig, bytes.NewReader(item.Msg), item.AuthNode)
+		results[i] = BatchResult{Valid: err == nil, Err: err}
+	})
+	return results
+}
@@ -0,0 +1,76 @@
+package mbpqs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Signs msgs messages in each of chans channels, and returns them as BatchItems.
+func batchItems(t testing.TB, sk *PrivateKey, chans, msgs int) []BatchItem {
+	var items []BatchItem
+	for c := 0; c < chans; c++ {
+		chIdx, rtSig, err := sk.AddChannel()
+		if err != nil {
+			t.Fatalf("Adding channel failed with error %s", err)
+		}
+		authNode := rtSig.NextAuthNode()
+		for m := 0; m < msgs; m++ {
+			msg := []byte(fmt.Sprintf("Block %d in channel %d", m, chIdx))
+			sig, err := sk.SignMsg(chIdx, msg)
+			if err != nil {
+				t.Fatalf("Signing message failed with error %s", err)
+			}
+			items = append(items, BatchItem{Sig: sig, Msg: msg, AuthNode: authNode})
+			authNode = sig.NextAuthNode(authNode)
+		}
+	}
+	return items
+}
+
+func TestBatchVerify(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 6, 0, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	items := batchItems(t, sk, 3, 5)
+	items[4].Msg = []byte("Forged block")
+	items[7].AuthNode = items[8].AuthNode
+	items[9].Sig = nil
+	other, _, err := GenerateKeyPair(InitParam(32, 2, 6, 0, 0, 16), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	items = append(items, batchItems(t, other, 1, 1)...)
+
+	for _, threads := range []int{1, 0} {
+		pk.ctx.threads = threads
+		results := pk.BatchVerify(items)
+		if len(results) != len(items) {
+			t.Fatalf("%d results for %d items", len(results), len(items))
+		}
+		for i, r := range results {
+			switch i {
+			case 4, 7:
+				if r.Valid || !errors.Is(r.Err, ErrInvalidSignature) {
+					t.Fatalf("Invalid item %d gave result %+v", i, r)
+				}
+			case 9:
+				if r.Valid || !errors.Is(r.Err, ErrMalformedSignature) {
+					t.Fatalf("Missing signature gave result %+v", r)
+				}
+			case 15:
+				if r.Valid || !errors.Is(r.Err, ErrParamsMismatch) {
+					t.Fatalf("Signature with other parameters gave result %+v", r)
+				}
+			default:
+				if !r.Valid || r.Err != nil {
+					t.Fatalf("Correct item %d gave result %+v", i, r)
+				}
+			}
+		}
+	}
+	if results := pk.BatchVerify(nil); len(results) != 0 {
+		t.Fatal("Empty batch gave results")
+	}
+}
@@ -129,6 +129,30 @@ func BenchmarkSignMsg(b *testing.B) {
 	}
 }
 
+// Benchmark message signing in a large channel for different values of c,
+// reporting the size of the internal node cache of the channel.
+func BenchmarkSignMsgCache(b *testing.B) {
+	var h uint32 = 1000
+	cCases := []uint16{0, 1, 2, 4, 8, 16, 32, 64, 128, 256}
+	if testing.Short() {
+		h = 100
+		cCases = []uint16{0, 1, 4, 16, 64}
+	}
+
+	for _, c := range cCases {
+		name := "c" + fmt.Sprint(c) + "-h" + fmt.Sprint(h)
+		b.Run(name, func(b *testing.B) {
+			benchmarkSignMsg(h, c, 16, b)
+			p := InitParam(32, 2, h, 0, c, 16)
+			ctx, err := newContext(p)
+			if err != nil {
+				b.Fatal("Creating context failed with error:", err)
+			}
+			b.ReportMetric(float64(ctx.chainTreeCacheSize(1)), "cache-B")
+		})
+	}
+}
+
 func BenchmarkVerification(b *testing.B) {
 	wCases := []uint16{4, 16}
 	cCases := []uint16{0}
@@ -203,3 +227,31 @@ func benchmarkHashMessage(b *testing.B) {
 func BenchmarkHashMessage(b *testing.B) {
 	benchmarkHashMessage(b)
 }
+
+// Benchmark verifying a backlog of 64 message signatures in 4 channels, one
+// at a time and with BatchVerify.
+func BenchmarkBatchVerify(b *testing.B) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 17, 0, 0, 16), 0)
+	if err != nil {
+		b.Fatal("Generating key pair failed with error: ", err)
+	}
+	items := batchItems(b, sk, 4, 16)
+	b.Run("Sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, item := range items {
+				if accept, err := pk.VerifyChannelMsg(item.Sig, item.Msg, item.AuthNode); !accept || err != nil {
+					b.Fatal("Correct signature not accepted: ", err)
+				}
+			}
+		}
+	})
+	b.Run("Batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, r := range pk.BatchVerify(items) {
+				if !r.Valid {
+					b.Fatal("Correct signature not accepted: ", r.Err)
+				}
+			}
+		}
+	})
+}
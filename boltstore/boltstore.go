@@ -0,0 +1,164 @@
+// Package boltstore keeps the state of an mbpqs.PrivateKey in a bbolt
+// database, see mbpqs.StateStore.
+package boltstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/Breus/mbpqs"
+	bolt "go.etcd.io/bbolt"
+)
+
+/* The state is kept in the bucket "mbpqs":
+ *
+ *   key               the KeyState without its channels, see KeyState.MarshalBinary
+ *   channels          #channels
+ *   channel || chIdx  layer || chainSeqNo || seqNo || closed || cache
+ *
+ * Save replaces the state in a single transaction, which bbolt syncs to disk
+ * before it returns, and only writes the values which changed: signing in a
+ * channel only rewrites its entry and leaves the caches of the other
+ * channels, the seeds and the root tree alone.
+ */
+
+// The bucket holding the state, and its keys.
+var (
+	bucket      = []byte("mbpqs")
+	keyKey      = []byte("key")
+	keyChannels = []byte("channels")
+	keyChannel  = []byte("channel")
+)
+
+// Store is an mbpqs.StateStore which keeps the state in a bbolt database.
+// The database holds the seeds unencrypted, so it should only be readable
+// by its owner.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the bbolt database at path as Store, and creates it if it does
+// not exist. The database is locked until Close, such that no other process
+// can open it.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening state store %s failed: %s", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database of the Store.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Returns the key of the entry of channel chIdx.
+func channelKey(chIdx uint32) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte{}, keyChannel...), chIdx)
+}
+
+// Stores value at key in bucket b, unless it holds value already.
+func put(b *bolt.Bucket, key, value []byte) error {
+	if bytes.Equal(b.Get(key), value) {
+		return nil
+	}
+	return b.Put(key, value)
+}
+
+// Save replaces the state in the database by st, in a single transaction.
+func (s *Store) Save(st *mbpqs.KeyState) error {
+	withoutChannels := *st
+	withoutChannels.Channels = nil
+	key, err := withoutChannels.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	defer clear(key)
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		if err = put(b, keyKey, key); err != nil {
+			return err
+		}
+		old := uint32(0)
+		if v := b.Get(keyChannels); len(v) == 4 {
+			old = binary.BigEndian.Uint32(v)
+		}
+		count := binary.BigEndian.AppendUint32(nil, uint32(len(st.Channels)))
+		if err = put(b, keyChannels, count); err != nil {
+			return err
+		}
+		for chIdx, cs := range st.Channels {
+			v := make([]byte, 13, 13+len(cs.Cache))
+			binary.BigEndian.PutUint32(v[0:4], cs.Layer)
+			binary.BigEndian.PutUint32(v[4:8], cs.ChainSeqNo)
+			binary.BigEndian.PutUint32(v[8:12], uint32(cs.SeqNo))
+			if cs.Closed {
+				v[12] = 1
+			}
+			if err = put(b, channelKey(uint32(chIdx)), append(v, cs.Cache...)); err != nil {
+				return err
+			}
+		}
+		for chIdx := uint32(len(st.Channels)); chIdx < old; chIdx++ {
+			if err = b.Delete(channelKey(chIdx)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Load returns the state in the database, or nil if it holds none.
+func (s *Store) Load() (*mbpqs.KeyState, error) {
+	var st *mbpqs.KeyState
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return nil
+		}
+		// UnmarshalBinary copies the seeds out of the database.
+		st = new(mbpqs.KeyState)
+		if err := st.UnmarshalBinary(b.Get(keyKey)); err != nil {
+			return err
+		}
+		count := b.Get(keyChannels)
+		if len(count) != 4 {
+			return fmt.Errorf("the amount of channels is missing")
+		}
+		nChannels := binary.BigEndian.Uint32(count)
+		// The channels are appended as they are read, such that a damaged
+		// amount fails at the first missing channel.
+		for chIdx := uint32(0); chIdx < nChannels; chIdx++ {
+			v := b.Get(channelKey(chIdx))
+			if len(v) < 13 {
+				return fmt.Errorf("the state of channel %d is missing", chIdx)
+			}
+			cs := mbpqs.ChannelState{
+				Layer:      binary.BigEndian.Uint32(v[0:4]),
+				ChainSeqNo: binary.BigEndian.Uint32(v[4:8]),
+				SeqNo:      mbpqs.SignatureSeqNo(binary.BigEndian.Uint32(v[8:12])),
+				Closed:     v[12] != 0,
+			}
+			if len(v) > 13 {
+				cs.Cache = append([]byte{}, v[13:]...)
+			}
+			st.Channels = append(st.Channels, cs)
+		}
+		return nil
+	})
+	if err != nil {
+		if st != nil {
+			clear(st.SkSeed)
+			clear(st.SkPrf)
+		}
+		return nil, fmt.Errorf("state store %s: %s", s.db.Path(), err)
+	}
+	return st, nil
+}
@@ -0,0 +1,117 @@
+package boltstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/Breus/mbpqs"
+)
+
+// Signs a message in channel chIdx of sk and verifies it with cv.
+func signAndVerify(t *testing.T, sk *mbpqs.PrivateKey, cv *mbpqs.ChannelVerifier, chIdx uint32) {
+	msg := []byte("Message in a channel kept in bbolt")
+	sig, err := sk.SignMsg(chIdx, msg)
+	if err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	if accept, err := cv.VerifyMsg(sig, msg); !accept || err != nil {
+		t.Fatalf("Correct MsgSignature not accepted: %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orderer.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Opening state store failed with error %s", err)
+	}
+	if st, err := s.Load(); st != nil || err != nil {
+		t.Fatalf("Empty store holds %v, %v", st, err)
+	}
+	sk, pk, err := mbpqs.GenerateKeyPair(mbpqs.InitParam(32, 2, 2, 1, 1, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	if err = sk.PersistTo(s); err != nil {
+		t.Fatalf("Persisting key failed with error %s", err)
+	}
+	if err = sk.PersistTo(s); err == nil {
+		t.Fatal("Persisting to a store which holds a key did not give an error")
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+	if err != nil {
+		t.Fatalf("Verifying RootSignature failed with error %s", err)
+	}
+	if _, _, err = sk.AddChannel(); err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	signAndVerify(t, sk, cv, chIdx)
+	growSig, err := sk.GrowChannel(chIdx)
+	if err != nil {
+		t.Fatalf("Growing channel failed with error %s", err)
+	}
+	if accept, err := cv.VerifyGrow(growSig); !accept || err != nil {
+		t.Fatalf("Correct GrowSignature not accepted: %v", err)
+	}
+	if _, err = sk.CloseChannel(1); err != nil {
+		t.Fatalf("Closing channel failed with error %s", err)
+	}
+
+	// Saving the same state again leaves the database as it is.
+	before, err := s.Load()
+	if err != nil {
+		t.Fatalf("Loading state failed with error %s", err)
+	}
+	if err = s.Save(before); err != nil {
+		t.Fatalf("Saving state failed with error %s", err)
+	}
+	after, err := s.Load()
+	if err != nil {
+		t.Fatalf("Loading state failed with error %s", err)
+	}
+	a, _ := before.MarshalBinary()
+	b, _ := after.MarshalBinary()
+	if !bytes.Equal(a, b) {
+		t.Fatal("Saving and loading changes the state")
+	}
+	if err = s.Close(); err != nil {
+		t.Fatalf("Closing state store failed with error %s", err)
+	}
+
+	// Restart: the state survives reopening the database, and the channel
+	// continues in its second chain tree.
+	if s, err = Open(path); err != nil {
+		t.Fatalf("Reopening state store failed with error %s", err)
+	}
+	defer s.Close()
+	if _, err = Open(path); err == nil {
+		t.Fatal("Opening a state store which is open did not give an error")
+	}
+	sk, err = mbpqs.LoadPrivateKeyFrom(s, 0)
+	if err != nil {
+		t.Fatalf("Loading key failed with error %s", err)
+	}
+	st := sk.Status()
+	if len(st.Channels) != 2 || !st.Channels[1].Closed || st.Channels[0].Layer != 2 {
+		t.Fatalf("Reloaded key has state %+v", st)
+	}
+	signAndVerify(t, sk, cv, chIdx)
+	if _, err = sk.SignMsg(1, []byte("Block")); err == nil {
+		t.Fatal("Signing in a closed channel did not give an error")
+	}
+	_, rtSig, err = sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	if accept, err := pk.VerifyChannel(rtSig); !accept || err != nil {
+		t.Fatalf("RootSignature of the reloaded key not accepted: %v", err)
+	}
+	if loaded, err := s.Load(); err != nil || len(loaded.Channels) != 3 {
+		t.Fatalf("Store holds %v, %v after adding a channel", loaded, err)
+	}
+}
@@ -21,10 +21,14 @@ import (
 
 	The buf array is structered as follows:
 	[(0,0),(0,1),(1,0)(1,1),(...),(t-2,0)(t-2,1),(t-1,0)]
+
+	A partial chain tree starts at a (cached) node N(b,0), and its buf array is:
+	[(b,0),(b,1),(b+1,0),(b+1,1),(...),(t-1,0)]
 */
 
 type chainTree struct {
 	height uint32
+	base   uint32 // Height of the lowest node in buf.
 	n      uint32
 	buf    []byte
 }
@@ -54,10 +58,21 @@ func (sk *PrivateKey) genChainTreeTill(pad scratchPad, chIdx, chLayer, till uint
 	return ct
 }
 
+// Allocates a partial chain tree on top of the node baseNode = N(base,0), and
+// returns it in memory. Only the leafs above height base are generated.
+// Chain-tree size: (2*(till-base)+1)n
+func (sk *PrivateKey) genChainTreeFromTill(pad scratchPad, chIdx, chLayer, base uint32, baseNode []byte, till uint32) chainTree {
+	ct := newPartialChainTree(base, till+1, sk.ctx.params.n)
+	copy(ct.node(base, 0), baseNode)
+	sk.genChainTreeInto(pad, chIdx, chLayer, till, ct)
+	return ct
+}
+
 // Generates a chain tree into ct.
 // Chaintree size = (2*till+1)n
 // Chaintree height = till+1
 // Till is highest = highest height you want to have
+// If ct is a partial chain tree, its base node should already be set.
 func (sk *PrivateKey) genChainTreeInto(pad scratchPad, chIdx, chLayer, till uint32, ct chainTree) {
 	// Init addresses for OTS, LTree nodes, and Tree nodes.
 	var otsAddr, lTreeAddr, nodeAddr address
@@ -72,12 +87,17 @@ func (sk *PrivateKey) genChainTreeInto(pad scratchPad, chIdx, chLayer, till uint
 	lTreeAddr.setType(lTreeAddrType)
 	nodeAddr.setSubTreeFrom(addr)
 	nodeAddr.setType(treeAddrType)
-	// First, compute the leafs of the chain tree.
-	var idx uint32
+	// First, compute the leafs of the chain tree. The leaf at index 0 is
+	// the base node of a full chain tree, a partial chain tree above it has
+	// its base node set already.
+	idx := ct.base
+	if ct.base > 0 {
+		idx++
+	}
 	cH := sk.ctx.chainTreeHeight(chLayer)
 	if sk.ctx.threads == 1 {
 		// No. leafs == height of the chain tree.
-		for idx = 0; idx <= till; idx++ {
+		for ; idx <= till; idx++ {
 			lTreeAddr.setLTree(cH - 1 - idx)
 			otsAddr.setOTS(cH - 1 - idx)
 			copy(sk.leafTill(&ct, idx, till), sk.ctx.genLeaf(pad, sk.ph, lTreeAddr, otsAddr))
@@ -113,6 +133,7 @@ func (sk *PrivateKey) genChainTreeInto(pad scratchPad, chIdx, chLayer, till uint
 						otsAddr))
 
 				}
+				pad.wipe()
 				wg.Done()
 			}(lTreeAddr, otsAddr)
 		}
@@ -122,7 +143,7 @@ func (sk *PrivateKey) genChainTreeInto(pad scratchPad, chIdx, chLayer, till uint
 	// Next, compute the internal nodes and the root node.
 	var height uint32
 	// Looping through all the layers of the chainTree.
-	for height = 1; height <= till; height++ {
+	for height = ct.base + 1; height <= till; height++ {
 		// Set tree height of the computed node.
 		nodeAddr.setTreeHeight(height - 1)
 		// Internal nodes and root node have Treeindex 0.
@@ -142,7 +163,7 @@ func (sk *PrivateKey) leafTill(ct *chainTree, idx, till uint32) []byte {
 
 // Returns a slice of the node at given height and index idx in the chain tree.
 func (ct *chainTree) node(height, idx uint32) []byte {
-	ptr := ct.n * (2*height + idx)
+	ptr := ct.n * (2*(height-ct.base) + idx)
 	return ct.buf[ptr : ptr+ct.n]
 }
 
@@ -156,6 +177,15 @@ func newChainTree(height, n uint32) chainTree {
 	return chainTreeFromBuf(make([]byte, (2*height-1)*n), height, n)
 }
 
+// Allocates memory for a partial chain tree of n-byte strings from height
+// base up to height-1.
+func newPartialChainTree(base, height, n uint32) chainTree {
+	ct := newChainTree(height-base, n)
+	ct.height = height
+	ct.base = base
+	return ct
+}
+
 // Makes a chain tree from a buffer.
 func chainTreeFromBuf(buf []byte, height, n uint32) chainTree {
 	return chainTree{
@@ -165,19 +195,80 @@ func chainTreeFromBuf(buf []byte, height, n uint32) chainTree {
 	}
 }
 
+/* If c > 0, the PrivateKey caches every c-th node N(h,0) on the spine of the
+ * current chain tree of each channel, such that signing only has to recompute
+ * the span between the authentication node and the cached node below it.
+ * For a chain tree of height h, cache entry j holds N(h-1-c(j+1), 0), for
+ * j = 0, ..., (h-1)/c-1. The lowest cached node is thus N((h-1)%c, 0).
+ */
+
+// Returns the internal node cache of chain tree ct at layer chLayer.
+func (ctx *Context) chainTreeCache(ct chainTree, chLayer uint32) []byte {
+	c := uint32(ctx.params.c)
+	if c == 0 {
+		return nil
+	}
+	h := ctx.chainTreeHeight(chLayer)
+	n := ctx.params.n
+	cache := make([]byte, ctx.chainTreeCacheSize(chLayer))
+	for j := uint32(0); j < (h-1)/c; j++ {
+		copy(cache[j*n:(j+1)*n], ct.node(h-1-c*(j+1), 0))
+	}
+	return cache
+}
+
+// Returns the size in bytes of the internal node cache of a chain tree at layer chLayer.
+func (ctx *Context) chainTreeCacheSize(chLayer uint32) uint32 {
+	c := uint32(ctx.params.c)
+	if c == 0 {
+		return 0
+	}
+	return ctx.params.n * ((ctx.chainTreeHeight(chLayer) - 1) / c)
+}
+
+// Computes the node N(height,0) of the chain tree at layer chLayer, from the
+// closest node below it in the internal node cache of the chain tree.
+// At most c leafs are generated.
+func (sk *PrivateKey) cachedChainTreeNode(pad scratchPad, cache []byte, chIdx, chLayer, height uint32) []byte {
+	c := uint32(sk.ctx.params.c)
+	n := sk.ctx.params.n
+	h := sk.ctx.chainTreeHeight(chLayer)
+	lowest := (h - 1) % c
+	if height < lowest {
+		// There is no cached node below the node, so start from the first leaf.
+		ct := sk.genChainTreeTill(pad, chIdx, chLayer, height)
+		return ct.node(height, 0)
+	}
+	base := lowest + (height-lowest)/c*c
+	j := (h-1-base)/c - 1
+	baseNode := cache[j*n : (j+1)*n]
+	if base == height {
+		return append([]byte{}, baseNode...)
+	}
+	ct := sk.genChainTreeFromTill(pad, chIdx, chLayer, base, baseNode, height)
+	return ct.node(height, 0)
+}
+
 // Returns the height of a chain tree at layer chainLayer.
 func (ctx *Context) chainTreeHeight(chainLayer uint32) uint32 {
 	return ctx.params.chanH + ctx.params.gf*(chainLayer-1)
 }
 
 // ChainSeqNo retrieves the current cahinSeqNo and increases it with one.
-func (sk *PrivateKey) ChainSeqNo(chIdx uint32) uint32 {
+func (sk *PrivateKey) ChainSeqNo(chIdx uint32) (uint32, error) {
 	ch := sk.getChannel(chIdx)
 	ch.mux.Lock()
+	defer ch.mux.Unlock()
+	if ch.chainSeqNo >= sk.ctx.chainTreeHeight(ch.layers) {
+		return 0, fmt.Errorf("the chain tree has no keys left")
+	}
+	// Reserve the key in the key file before it is used, unless it is the
+	// last key of the chain tree, see reserveChannelKey.
+	if err := sk.reserveChannelKey(ch); err != nil {
+		return 0, err
+	}
 	ch.chainSeqNo++
-	ch.mux.Unlock()
-	return ch.chainSeqNo - 1
-
+	return ch.chainSeqNo - 1, nil
 }
 
 // ChannelSeqNos retrieves the current chainSeqNo and the current channelSeqNo.
@@ -186,13 +277,57 @@ func (sk *PrivateKey) ChannelSeqNos(chIdx uint32) (uint32, SignatureSeqNo, error
 	ch.mux.Lock()
 	// Unlock the lock when the function is finished.
 	defer ch.mux.Unlock()
-	ch.chainSeqNo++
+	chainSeqNo, seqNo, err := sk.nextChannelSeqNos(ch)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := sk.useChannelSeqNos(ch, nil); err != nil {
+		return 0, 0, err
+	}
+	return chainSeqNo, seqNo, nil
+}
+
+// Returns the chainSeqNo and the seqNo of the next message signing key in
+// channel ch, without using it. The lock of the channel should be held.
+func (sk *PrivateKey) nextChannelSeqNos(ch *Channel) (uint32, SignatureSeqNo, error) {
+	// Destroy holds the lock of every channel while it sets destroyed.
+	if sk.destroyed {
+		return 0, 0, fmt.Errorf("the private key is destroyed")
+	}
+	if ch.closed {
+		return 0, 0, fmt.Errorf("the channel is closed")
+	}
 	if uint32(ch.seqNo) == ^uint32(0) {
 		return 0, 0, fmt.Errorf("Please use a new key channel, this one has used the maximum of keys (2^32)")
 	}
+	// The last key of the chain tree is reserved to sign the next chain tree.
+	if sk.chainTreeFull(ch) {
+		return 0, 0, fmt.Errorf("please grow the channel before signing new messages in it")
+	}
+	return ch.chainSeqNo, ch.seqNo, nil
+}
+
+// Returns whether the current chain tree of channel ch has no message
+// signing keys left, and should be grown. The lock of the channel should be held.
+func (sk *PrivateKey) chainTreeFull(ch *Channel) bool {
+	return ch.chainSeqNo >= sk.ctx.chainTreeHeight(ch.layers)-1
+}
 
+// Marks the next message signing key in channel ch as used, after reserving
+// it in the key file and recording e in the journal, if e is not nil. The
+// lock of the channel should be held.
+func (sk *PrivateKey) useChannelSeqNos(ch *Channel, e *JournalEntry) error {
+	if err := sk.reserveChannelKey(ch); err != nil {
+		return err
+	}
+	if e != nil {
+		if err := sk.journalKey(*e); err != nil {
+			return err
+		}
+	}
+	ch.chainSeqNo++
 	ch.seqNo++
-	return ch.chainSeqNo - 1, ch.seqNo - 1, nil
+	return nil
 }
 
 // Returns the layer of the current chain in the channel.
@@ -229,79 +364,107 @@ func (sk *PrivateKey) getChannel(chIdx uint32) *Channel {
 // GrowChannel creates a GrowSignature for channel chIdx with the root of the next chainTree embedded.
 func (sk *PrivateKey) growChannel(chIdx uint32) (*GrowSignature, error) {
 	// Returns an error if the channel does not exist.
-	if chIdx > uint32(len(sk.Channels)) {
+	if chIdx >= uint32(len(sk.Channels)) {
 		return nil, fmt.Errorf("channel does not exist, please create it first")
 	}
 
-	// Check if last key of a chaintree is used to sign a new chain tree.
 	ch := sk.getChannel(chIdx)
-	if !(sk.ctx.chainTreeHeight(ch.layers)-1 == uint32(ch.chainSeqNo)) {
+	ch.mux.Lock()
+	defer ch.mux.Unlock()
+	return sk.growChannelLocked(chIdx, ch)
+}
+
+// Grows channel ch with index chIdx, see growChannel.
+// The lock of the channel should be held.
+func (sk *PrivateKey) growChannelLocked(chIdx uint32, ch *Channel) (*GrowSignature, error) {
+	if sk.destroyed {
+		return nil, fmt.Errorf("the private key is destroyed")
+	}
+	if ch.closed {
+		return nil, fmt.Errorf("channel %d is closed", chIdx)
+	}
+	// Check if last key of a chaintree is used to sign a new chain tree.
+	if !sk.chainTreeFull(ch) {
 		return nil, fmt.Errorf("current chainTree hasn't used its full capacity yet")
 	}
+	if ch.chainSeqNo >= sk.ctx.chainTreeHeight(ch.layers) {
+		return nil, fmt.Errorf("channel %d has no key left to grow it with", chIdx)
+	}
 
 	// Compute the new tree, and retrieve its root node.
 	pad := sk.ctx.newScratchPad()
+	defer pad.wipe()
 	ct := sk.genChainTree(pad, chIdx, ch.layers+1)
 
-	// Initialize internal node cache if c > 0.
-	if sk.ctx.params.c > 0 {
-		h := sk.ctx.params.chanH
-		c := uint32(sk.ctx.params.c)
-		n := sk.ctx.params.n
-
-		cacheBuf := make([]byte, n*((h-1)/c))
-
-		//First cache node height .
-		nh := h - 1 - c
-
-		// Fill the cache untill it reaches it's last node.
-		var idx uint32
-		for nh >= (h-1)%c {
-			// Put the node at height nh in the cache.
-			copy(cacheBuf[idx*n:idx*n+n], ct.node(nh, 0))
-			if nh == (h-1)%c {
-				break
-			}
-			// Decrease the node height with c.
-			nh -= c
-			// Increase cacheBuf index counter.
-			idx++
-		}
-		ch.cache = cacheBuf
-	}
+	// Initialize internal node cache of the new chain tree if c > 0.
+	cacheBuf := sk.ctx.chainTreeCache(ct, ch.layers+1)
 
 	ctRoot := ct.getRootNode()
 
-	// Retrieve and update chainSeqNo.
-	chainSeqNo := sk.ChainSeqNo(chIdx)
+	// Retrieve chainSeqNo, the last key of the chain tree, and record it in
+	// the journal. It is not reserved, see reserveChannelKey.
+	chainSeqNo, chLayer := ch.chainSeqNo, ch.layers
+	if err := sk.journalKey(JournalEntry{
+		Kind:   JournalGrow,
+		Key:    OTSKey{Channel: chIdx, Layer: chLayer, Index: chainSeqNo},
+		Digest: ctRoot,
+	}); err != nil {
+		return nil, err
+	}
+
+	// Update the channel information for an additional tree, and save
+	// it to the key file before the signature is released.
+	if err := sk.advanceLayer(ch, cacheBuf); err != nil {
+		return nil, err
+	}
 
 	// Set OTSaddr to calculate the Wots sig over the message.
 	var otsAddr address
 	otsAddr.setOTS(uint32(chainSeqNo))
-	otsAddr.setLayer(ch.layers)
+	otsAddr.setLayer(chLayer)
 	otsAddr.setTree(uint64(chIdx))
 
 	// These fields can only be set after check for required rootSignature is made.
-	sig := &GrowSignature{
+	return &GrowSignature{
 		ctx:        sk.ctx,
 		chainSeqNo: chainSeqNo,
 		chIdx:      chIdx,
-		layer:      ch.layers,
+		layer:      chLayer,
 		wotsSig:    sk.ctx.wotsSign(pad, ctRoot, sk.pubSeed, sk.skSeed, otsAddr),
 		rootHash:   ctRoot,
-	}
-
-	// Update the channel information for an additional tree.
-	ch.layers++
-	ch.chainSeqNo = 0
-	return sig, nil
+	}, nil
 }
 
 // Verify a chainTree root signature, part of the growsignature.
 func (pk *PublicKey) verifyChainTreeRoot(sig *GrowSignature,
 	authNode []byte) (bool, error) {
-	pad := pk.ctx.newScratchPad()
+	if err := pk.verifyGrowSig(pk.ctx.newScratchPad(), sig, authNode); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Verifies the GrowSignature against the authentication node authNode, which
+// is the root of the chain tree it ends.
+func (pk *PublicKey) verifyGrowSig(pad scratchPad, sig *GrowSignature, authNode []byte) error {
+	if err := pk.checkSignature(sig); err != nil {
+		return err
+	}
+	if authNode == nil {
+		return verifyError(ErrMissingAuthNode, "no authentication node for %s", sig)
+	}
+	if sig.chainSeqNo != pk.ctx.chainTreeHeight(sig.layer)-1 {
+		return verifyError(ErrOutOfOrder, "%s is not signed by the last key of its chain tree", sig)
+	}
+	if subtle.ConstantTimeCompare(pk.growSigLeaf(pad, sig), authNode) != 1 {
+		return verifyError(ErrInvalidSignature, "%s does not hash up to the authentication node", sig)
+	}
+	return nil
+}
 
+// Computes the leaf of the key which made the GrowSignature, which is the
+// bottom node N(0,0) of its chain tree.
+func (pk *PublicKey) growSigLeaf(pad scratchPad, sig *GrowSignature) []byte {
 	sta := SubTreeAddress{
 		Layer: sig.layer,
 		Tree:  uint64(sig.chIdx),
@@ -318,11 +481,30 @@ func (pk *PublicKey) verifyChainTreeRoot(sig *GrowSignature,
 	lTreeAddr.setSubTreeFrom(addr)
 	lTreeAddr.setType(lTreeAddrType)
 	lTreeAddr.setLTree(uint32(sig.chainSeqNo))
-	curHash := pk.ctx.lTree(pad, wotsPk, pk.ph, lTreeAddr)
+	return append([]byte{}, pk.ctx.lTree(pad, wotsPk, pk.ph, lTreeAddr)...)
+}
 
-	if subtle.ConstantTimeCompare(curHash, authNode) != 1 {
-		return false, nil
+// Hashes node, which is N(height,0) of chain tree chLayer in channel chIdx, up
+// to the root of the chain tree. Leaves holds the leafs of the keys 0 up to
+// H-2-height of the chain tree, concatenated.
+func (pk *PublicKey) hashChainTreeUp(pad scratchPad, chIdx, chLayer, height uint32, node, leaves []byte) []byte {
+	n := pk.ctx.params.n
+	cH := pk.ctx.chainTreeHeight(chLayer)
+	sta := SubTreeAddress{
+		Layer: chLayer,
+		Tree:  uint64(chIdx),
 	}
-	return true, nil
-
+	var nodeAddr address
+	nodeAddr.setSubTreeFrom(sta.address())
+	nodeAddr.setType(treeAddrType)
+	nodeAddr.setTreeIndex(0)
+
+	// N(h+1,0) = H(N(h,0), N(h,1)), where N(h,1) is the leaf of key H-2-h.
+	ret := append([]byte{}, node...)
+	for h := height; h < cH-1; h++ {
+		key := cH - 2 - h
+		nodeAddr.setTreeHeight(h)
+		pk.ctx.hInto(pad, ret, leaves[key*n:(key+1)*n], pk.ph, nodeAddr, ret)
+	}
+	return ret
 }
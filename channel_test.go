@@ -1,6 +1,7 @@
 package mbpqs
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -24,6 +25,71 @@ func TestPartials(t *testing.T) {
 	var till uint32 = 3
 	ct := newChainTree(till+1, sk.ctx.params.n)
 	sk.genChainTreeInto(sk.ctx.newScratchPad(), 1, 1, till, ct)
-	// ct := sk.genChainTreeFromTill(sk.ctx.newScratchPad(), 1, 1, 0, 1)
+	// A partial chain tree on top of a node of the full chain tree has the same nodes.
+	for base := uint32(0); base < till; base++ {
+		pct := sk.genChainTreeFromTill(sk.ctx.newScratchPad(), 1, 1, base, ct.node(base, 0), till)
+		for h := base + 1; h <= till; h++ {
+			if !bytes.Equal(pct.node(h, 0), ct.node(h, 0)) {
+				t.Fatalf("Partial chain tree from height %d has another node at height %d", base, h)
+			}
+		}
+	}
+}
+
+// Signing with an internal node cache gives the same signatures as without.
+func TestSignWithCache(t *testing.T) {
+	var chanH, gf uint32 = 7, 2
+	skSeed := bytes.Repeat([]byte{1}, 32)
+	skPrf := bytes.Repeat([]byte{2}, 32)
+	pubSeed := bytes.Repeat([]byte{3}, 32)
+	msg := []byte("Block in a cached channel")
 
+	// Sign all messages in the first three chain trees of a channel.
+	signChannel := func(c uint16) []*MsgSignature {
+		sk, pk, err := DeriveKeyPair(InitParam(32, 2, chanH, gf, c, 4), 0, skSeed, skPrf, pubSeed)
+		if err != nil {
+			t.Fatalf("KeyGen with c=%d failed with error %s", c, err)
+		}
+		chIdx, rtSig, err := sk.AddChannel()
+		if err != nil {
+			t.Fatalf("Adding channel with c=%d failed with error %s", c, err)
+		}
+		cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+		if err != nil {
+			t.Fatalf("Creating verifier with c=%d failed with error %s", c, err)
+		}
+		var sigs []*MsgSignature
+		for layer := uint32(1); layer <= 3; layer++ {
+			if got, want := uint32(len(sk.Channels[chIdx].cache)), sk.ctx.chainTreeCacheSize(layer); got != want {
+				t.Fatalf("Cache with c=%d at layer %d has %d bytes instead of %d", c, layer, got, want)
+			}
+			for i := uint32(0); i < sk.ctx.chainTreeHeight(layer)-1; i++ {
+				sig, err := sk.SignChannelMsg(chIdx, msg)
+				if err != nil {
+					t.Fatalf("Signing with c=%d failed with error %s", c, err)
+				}
+				if accept, err := cv.VerifyMsg(sig, msg); !accept || err != nil {
+					t.Fatalf("Signature %d with c=%d not accepted: %v", sig.seqNo, c, err)
+				}
+				sigs = append(sigs, sig)
+			}
+			growSig, err := sk.GrowChannel(chIdx)
+			if err != nil {
+				t.Fatalf("Growing channel with c=%d failed with error %s", c, err)
+			}
+			if accept, err := cv.VerifyGrow(growSig); !accept || err != nil {
+				t.Fatalf("GrowSignature with c=%d not accepted: %v", c, err)
+			}
+		}
+		return sigs
+	}
+
+	expected := signChannel(0)
+	for c := uint16(1); c < uint16(chanH); c++ {
+		for i, sig := range signChannel(c) {
+			if !bytes.Equal(sig.authPath, expected[i].authPath) {
+				t.Fatalf("Signature %d with c=%d has another authentication node than with c=0", i, c)
+			}
+		}
+	}
 }
@@ -0,0 +1,180 @@
+package mbpqs
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+/* A channel is ended with a CloseSignature: the statement "channel chIdx is
+ * closed at seqNo", signed with the next key of the channel. The key is
+ * verified like the one of a MsgSignature, so the statement takes the place
+ * of the next MsgSignature, and verifiers reject every later signature in
+ * the channel. If the current chain tree has no message keys left, its last
+ * key signs the statement instead of the root of the next chain tree.
+ */
+
+// Prefix of the statement signed by a CloseSignature.
+const closeStatementPrefix = "MBPQS channel closed"
+
+// CloseSignature holds the signed statement that a channel is closed.
+type CloseSignature struct {
+	ctx        *Context       // Defines the MBPQS instance which was used to create the signature.
+	seqNo      SignatureSeqNo // The channel is closed at this seqNo: no MsgSignature with it, or later, is valid.
+	wotsSig    []byte         // The WOTS signature over the statement.
+	authPath   []byte         // Authentication path node, unused if the last key of the chain tree signs.
+	chainSeqNo uint32         // Sequence number of the signing key in the used chain tree.
+	chIdx      uint32         // The closed channel.
+	layer      uint32         // From which chainTree layer the key comes.
+}
+
+// NextAuthNode returns nil, as no signature can follow a CloseSignature.
+func (cs *CloseSignature) NextAuthNode(prevAuthNode ...[]byte) []byte {
+	return nil
+}
+
+// SeqNo returns the seqNo the channel is closed at.
+func (cs *CloseSignature) SeqNo() SignatureSeqNo {
+	return cs.seqNo
+}
+
+// String returns a description of the CloseSignature for humans.
+func (cs *CloseSignature) String() string {
+	return fmt.Sprintf("CloseSignature{channel: %d, layer: %d, chainSeqNo: %d, seqNo: %d}",
+		cs.chIdx, cs.layer, cs.chainSeqNo, cs.seqNo)
+}
+
+// Computes the digest of the statement signed by the CloseSignature.
+func (ctx *Context) closeDigest(pad scratchPad, root []byte, chIdx, layer, chainSeqNo uint32, seqNo SignatureSeqNo) ([]byte, error) {
+	stmt := make([]byte, len(closeStatementPrefix)+16)
+	off := copy(stmt, closeStatementPrefix)
+	binary.BigEndian.PutUint32(stmt[off:], chIdx)
+	binary.BigEndian.PutUint32(stmt[off+4:], layer)
+	binary.BigEndian.PutUint32(stmt[off+8:], chainSeqNo)
+	binary.BigEndian.PutUint32(stmt[off+12:], uint32(seqNo))
+	sigIdx := uint64(chIdx)<<32 + uint64(seqNo)
+	// The statement is fixed by the channel state, so it needs no randomness.
+	return ctx.hashMessage(pad, stmt, make([]byte, ctx.params.n), root, sigIdx)
+}
+
+// CloseChannel closes channel chIdx, and returns the CloseSignature which
+// proves this to verifiers. Afterwards, the channel can not sign or grow.
+// The closure is saved to the key file before the signature is released.
+func (sk *PrivateKey) CloseChannel(chIdx uint32) (*CloseSignature, error) {
+	if chIdx >= uint32(len(sk.Channels)) {
+		return nil, fmt.Errorf("channel does not exist, please create it first")
+	}
+	ch := sk.getChannel(chIdx)
+	pad := sk.ctx.newScratchPad()
+	defer pad.wipe()
+
+	ch.mux.Lock()
+	if sk.destroyed {
+		ch.mux.Unlock()
+		return nil, fmt.Errorf("the private key is destroyed")
+	}
+	if ch.closed {
+		ch.mux.Unlock()
+		return nil, fmt.Errorf("channel %d is closed", chIdx)
+	}
+	cH := sk.ctx.chainTreeHeight(ch.layers)
+	if ch.chainSeqNo >= cH {
+		ch.mux.Unlock()
+		return nil, fmt.Errorf("channel %d has no key left to close it with", chIdx)
+	}
+	// Reserve the key, unless it is the last one of the chain tree, and
+	// record it in the journal before the channel is closed.
+	if err := sk.reserveChannelKey(ch); err != nil {
+		ch.mux.Unlock()
+		return nil, err
+	}
+	chainSeqNo, seqNo := ch.chainSeqNo, ch.seqNo
+	chLayer, cache := ch.layers, ch.cache
+	digest, err := sk.ctx.closeDigest(pad, sk.root, chIdx, chLayer, chainSeqNo, seqNo)
+	if err == nil {
+		err = sk.journalKey(JournalEntry{
+			Kind:   JournalClose,
+			Key:    OTSKey{Channel: chIdx, Layer: chLayer, Index: chainSeqNo},
+			SeqNo:  seqNo,
+			Digest: digest,
+		})
+	}
+	if err == nil {
+		err = sk.closeChannel(ch)
+	}
+	if err != nil {
+		ch.mux.Unlock()
+		return nil, err
+	}
+	ch.chainSeqNo++
+	ch.mux.Unlock()
+
+	// The last key of a chain tree is verified without authentication path.
+	authPath := make([]byte, sk.ctx.params.n)
+	if chainSeqNo < cH-1 {
+		authPath = sk.chainAuthPath(pad, chIdx, chLayer, chainSeqNo, cache)
+	}
+
+	var otsAddr address
+	otsAddr.setOTS(chainSeqNo)
+	otsAddr.setLayer(chLayer)
+	otsAddr.setTree(uint64(chIdx))
+	return &CloseSignature{
+		ctx:        sk.ctx,
+		seqNo:      seqNo,
+		wotsSig:    sk.ctx.wotsSign(pad, digest, sk.pubSeed, sk.skSeed, otsAddr),
+		authPath:   authPath,
+		chainSeqNo: chainSeqNo,
+		chIdx:      chIdx,
+		layer:      chLayer,
+	}, nil
+}
+
+// VerifyClose returns true if the CloseSignature is valid for the previous
+// authentication node authNode in its channel.
+func (pk *PublicKey) VerifyClose(sig *CloseSignature, authNode []byte) (bool, error) {
+	if err := pk.checkSignature(sig); err != nil {
+		return false, err
+	}
+	if authNode == nil {
+		return false, verifyError(ErrMissingAuthNode, "no authentication node for %s", sig)
+	}
+	pad := pk.ctx.newScratchPad()
+	digest, err := pk.ctx.closeDigest(pad, pk.root, sig.chIdx, sig.layer, sig.chainSeqNo, sig.seqNo)
+	if err != nil {
+		return false, err
+	}
+
+	sta := SubTreeAddress{
+		Layer: sig.layer,
+		Tree:  uint64(sig.chIdx),
+	}
+	addr := sta.address()
+	var otsAddr address
+	otsAddr.setSubTreeFrom(addr)
+	otsAddr.setOTS(sig.chainSeqNo)
+	wotsPk := pad.wotsBuf()
+	pk.ctx.wotsPkFromSigInto(pad, sig.wotsSig, digest, pk.ph, otsAddr, wotsPk)
+
+	// Compute the leaf from the wotsPk.
+	var lTreeAddr address
+	lTreeAddr.setSubTreeFrom(addr)
+	lTreeAddr.setType(lTreeAddrType)
+	lTreeAddr.setLTree(sig.chainSeqNo)
+	curHash := pk.ctx.lTree(pad, wotsPk, pk.ph, lTreeAddr)
+
+	// The leaf of the last key is the authentication node itself.
+	if sig.chainSeqNo < pk.ctx.chainTreeHeight(sig.layer)-1 {
+		var nodeAddr address
+		nodeAddr.setSubTreeFrom(addr)
+		nodeAddr.setType(treeAddrType)
+		nodeAddr.setTreeHeight(pk.ctx.getNodeHeight(sig.layer, sig.chainSeqNo))
+		nodeAddr.setTreeIndex(0)
+		pk.ctx.hInto(pad, sig.authPath, curHash, pk.ph, nodeAddr, curHash)
+	}
+
+	if subtle.ConstantTimeCompare(curHash, authNode) != 1 {
+		return false, verifyError(ErrInvalidSignature, "%s does not hash up to the authentication node", sig)
+	}
+	return true, nil
+}
@@ -0,0 +1,128 @@
+package mbpqs
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCloseChannel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orderer.key")
+	p := InitParam(32, 2, 3, 1, 0, 4)
+	sk, pk, err := GenerateKeyPair(p, 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	// A copy of the key, standing in for a leaked channel state.
+	leaked, _, err := DeriveKeyPair(p, 0, sk.skSeed, sk.skPrf, sk.pubSeed)
+	if err != nil {
+		t.Fatalf("Deriving keypair failed with error %s", err)
+	}
+	if err = sk.Persist(path); err != nil {
+		t.Fatalf("Persisting key failed with error %s", err)
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	if _, _, err = leaked.AddChannel(); err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+	if err != nil {
+		t.Fatalf("Creating verifier failed with error %s", err)
+	}
+	msg := []byte("Block in the channel")
+	for _, signer := range []*PrivateKey{sk, leaked} {
+		sig, err := signer.SignMsg(chIdx, msg)
+		if err != nil {
+			t.Fatalf("Signing message failed with error %s", err)
+		}
+		if signer == sk {
+			if accept, err := cv.Verify(sig, msg); !accept || err != nil {
+				t.Fatalf("Correct MsgSignature not accepted: %v", err)
+			}
+		}
+	}
+
+	cs, err := sk.CloseChannel(chIdx)
+	if err != nil {
+		t.Fatalf("Closing channel failed with error %s", err)
+	}
+	buf, err := cs.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Marshalling CloseSignature failed with error %s", err)
+	}
+	sig, err := UnmarshalSignature(buf)
+	if err != nil {
+		t.Fatalf("Unmarshalling CloseSignature failed with error %s", err)
+	}
+	if accept, err := cv.Verify(sig, nil); !accept || err != nil {
+		t.Fatalf("Correct CloseSignature not accepted: %v", err)
+	}
+	if !cv.Closed() || cs.SeqNo() != 1 {
+		t.Fatalf("Channel is closed at seqNo %d", cs.SeqNo())
+	}
+
+	// The leaked state can still sign, but its signatures are rejected.
+	leakedSig, err := leaked.SignMsg(chIdx, msg)
+	if err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	if accept, err := cv.Verify(leakedSig, msg); accept || err == nil {
+		t.Fatal("MsgSignature after the CloseSignature accepted")
+	}
+
+	// The closure survives a restart.
+	if sk, err = LoadPrivateKey(path, 0); err != nil {
+		t.Fatalf("Loading key failed with error %s", err)
+	}
+	if _, err = sk.SignMsg(chIdx, msg); err == nil {
+		t.Fatal("Signing in a closed channel did not give an error")
+	}
+	if _, err = sk.GrowChannel(chIdx); err == nil {
+		t.Fatal("Growing a closed channel did not give an error")
+	}
+	if _, err = sk.CloseChannel(chIdx); err == nil {
+		t.Fatal("Closing a closed channel did not give an error")
+	}
+	if st, _ := sk.ChannelStatus(chIdx); !st.Closed || st.KeysLeft != 0 {
+		t.Fatalf("Status of closed channel is %+v", st)
+	}
+}
+
+// A full chain tree is closed with its last key.
+func TestCloseFullChannel(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 2, 0, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+	if err != nil {
+		t.Fatalf("Creating verifier failed with error %s", err)
+	}
+	msg := []byte("Block in the channel")
+	sig, err := sk.SignMsg(chIdx, msg)
+	if err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	if accept, err := cv.Verify(sig, msg); !accept || err != nil {
+		t.Fatalf("Correct MsgSignature not accepted: %v", err)
+	}
+	cs, err := sk.CloseChannel(chIdx)
+	if err != nil {
+		t.Fatalf("Closing channel failed with error %s", err)
+	}
+	// A statement for another seqNo is not accepted.
+	cs.seqNo++
+	if accept, _ := pk.VerifyClose(cs, cv.AuthNode()); accept {
+		t.Fatal("CloseSignature for another seqNo accepted")
+	}
+	cs.seqNo--
+	if accept, err := cv.VerifyClose(cs); !accept || err != nil {
+		t.Fatalf("Correct CloseSignature with the last key not accepted: %v", err)
+	}
+}
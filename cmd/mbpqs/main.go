@@ -0,0 +1,833 @@
+// Command mbpqs creates and audits MBPQS keys, channels and signatures
+// stored on disk.
+//
+// Usage:
+//
+//	mbpqs keygen -key FILE -pub FILE [-n 32] [-hash sha2] [-w 16] [-rootH 10] [-chanH 100] [-gf 0] [-c 0] [-threads 0] [-reserve-successor] [-scrypt-logn 16]
+//	mbpqs add-channel -key FILE -out FILE [-journal FILE]
+//	mbpqs grow -key FILE -ch CHANNEL -out FILE [-journal FILE]
+//	mbpqs sign -key FILE -ch CHANNEL -in FILE -out FILE [-journal FILE]
+//	mbpqs close -key FILE -ch CHANNEL -out FILE [-journal FILE]
+//	mbpqs verify -pub FILE -ch CHANNEL ROOTSIG [SIG [MSG]]...
+//	mbpqs rollover -key FILE -next FILE -out FILE [-journal FILE]
+//	mbpqs successor -pub FILE CERT...
+//	mbpqs inspect FILE...
+//	mbpqs passwd -key FILE [-scrypt-logn 16]
+//	mbpqs split -key FILE -k 2 -n 3 -out PREFIX
+//	mbpqs recover -key FILE [-threads 0] [-scrypt-logn 16] SHARE... [SIG...]
+//	mbpqs audit -key FILE -journal FILE
+//	mbpqs serve -key FILE -listen ADDR [-threads 0] [-journal FILE]
+//
+// Private keys are key files which reserve their indices before use, see
+// PrivateKey.Persist. The verify command verifies the signatures of a channel
+// in the order they were created, starting with its RootSignature. Each
+// MsgSignature is followed by the file holding the signed message. The
+// rollover command certifies the public key in -next as the successor of the
+// key pair, and the successor command follows a chain of such certificates.
+//
+// If MBPQS_PASSPHRASE is set, private key files are encrypted with it. The
+// passwd command encrypts a key file under the passphrase in
+// MBPQS_NEW_PASSPHRASE instead.
+//
+// The split command splits the seeds of a key into shares, of which any k
+// recover the key with the recover command. A recovered key continues after
+// the signatures given to recover, which should include the last RootSignature
+// and the last signature in every channel of the key.
+//
+// The commands which sign record every one-time key they use in the journal
+// given with -journal. The audit command replays a journal against the state
+// of the key, and fails if a key is used twice.
+//
+// The serve command runs a signer daemon for the key until it is interrupted,
+// see mbpqs.NewSignerHandler. It listens on a loopback TCP address, or on the
+// Unix socket at PATH for an address unix:PATH.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Breus/mbpqs"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "mbpqs: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// The subcommands, by name.
+var commands = map[string]func(args []string, out io.Writer) error{
+	"keygen":      keygen,
+	"add-channel": addChannel,
+	"grow":        grow,
+	"sign":        sign,
+	"close":       closeChannel,
+	"verify":      verify,
+	"rollover":    rollover,
+	"successor":   successor,
+	"inspect":     inspect,
+	"passwd":      passwd,
+	"split":       split,
+	"recover":     recoverKey,
+	"audit":       audit,
+	"serve":       serve,
+}
+
+// Runs the subcommand in args[0] with the remaining arguments.
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command given, use one of: keygen, add-channel, grow, sign, close, verify, rollover, successor, inspect, passwd, split, recover, audit, serve")
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return cmd(args[1:], out)
+}
+
+// Creates the FlagSet of a subcommand, which reports errors instead of exiting.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// Checks that the flags with the given names are set.
+func requireFlags(fs *flag.FlagSet, names ...string) error {
+	for _, name := range names {
+		if fs.Lookup(name).Value.String() == "" {
+			return fmt.Errorf("%s: flag -%s is required", fs.Name(), name)
+		}
+	}
+	return nil
+}
+
+func keygen(args []string, out io.Writer) error {
+	fs := newFlagSet("keygen")
+	keyPath := fs.String("key", "", "file to store the private key in")
+	pubPath := fs.String("pub", "", "file to store the public key in")
+	n := fs.Uint("n", 32, "security parameter in bytes (24, 32 or 64)")
+	hash := fs.String("hash", "sha2", "hash function (sha2 or shake256)")
+	w := fs.Uint("w", 16, "Winternitz parameter (4, 16 or 256)")
+	rootH := fs.Uint("rootH", 10, "height of the root tree")
+	chanH := fs.Uint("chanH", 100, "height of the first chain tree in a channel")
+	gf := fs.Uint("gf", 0, "growth factor of subsequent chain trees")
+	c := fs.Uint("c", 0, "caching parameter")
+	threads := fs.Int("threads", 0, "threads to use, 0 for all CPUs")
+	reserve := fs.Bool("reserve-successor", false, "reserve the last root tree leaf to sign a successor")
+	logN := fs.Uint("scrypt-logn", uint(mbpqs.DefaultScryptParams.LogN), "scrypt cost of an encrypted key file, as a power of 2")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "key", "pub"); err != nil {
+		return err
+	}
+	var hf mbpqs.HashFunction
+	switch *hash {
+	case "sha2":
+		hf = mbpqs.SHA2
+	case "shake256":
+		hf = mbpqs.SHAKE256
+	default:
+		return fmt.Errorf("keygen: unknown hash function %q", *hash)
+	}
+	p := mbpqs.InitParamHash(uint32(*n), uint32(*rootH), uint32(*chanH),
+		uint32(*gf), uint16(*c), uint16(*w), hf)
+	sk, pk, err := mbpqs.GenerateKeyPair(p, *threads)
+	if err != nil {
+		return err
+	}
+	if err = persistKey(sk, *keyPath, *logN); err != nil {
+		return err
+	}
+	if *reserve {
+		if err = sk.ReserveSuccessorLeaf(); err != nil {
+			return err
+		}
+	}
+	if err = writePublicKey(*pubPath, pk); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, pk)
+	return nil
+}
+
+func addChannel(args []string, out io.Writer) error {
+	fs := newFlagSet("add-channel")
+	keyPath := fs.String("key", "", "private key file")
+	outPath := fs.String("out", "", "file to store the RootSignature in")
+	threads := fs.Int("threads", 0, "threads to use, 0 for all CPUs")
+	journalPath := fs.String("journal", "", "journal to record the used one-time keys in")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "key", "out"); err != nil {
+		return err
+	}
+	sigFile, err := createSignatureFile(*outPath)
+	if err != nil {
+		return err
+	}
+	defer sigFile.discard()
+	sk, err := loadKey(*keyPath, *threads)
+	if err != nil {
+		return err
+	}
+	if err = openJournal(sk, *journalPath); err != nil {
+		return err
+	}
+	defer sk.CloseJournal()
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		return err
+	}
+	if err = sigFile.write(rtSig); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "channel %d: %s\n", chIdx, rtSig)
+	return nil
+}
+
+func grow(args []string, out io.Writer) error {
+	fs := newFlagSet("grow")
+	keyPath := fs.String("key", "", "private key file")
+	chIdx := fs.Uint("ch", 0, "index of the channel to grow")
+	outPath := fs.String("out", "", "file to store the GrowSignature in")
+	threads := fs.Int("threads", 0, "threads to use, 0 for all CPUs")
+	journalPath := fs.String("journal", "", "journal to record the used one-time keys in")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "key", "out"); err != nil {
+		return err
+	}
+	sigFile, err := createSignatureFile(*outPath)
+	if err != nil {
+		return err
+	}
+	defer sigFile.discard()
+	sk, err := loadKeyWithChannel(*keyPath, *threads, *chIdx)
+	if err != nil {
+		return err
+	}
+	if err = openJournal(sk, *journalPath); err != nil {
+		return err
+	}
+	defer sk.CloseJournal()
+	growSig, err := sk.GrowChannel(uint32(*chIdx))
+	if err != nil {
+		return err
+	}
+	if err = sigFile.write(growSig); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, growSig)
+	return nil
+}
+
+func closeChannel(args []string, out io.Writer) error {
+	fs := newFlagSet("close")
+	keyPath := fs.String("key", "", "private key file")
+	chIdx := fs.Uint("ch", 0, "index of the channel to close")
+	outPath := fs.String("out", "", "file to store the CloseSignature in")
+	threads := fs.Int("threads", 0, "threads to use, 0 for all CPUs")
+	journalPath := fs.String("journal", "", "journal to record the used one-time keys in")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "key", "out"); err != nil {
+		return err
+	}
+	sigFile, err := createSignatureFile(*outPath)
+	if err != nil {
+		return err
+	}
+	defer sigFile.discard()
+	sk, err := loadKeyWithChannel(*keyPath, *threads, *chIdx)
+	if err != nil {
+		return err
+	}
+	if err = openJournal(sk, *journalPath); err != nil {
+		return err
+	}
+	defer sk.CloseJournal()
+	closeSig, err := sk.CloseChannel(uint32(*chIdx))
+	if err != nil {
+		return err
+	}
+	if err = sigFile.write(closeSig); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, closeSig)
+	return nil
+}
+
+func sign(args []string, out io.Writer) error {
+	fs := newFlagSet("sign")
+	keyPath := fs.String("key", "", "private key file")
+	chIdx := fs.Uint("ch", 0, "index of the channel to sign in")
+	inPath := fs.String("in", "", "file holding the message to sign")
+	outPath := fs.String("out", "", "file to store the MsgSignature in")
+	threads := fs.Int("threads", 0, "threads to use, 0 for all CPUs")
+	journalPath := fs.String("journal", "", "journal to record the used one-time keys in")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "key", "in", "out"); err != nil {
+		return err
+	}
+	sigFile, err := createSignatureFile(*outPath)
+	if err != nil {
+		return err
+	}
+	defer sigFile.discard()
+	sk, err := loadKeyWithChannel(*keyPath, *threads, *chIdx)
+	if err != nil {
+		return err
+	}
+	if err = openJournal(sk, *journalPath); err != nil {
+		return err
+	}
+	defer sk.CloseJournal()
+	// Stream the message from its file, as blocks can be large.
+	msg, err := os.Open(*inPath)
+	if err != nil {
+		return err
+	}
+	defer msg.Close()
+	msgSig, err := sk.SignChannelReader(uint32(*chIdx), msg)
+	if err != nil {
+		return err
+	}
+	if err = sigFile.write(msgSig); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, msgSig)
+	return nil
+}
+
+func verify(args []string, out io.Writer) error {
+	fs := newFlagSet("verify")
+	pubPath := fs.String("pub", "", "public key file")
+	chIdx := fs.Uint("ch", 0, "index of the channel the signatures belong to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "pub"); err != nil {
+		return err
+	}
+	files := fs.Args()
+	if len(files) == 0 {
+		return fmt.Errorf("verify: the RootSignature of the channel is required")
+	}
+	pk, err := readPublicKey(*pubPath)
+	if err != nil {
+		return err
+	}
+	sig, err := readSignature(files[0])
+	if err != nil {
+		return err
+	}
+	rtSig, ok := sig.(*mbpqs.RootSignature)
+	if !ok {
+		return fmt.Errorf("%s: holds a %T instead of a RootSignature", files[0], sig)
+	}
+	cv, err := pk.NewChannelVerifier(uint32(*chIdx), rtSig)
+	if err != nil {
+		return fmt.Errorf("%s: %s", files[0], err)
+	}
+	fmt.Fprintf(out, "%s: OK %s\n", files[0], rtSig)
+
+	for i := 1; i < len(files); i++ {
+		sigPath := files[i]
+		sig, err := readSignature(sigPath)
+		if err != nil {
+			return err
+		}
+		var accept bool
+		if msgSig, ok := sig.(*mbpqs.MsgSignature); ok {
+			if i+1 == len(files) {
+				return fmt.Errorf("%s: the signed message file is missing", sigPath)
+			}
+			i++
+			accept, err = verifyMsgFile(cv, msgSig, files[i])
+		} else {
+			accept, err = cv.Verify(sig, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", sigPath, err)
+		}
+		if !accept {
+			return fmt.Errorf("%s: invalid %s", sigPath, sig)
+		}
+		fmt.Fprintf(out, "%s: OK %s\n", sigPath, sig)
+	}
+	return nil
+}
+
+func rollover(args []string, out io.Writer) error {
+	fs := newFlagSet("rollover")
+	keyPath := fs.String("key", "", "private key file")
+	nextPath := fs.String("next", "", "public key file of the successor")
+	outPath := fs.String("out", "", "file to store the SuccessionCertificate in")
+	threads := fs.Int("threads", 0, "threads to use, 0 for all CPUs")
+	journalPath := fs.String("journal", "", "journal to record the used one-time keys in")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "key", "next", "out"); err != nil {
+		return err
+	}
+	next, err := readPublicKey(*nextPath)
+	if err != nil {
+		return err
+	}
+	sigFile, err := createSignatureFile(*outPath)
+	if err != nil {
+		return err
+	}
+	defer sigFile.discard()
+	sk, err := loadKey(*keyPath, *threads)
+	if err != nil {
+		return err
+	}
+	if err = openJournal(sk, *journalPath); err != nil {
+		return err
+	}
+	defer sk.CloseJournal()
+	sc, err := sk.SignSuccessor(next)
+	if err != nil {
+		return err
+	}
+	if err = sigFile.write(sc); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, sc)
+	return nil
+}
+
+func successor(args []string, out io.Writer) error {
+	fs := newFlagSet("successor")
+	pubPath := fs.String("pub", "", "public key file of the first key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "pub"); err != nil {
+		return err
+	}
+	pk, err := readPublicKey(*pubPath)
+	if err != nil {
+		return err
+	}
+	kc := mbpqs.NewKeyChain(pk)
+	for _, path := range fs.Args() {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var sc mbpqs.SuccessionCertificate
+		if err = sc.UnmarshalBinary(buf); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		accept, err := kc.Add(&sc)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		if !accept {
+			return fmt.Errorf("%s: invalid %s", path, &sc)
+		}
+		fmt.Fprintf(out, "%s: OK %s\n", path, &sc)
+	}
+	fmt.Fprintf(out, "current: %s\n", kc.Current())
+	return nil
+}
+
+func inspect(args []string, out io.Writer) error {
+	fs := newFlagSet("inspect")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var pk mbpqs.PublicKey
+		if bytes.HasPrefix(data, []byte("-----BEGIN ")) {
+			if err = pk.UnmarshalText(data); err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+			fmt.Fprintf(out, "%s: %s\n", path, &pk)
+			continue
+		}
+		if pk.UnmarshalBinary(data) == nil {
+			fmt.Fprintf(out, "%s: %s\n", path, &pk)
+			continue
+		}
+		if sig, err := mbpqs.UnmarshalSignature(data); err == nil {
+			fmt.Fprintf(out, "%s: %s\n", path, sig)
+			continue
+		}
+		var sc mbpqs.SuccessionCertificate
+		if sc.UnmarshalBinary(data) == nil {
+			fmt.Fprintf(out, "%s: %s\n", path, &sc)
+			continue
+		}
+		var ks mbpqs.KeyShare
+		if ks.UnmarshalBinary(data) == nil {
+			fmt.Fprintf(out, "%s: %s\n", path, &ks)
+			continue
+		}
+		sk, err := loadKey(path, 1)
+		if err != nil {
+			return fmt.Errorf("%s: not a MBPQS key or signature (%s)", path, err)
+		}
+		fmt.Fprintf(out, "%s: %s\n", path, sk)
+		st := sk.Status()
+		fmt.Fprintf(out, "  channels left: %d of %d, signatures: %d\n",
+			st.ChannelsLeft, st.RootLeaves, st.Signatures)
+		for chIdx, ch := range sk.Channels {
+			fmt.Fprintf(out, "  channel %d: %s, %d keys left before grow\n",
+				chIdx, ch, st.Channels[chIdx].KeysLeft)
+		}
+	}
+	return nil
+}
+
+func passwd(args []string, out io.Writer) error {
+	fs := newFlagSet("passwd")
+	keyPath := fs.String("key", "", "private key file")
+	logN := fs.Uint("scrypt-logn", uint(mbpqs.DefaultScryptParams.LogN), "scrypt cost of the key file, as a power of 2")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "key"); err != nil {
+		return err
+	}
+	pass, ok := os.LookupEnv(newPassphraseEnv)
+	if !ok {
+		return fmt.Errorf("passwd: %s is not set", newPassphraseEnv)
+	}
+	sk, err := loadKey(*keyPath, 1)
+	if err != nil {
+		return err
+	}
+	if err = sk.ChangePassphrase([]byte(pass), scryptParams(*logN)); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s: encrypted\n", *keyPath)
+	return nil
+}
+
+func split(args []string, out io.Writer) error {
+	fs := newFlagSet("split")
+	keyPath := fs.String("key", "", "private key file")
+	k := fs.Int("k", 2, "amount of shares needed to recover the key")
+	n := fs.Int("n", 3, "amount of shares")
+	outPrefix := fs.String("out", "", "prefix of the share files, which end in .1 to .n")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "key", "out"); err != nil {
+		return err
+	}
+	sk, err := loadKey(*keyPath, 1)
+	if err != nil {
+		return err
+	}
+	defer sk.Destroy()
+	shares, err := sk.SplitSeeds(*k, *n)
+	if err != nil {
+		return err
+	}
+	for _, ks := range shares {
+		buf, err := ks.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		path := fmt.Sprintf("%s.%d", *outPrefix, ks.Index())
+		// Shares are as secret as the key, for whoever holds enough of them.
+		if err = writeNewFile(path, buf, 0600); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s: %s\n", path, ks)
+	}
+	return nil
+}
+
+func recoverKey(args []string, out io.Writer) error {
+	fs := newFlagSet("recover")
+	keyPath := fs.String("key", "", "file to store the recovered private key in")
+	threads := fs.Int("threads", 0, "threads to use, 0 for all CPUs")
+	logN := fs.Uint("scrypt-logn", uint(mbpqs.DefaultScryptParams.LogN), "scrypt cost of an encrypted key file, as a power of 2")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "key"); err != nil {
+		return err
+	}
+	var shares []*mbpqs.KeyShare
+	var st mbpqs.KeyStatus
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		ks := new(mbpqs.KeyShare)
+		if ks.UnmarshalBinary(data) == nil {
+			shares = append(shares, ks)
+			continue
+		}
+		sig, err := mbpqs.UnmarshalSignature(data)
+		if err != nil {
+			return fmt.Errorf("%s: not a key share or signature", path)
+		}
+		if err = st.Observe(sig); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	sk, err := mbpqs.RecoverPrivateKey(shares, st, *threads)
+	if err != nil {
+		return err
+	}
+	if err = persistKey(sk, *keyPath, *logN); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, sk)
+	return nil
+}
+
+func audit(args []string, out io.Writer) error {
+	fs := newFlagSet("audit")
+	keyPath := fs.String("key", "", "private key file")
+	journalPath := fs.String("journal", "", "journal of the private key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "key", "journal"); err != nil {
+		return err
+	}
+	sk, err := loadKey(*keyPath, 1)
+	if err != nil {
+		return err
+	}
+	a, err := sk.AuditJournal(*journalPath)
+	if err != nil {
+		return err
+	}
+	for _, e := range a.Reused {
+		fmt.Fprintf(out, "reused: %s\n", e)
+	}
+	for _, e := range a.Ahead {
+		fmt.Fprintf(out, "unused by the key: %s\n", e)
+	}
+	for _, key := range a.Skipped {
+		fmt.Fprintf(out, "not recorded: %s\n", key)
+	}
+	fmt.Fprintf(out, "%d entries, %d reused, %d unused by the key, %d not recorded\n",
+		a.Entries, len(a.Reused), len(a.Ahead), len(a.Skipped))
+	if !a.OK() {
+		return fmt.Errorf("audit: the journal shows one-time keys which are used twice")
+	}
+	return nil
+}
+
+func serve(args []string, out io.Writer) error {
+	fs := newFlagSet("serve")
+	keyPath := fs.String("key", "", "private key file")
+	listen := fs.String("listen", "", "loopback address to listen on, unix:PATH for a Unix socket")
+	threads := fs.Int("threads", 0, "threads to use, 0 for all CPUs")
+	journalPath := fs.String("journal", "", "journal to record the used one-time keys in")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "key", "listen"); err != nil {
+		return err
+	}
+	sk, err := loadKey(*keyPath, *threads)
+	if err != nil {
+		return err
+	}
+	defer sk.Destroy()
+	if err = openJournal(sk, *journalPath); err != nil {
+		return err
+	}
+	defer sk.CloseJournal()
+	l, err := mbpqs.ListenSigner(*listen)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Handler: mbpqs.NewSignerHandler(sk)}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	fmt.Fprintf(out, "serving %s on %s\n", sk.PublicKey(), l.Addr())
+	if err = srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Environment variables holding the passphrase of encrypted key files, and
+// the new passphrase for the passwd command.
+const (
+	passphraseEnv    = "MBPQS_PASSPHRASE"
+	newPassphraseEnv = "MBPQS_NEW_PASSPHRASE"
+)
+
+// Returns the scrypt parameters with cost 2^logN.
+func scryptParams(logN uint) mbpqs.ScryptParams {
+	sp := mbpqs.DefaultScryptParams
+	sp.LogN = uint8(logN)
+	return sp
+}
+
+// Loads the private key at path, which is encrypted if a passphrase is set.
+func loadKey(path string, threads int) (*mbpqs.PrivateKey, error) {
+	if pass, ok := os.LookupEnv(passphraseEnv); ok {
+		return mbpqs.LoadEncryptedPrivateKey(path, []byte(pass), threads)
+	}
+	return mbpqs.LoadPrivateKey(path, threads)
+}
+
+// Saves the private key to a new key file at path, which is encrypted with
+// scrypt cost 2^logN if a passphrase is set.
+func persistKey(sk *mbpqs.PrivateKey, path string, logN uint) error {
+	if pass, ok := os.LookupEnv(passphraseEnv); ok {
+		return sk.PersistEncrypted(path, []byte(pass), scryptParams(logN))
+	}
+	return sk.Persist(path)
+}
+
+// Makes the private key record the one-time keys it uses in the journal at
+// path, if one is given.
+func openJournal(sk *mbpqs.PrivateKey, path string) error {
+	if path == "" {
+		return nil
+	}
+	return sk.OpenJournal(path)
+}
+
+// Verifies the next MsgSignature in a channel over the message streamed from
+// the file at path.
+func verifyMsgFile(cv *mbpqs.ChannelVerifier, sig *mbpqs.MsgSignature, path string) (bool, error) {
+	msg, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer msg.Close()
+	return cv.VerifyMsgReader(sig, msg)
+}
+
+// Loads the private key at path, and checks that it has channel chIdx.
+func loadKeyWithChannel(path string, threads int, chIdx uint) (*mbpqs.PrivateKey, error) {
+	sk, err := loadKey(path, threads)
+	if err != nil {
+		return nil, err
+	}
+	if chIdx >= uint(len(sk.Channels)) {
+		return nil, fmt.Errorf("channel %d does not exist, the key has %d channels", chIdx, len(sk.Channels))
+	}
+	return sk, nil
+}
+
+// Writes the armored public key to a new file at path.
+func writePublicKey(path string, pk *mbpqs.PublicKey) error {
+	text, err := pk.MarshalText()
+	if err != nil {
+		return err
+	}
+	return writeNewFile(path, text, 0644)
+}
+
+// Reads an armored public key from the file at path.
+func readPublicKey(path string) (*mbpqs.PublicKey, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pk mbpqs.PublicKey
+	if err = pk.UnmarshalText(text); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &pk, nil
+}
+
+// A new file to store a signature in. It is created before the one-time key
+// is used, such that a signature is never lost because its file exists or can
+// not be created.
+type signatureFile struct {
+	f       *os.File
+	written bool
+}
+
+// Creates the file at path for a signature, which should not exist yet.
+func createSignatureFile(path string) (*signatureFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &signatureFile{f: f}, nil
+}
+
+// Writes the binary encoded signature to the file and closes it.
+func (sf *signatureFile) write(sig interface{ MarshalBinary() ([]byte, error) }) error {
+	sf.written = true
+	buf, err := sig.MarshalBinary()
+	if err != nil {
+		sf.f.Close()
+		return err
+	}
+	if _, err = sf.f.Write(buf); err != nil {
+		sf.f.Close()
+		return err
+	}
+	return sf.f.Close()
+}
+
+// Removes the file if no signature is written to it, as signing failed.
+func (sf *signatureFile) discard() {
+	if !sf.written {
+		sf.f.Close()
+		os.Remove(sf.f.Name())
+	}
+}
+
+// Reads a binary encoded signature from the file at path.
+func readSignature(path string) (mbpqs.Signature, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := mbpqs.UnmarshalSignature(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return sig, nil
+}
+
+// Writes data to a file at path with permissions perm, which should not exist yet.
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
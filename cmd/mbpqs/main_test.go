@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Runs the command line tool with args, and fails the test on an error.
+func mustRun(t *testing.T, args ...string) string {
+	var out bytes.Buffer
+	if err := run(args, &out); err != nil {
+		t.Fatalf("mbpqs %s failed with error %s", strings.Join(args, " "), err)
+	}
+	return out.String()
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	for _, name := range []string{"block1", "block2"} {
+		if err := os.WriteFile(path(name), []byte("Contents of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mustRun(t, "keygen", "-key", path("orderer.key"), "-pub", path("orderer.pub"),
+		"-w", "4", "-rootH", "2", "-chanH", "2", "-gf", "1")
+	mustRun(t, "add-channel", "-key", path("orderer.key"), "-out", path("ch0.sig"))
+	mustRun(t, "sign", "-key", path("orderer.key"), "-in", path("block1"), "-out", path("block1.sig"))
+	// A signature file which exists already fails the command before a
+	// one-time key is used, so the channel can still be grown below.
+	var discard bytes.Buffer
+	if err := run([]string{"sign", "-key", path("orderer.key"), "-in", path("block2"),
+		"-out", path("block1.sig")}, &discard); err == nil {
+		t.Fatal("Signing into an existing file did not give an error")
+	}
+	mustRun(t, "grow", "-key", path("orderer.key"), "-out", path("grow1.sig"))
+	mustRun(t, "sign", "-key", path("orderer.key"), "-in", path("block2"), "-out", path("block2.sig"))
+
+	out := mustRun(t, "verify", "-pub", path("orderer.pub"), path("ch0.sig"),
+		path("block1.sig"), path("block1"), path("grow1.sig"), path("block2.sig"), path("block2"))
+	if strings.Count(out, ": OK ") != 4 {
+		t.Fatalf("Not all signatures are verified:\n%s", out)
+	}
+
+	// A signature over another message, or out of order, is not accepted.
+	if err := run([]string{"verify", "-pub", path("orderer.pub"), path("ch0.sig"),
+		path("block1.sig"), path("block2")}, &discard); err == nil {
+		t.Fatal("MsgSignature over another message accepted")
+	}
+	if err := run([]string{"verify", "-pub", path("orderer.pub"), path("ch0.sig"),
+		path("block2.sig"), path("block2")}, &discard); err == nil {
+		t.Fatal("MsgSignature out of order accepted")
+	}
+	// Signing in a channel which does not exist fails.
+	if err := run([]string{"sign", "-key", path("orderer.key"), "-ch", "1",
+		"-in", path("block1"), "-out", path("other.sig")}, &discard); err == nil {
+		t.Fatal("Signing in a channel which does not exist did not give an error")
+	}
+	if _, err := os.Stat(path("other.sig")); !os.IsNotExist(err) {
+		t.Fatal("Signature file is not removed after signing failed")
+	}
+
+	// After the channel is closed, no signature can follow.
+	mustRun(t, "close", "-key", path("orderer.key"), "-out", path("close.sig"))
+	out = mustRun(t, "verify", "-pub", path("orderer.pub"), path("ch0.sig"),
+		path("block1.sig"), path("block1"), path("grow1.sig"), path("block2.sig"), path("block2"), path("close.sig"))
+	if !strings.Contains(out, ": OK CloseSignature{") {
+		t.Fatalf("CloseSignature is not verified:\n%s", out)
+	}
+	if err := run([]string{"sign", "-key", path("orderer.key"), "-in", path("block1"), "-out", path("block3.sig")}, &discard); err == nil {
+		t.Fatal("Signing in a closed channel did not give an error")
+	}
+
+	out = mustRun(t, "inspect", path("orderer.pub"), path("orderer.key"), path("grow1.sig"))
+	for _, want := range []string{"PublicKey{", "PrivateKey{", "channel 0: Channel{layers: 2", "channels left: 3 of 4", "GrowSignature{"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("Inspect output does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestKeygenHash(t *testing.T) {
+	dir := t.TempDir()
+	out := mustRun(t, "keygen", "-key", filepath.Join(dir, "orderer.key"), "-pub", filepath.Join(dir, "orderer.pub"),
+		"-n", "24", "-hash", "shake256", "-w", "4", "-rootH", "2", "-chanH", "2")
+	if !strings.Contains(out, "SHAKE256 n=24") {
+		t.Fatalf("Key is not generated with SHAKE256 and n=24:\n%s", out)
+	}
+	var discard bytes.Buffer
+	if err := run([]string{"keygen", "-key", filepath.Join(dir, "other.key"), "-pub", filepath.Join(dir, "other.pub"),
+		"-hash", "md5"}, &discard); err == nil {
+		t.Fatal("Generating a key with an unknown hash function did not give an error")
+	}
+}
+
+func TestRollover(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	mustRun(t, "keygen", "-key", path("old.key"), "-pub", path("old.pub"),
+		"-w", "4", "-rootH", "1", "-chanH", "2", "-reserve-successor")
+	mustRun(t, "keygen", "-key", path("new.key"), "-pub", path("new.pub"),
+		"-w", "4", "-rootH", "2", "-chanH", "2")
+	mustRun(t, "add-channel", "-key", path("old.key"), "-out", path("ch0.sig"))
+	var discard bytes.Buffer
+	if err := run([]string{"add-channel", "-key", path("old.key"), "-out", path("ch1.sig")}, &discard); err == nil {
+		t.Fatal("Adding a channel with the reserved leaf did not give an error")
+	}
+	mustRun(t, "rollover", "-key", path("old.key"), "-next", path("new.pub"), "-out", path("succession.cert"))
+
+	out := mustRun(t, "successor", "-pub", path("old.pub"), path("succession.cert"))
+	if !strings.Contains(out, ": OK SuccessionCertificate{") || !strings.Contains(out, "current: PublicKey{") {
+		t.Fatalf("Successor is not accepted:\n%s", out)
+	}
+	if err := run([]string{"successor", "-pub", path("new.pub"), path("succession.cert")}, &discard); err == nil {
+		t.Fatal("Certificate accepted by its successor")
+	}
+	out = mustRun(t, "inspect", path("succession.cert"))
+	if !strings.Contains(out, "SuccessionCertificate{seqNo: 1") {
+		t.Fatalf("Inspect output does not describe the certificate:\n%s", out)
+	}
+}
+
+func TestEncryptedKey(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	mustRun(t, "keygen", "-key", path("orderer.key"), "-pub", path("orderer.pub"),
+		"-w", "4", "-rootH", "2", "-chanH", "2")
+	var discard bytes.Buffer
+	if err := run([]string{"passwd", "-key", path("orderer.key")}, &discard); err == nil {
+		t.Fatal("Encrypting without a new passphrase did not give an error")
+	}
+	t.Setenv("MBPQS_NEW_PASSPHRASE", "correct horse")
+	mustRun(t, "passwd", "-key", path("orderer.key"), "-scrypt-logn", "4")
+
+	if err := run([]string{"add-channel", "-key", path("orderer.key"), "-out", path("ch0.sig")}, &discard); err == nil {
+		t.Fatal("Loading an encrypted key without passphrase did not give an error")
+	}
+	t.Setenv("MBPQS_PASSPHRASE", "battery staple")
+	if err := run([]string{"add-channel", "-key", path("orderer.key"), "-out", path("ch0.sig")}, &discard); err == nil {
+		t.Fatal("Loading an encrypted key with a wrong passphrase did not give an error")
+	}
+	t.Setenv("MBPQS_PASSPHRASE", "correct horse")
+	mustRun(t, "add-channel", "-key", path("orderer.key"), "-out", path("ch0.sig"))
+	out := mustRun(t, "inspect", path("orderer.key"))
+	if !strings.Contains(out, "channels left: 3 of 4") {
+		t.Fatalf("Inspect output does not describe the encrypted key:\n%s", out)
+	}
+
+	// Keys generated with a passphrase are encrypted right away.
+	mustRun(t, "keygen", "-key", path("other.key"), "-pub", path("other.pub"),
+		"-w", "4", "-rootH", "2", "-chanH", "2", "-scrypt-logn", "4")
+	os.Unsetenv("MBPQS_PASSPHRASE")
+	if err := run([]string{"inspect", path("other.key")}, &discard); err == nil {
+		t.Fatal("Inspecting an encrypted key without passphrase did not give an error")
+	}
+}
+
+func TestSplitRecover(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	for _, name := range []string{"block1", "block2"} {
+		if err := os.WriteFile(path(name), []byte("Contents of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustRun(t, "keygen", "-key", path("orderer.key"), "-pub", path("orderer.pub"),
+		"-w", "4", "-rootH", "2", "-chanH", "3")
+	mustRun(t, "split", "-key", path("orderer.key"), "-k", "2", "-n", "3", "-out", path("share"))
+	mustRun(t, "add-channel", "-key", path("orderer.key"), "-out", path("ch0.sig"))
+	mustRun(t, "sign", "-key", path("orderer.key"), "-in", path("block1"), "-out", path("block1.sig"))
+	if fi, err := os.Stat(path("share.3")); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("Share file is missing or readable by others: %v", err)
+	}
+
+	var discard bytes.Buffer
+	if err := run([]string{"recover", "-key", path("lost.key"), path("share.1")}, &discard); err == nil {
+		t.Fatal("Recovering from a single share did not give an error")
+	}
+	mustRun(t, "recover", "-key", path("recovered.key"), path("share.3"), path("share.1"),
+		path("ch0.sig"), path("block1.sig"))
+	out := mustRun(t, "inspect", path("share.2"), path("recovered.key"))
+	if !strings.Contains(out, "share 2, threshold: 2") || !strings.Contains(out, "channels left: 3 of 4") {
+		t.Fatalf("Inspect output does not describe the share and recovered key:\n%s", out)
+	}
+	// The recovered key continues after the last signature.
+	mustRun(t, "sign", "-key", path("recovered.key"), "-in", path("block1"), "-out", path("block2.sig"))
+	out = mustRun(t, "verify", "-pub", path("orderer.pub"), path("ch0.sig"),
+		path("block1.sig"), path("block1"), path("block2.sig"), path("block1"))
+	if strings.Count(out, ": OK ") != 3 {
+		t.Fatalf("Not all signatures are verified:\n%s", out)
+	}
+}
+
+func TestJournalAudit(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	for _, name := range []string{"block1", "block2"} {
+		if err := os.WriteFile(path(name), []byte("Contents of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustRun(t, "keygen", "-key", path("orderer.key"), "-pub", path("orderer.pub"),
+		"-w", "4", "-rootH", "2", "-chanH", "3")
+	backup, err := os.ReadFile(path("orderer.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustRun(t, "add-channel", "-key", path("orderer.key"), "-out", path("ch0.sig"), "-journal", path("journal"))
+	mustRun(t, "sign", "-key", path("orderer.key"), "-in", path("block1"), "-out", path("block1.sig"), "-journal", path("journal"))
+	out := mustRun(t, "audit", "-key", path("orderer.key"), "-journal", path("journal"))
+	if !strings.Contains(out, "2 entries, 0 reused, 0 unused by the key, 0 not recorded") {
+		t.Fatalf("Audit output does not report a clean journal:\n%s", out)
+	}
+
+	// A key file restored from a backup uses the first channel key again,
+	// for another message. Signing the same channel root again with the
+	// first root tree leaf does not count as reuse.
+	if err = os.WriteFile(path("orderer.key"), backup, 0600); err != nil {
+		t.Fatal(err)
+	}
+	mustRun(t, "add-channel", "-key", path("orderer.key"), "-out", path("ch0b.sig"), "-journal", path("journal"))
+	mustRun(t, "sign", "-key", path("orderer.key"), "-in", path("block2"), "-out", path("block2.sig"), "-journal", path("journal"))
+	var buf bytes.Buffer
+	if err = run([]string{"audit", "-key", path("orderer.key"), "-journal", path("journal")}, &buf); err == nil {
+		t.Fatal("Auditing a journal with a reused key did not give an error")
+	}
+	if !strings.Contains(buf.String(), "reused: msg with channel 0, layer 1, key 0,") ||
+		strings.Contains(buf.String(), "reused: root") {
+		t.Fatalf("Audit output does not report the reused key:\n%s", buf.String())
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	var out bytes.Buffer
+	if err := run(nil, &out); err == nil {
+		t.Fatal("Running without a command did not give an error")
+	}
+	if err := run([]string{"frobnicate"}, &out); err == nil {
+		t.Fatal("Running an unknown command did not give an error")
+	}
+}
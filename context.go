@@ -6,12 +6,14 @@ import (
 
 // Context including a full MBPQS instance.
 type Context struct {
-	params       *Params // MBPQS parameters
-	wotsLogW     uint8   // logarithm of the Winternitz parameter
-	wotsLen1     uint32  // WOTS+ chains for message
-	wotsLen2     uint32  // WOTS+ chains for checksum
-	wotsLen      uint32  // total number of WOTS+ chains
-	wotsSigBytes uint32  // length of WOTS+ signature
+	params       *Params     // MBPQS parameters
+	wotsLogW     uint8       // logarithm of the Winternitz parameter
+	wotsLen1     uint32      // WOTS+ chains for message
+	wotsLen2     uint32      // WOTS+ chains for checksum
+	wotsLen      uint32      // total number of WOTS+ chains
+	wotsSigBytes uint32      // length of WOTS+ signature
+	hash         hashBackend // the hash function F, H, H_msg and PRF are instantiated with
+	hashPadLen   uint32      // length of the padding in F, H, H_msg and PRF
 	// The amount of threads to use in the MBPQS scheme.
 	threads int
 }
@@ -19,8 +21,16 @@ type Context struct {
 // Allocates memory for a Context and sets the given parameters in it.
 func newContext(p *Params) (ctx *Context, err error) {
 	ctx = new(Context)
-	if p.n != 32 && p.n != 64 {
-		return nil, fmt.Errorf("Only n=32 and n = 64 are supported for now (it was %d)", p.n)
+	if p.n != 24 && p.n != 32 && p.n != 64 {
+		return nil, fmt.Errorf("Only n=24, n=32 and n=64 are supported for now (it was %d)", p.n)
+	}
+	switch p.hash {
+	case SHA2:
+		ctx.hash = newSHA2Backend(p.n)
+	case SHAKE256:
+		ctx.hash = newSHAKEBackend(p.n)
+	default:
+		return nil, fmt.Errorf("unknown hash function %d", p.hash)
 	}
 	if p.w != 4 && p.w != 16 && p.w != 256 {
 		return nil, fmt.Errorf("w = {4,16,256} are suported, no other values (w was %d)", p.w)
@@ -40,6 +50,7 @@ func newContext(p *Params) (ctx *Context, err error) {
 	ctx.wotsLen2 = p.wotsLen2()
 	ctx.wotsLen = p.wotsLen()
 	ctx.wotsSigBytes = p.wotsSignatureSize()
+	ctx.hashPadLen = p.hashPaddingLen()
 	return ctx, nil
 }
 
@@ -51,6 +62,7 @@ func (ctx *Context) deriveKeyPair(skSeed, skPrf, pubSeed []byte) (
 	}
 
 	pad := ctx.newScratchPad()
+	defer pad.wipe()
 
 	sk, err := ctx.newPrivateKey(pad, skSeed, pubSeed, skPrf, 0)
 	if err != nil {
@@ -72,13 +84,14 @@ func (ctx *Context) newPrivateKey(pad scratchPad, skSeed, pubSeed, skPrf []byte,
 		pubSeed: pubSeed,
 		ctx:     ctx,
 		ph:      ctx.precomputeHashes(pubSeed, skSeed),
-	}
 
-	// Create a root tree to retrieve the root.
-	rt := ctx.genRootTree(pad, ret.ph)
+		rootLookahead: defaultRootLookahead,
+	}
 
+	// Build the root tree cache to retrieve the root.
+	ret.rootCache.subH = ctx.defaultRootSubTreeHeight()
 	ret.root = make([]byte, ctx.params.n)
-	copy(ret.root, rt.getRootNode())
+	copy(ret.root, ret.buildRootTreeCache(pad, uint32(seqNo)))
 
 	return &ret, nil
 }
@@ -0,0 +1,104 @@
+package mbpqs
+
+import (
+	"fmt"
+)
+
+/* The secrets of a PrivateKey are its skSeed and skPrf, and everything derived
+ * from them: the precomputed hash state of the skSeed, and the scratchpads
+ * used during key generation and signing, which hold WOTS secret keys. The
+ * scratchpads are zeroed at the end of every operation, the rest is zeroed by
+ * Destroy.
+ *
+ * On Linux, LockMemory moves the skSeed and skPrf out of the Go heap, into
+ * memory which is locked into RAM and excluded from core dumps, such that the
+ * seeds never reach swap. The precomputed hash state and the scratchpads stay
+ * on the Go heap.
+ */
+
+// Destroy zeroes the secrets of the PrivateKey, including its precomputed
+// hashes, the key of its encrypted key file, the state in its
+// MemoryStateStore, and the caches of its root tree and channels. Afterwards,
+// the PrivateKey can not sign anymore, and leaves its key file or other state
+// store untouched. Destroy should only be called once no operation on the
+// PrivateKey is in progress.
+func (sk *PrivateKey) Destroy() {
+	sk.mux.Lock()
+	channels := sk.Channels
+	sk.mux.Unlock()
+
+	// Locking order is channel before PrivateKey, like in Persist.
+	for _, ch := range channels {
+		ch.mux.Lock()
+		defer ch.mux.Unlock()
+	}
+	sk.mux.Lock()
+	defer sk.mux.Unlock()
+	if sk.destroyed {
+		return
+	}
+
+	clear(sk.skSeed)
+	clear(sk.skPrf)
+	if sk.ph.wipeSkSeed != nil {
+		sk.ph.wipeSkSeed()
+	}
+	if w, ok := sk.store.(interface{ wipe() }); ok {
+		w.wipe()
+	}
+	if sk.locked != nil {
+		freeLockedMemory(sk.locked)
+		sk.locked = nil
+	}
+	sk.skSeed, sk.skPrf = nil, nil
+
+	for _, ch := range channels {
+		clear(ch.cache)
+		ch.cache = nil
+	}
+	rtc := &sk.rootCache
+	rtc.mux.Lock()
+	clear(rtc.top.buf)
+	clear(rtc.cur.buf)
+	clear(rtc.next.buf)
+	rtc.top, rtc.cur, rtc.next = rootTree{}, rootTree{}, rootTree{}
+	rtc.built = false
+	rtc.mux.Unlock()
+
+	sk.destroyed = true
+}
+
+// LockMemory moves the skSeed and skPrf of the PrivateKey into memory which
+// is locked into RAM and excluded from core dumps, and zeroes their previous
+// copies. It is only supported on Linux, and should be called right after
+// the PrivateKey is generated or loaded. The memory is released by Destroy.
+func (sk *PrivateKey) LockMemory() error {
+	sk.mux.Lock()
+	defer sk.mux.Unlock()
+	if sk.destroyed {
+		return fmt.Errorf("the private key is destroyed")
+	}
+	if sk.locked != nil {
+		return nil
+	}
+	n := len(sk.skSeed)
+	buf, err := allocLockedMemory(2 * n)
+	if err != nil {
+		return err
+	}
+	copy(buf, sk.skSeed)
+	copy(buf[n:], sk.skPrf)
+	clear(sk.skSeed)
+	clear(sk.skPrf)
+	sk.skSeed, sk.skPrf = buf[:n:n], buf[n:2*n:2*n]
+	sk.locked = buf
+	return nil
+}
+
+// MemoryLocked returns whether the seeds of the PrivateKey are kept in
+// locked memory, see LockMemory.
+func (sk *PrivateKey) MemoryLocked() bool {
+	sk.mux.Lock()
+	defer sk.mux.Unlock()
+	return sk.locked != nil
+}
@@ -0,0 +1,147 @@
+package mbpqs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// Returns whether buf only holds zeroes.
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDestroy(t *testing.T) {
+	for _, hash := range []HashFunction{SHA2, SHAKE256} {
+		path := filepath.Join(t.TempDir(), "orderer.key")
+		p := InitParam(24, 2, 4, 1, 1, 4)
+		p.hash = hash
+		sk, _, err := GenerateKeyPair(p, 0)
+		if err != nil {
+			t.Fatalf("KeyGen failed with error %s", err)
+		}
+		if err = sk.Persist(path); err != nil {
+			t.Fatalf("Persisting key failed with error %s", err)
+		}
+		chIdx, _, err := sk.AddChannel()
+		if err != nil {
+			t.Fatalf("Adding channel failed with error %s", err)
+		}
+		if _, err = sk.SignMsg(chIdx, []byte("Block")); err != nil {
+			t.Fatalf("Signing message failed with error %s", err)
+		}
+		before, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Reading key file failed with error %s", err)
+		}
+
+		var addr address
+		pad := sk.ctx.newScratchPad()
+		prf := make([]byte, sk.ctx.params.n)
+		sk.ph.prfAddrSkSeedInto(pad, addr, prf)
+		skSeed, skPrf, cache := sk.skSeed, sk.skPrf, sk.Channels[chIdx].cache
+		rootCache := sk.rootCache.top.buf
+
+		sk.Destroy()
+		for name, buf := range map[string][]byte{
+			"skSeed":     skSeed,
+			"skPrf":      skPrf,
+			"cache":      cache,
+			"root cache": rootCache,
+		} {
+			if !isZero(buf) {
+				t.Fatalf("%s of %s is not zeroed by Destroy", name, hash)
+			}
+		}
+		// The precomputed state of the skSeed is zeroed as well.
+		wiped := make([]byte, sk.ctx.params.n)
+		sk.ph.prfAddrSkSeedInto(pad, addr, wiped)
+		if bytes.Equal(prf, wiped) {
+			t.Fatalf("Precomputed skSeed hash of %s is not zeroed by Destroy", hash)
+		}
+
+		if _, err = sk.SignMsg(chIdx, []byte("Block")); err == nil {
+			t.Fatal("Signing with a destroyed key did not give an error")
+		}
+		if _, err = sk.GrowChannel(chIdx); err == nil {
+			t.Fatal("Growing with a destroyed key did not give an error")
+		}
+		if _, err = sk.CloseChannel(chIdx); err == nil {
+			t.Fatal("Closing with a destroyed key did not give an error")
+		}
+		if _, _, err = sk.AddChannel(); err == nil {
+			t.Fatal("Adding a channel with a destroyed key did not give an error")
+		}
+		after, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Reading key file failed with error %s", err)
+		}
+		if !bytes.Equal(before, after) {
+			t.Fatal("Destroyed key changed its key file")
+		}
+		// Destroying twice is harmless.
+		sk.Destroy()
+	}
+}
+
+func TestScratchPadWipe(t *testing.T) {
+	sk, _, err := GenerateKeyPair(InitParam(32, 2, 3, 1, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	pad := sk.ctx.newScratchPad()
+	var addr address
+	sk.ctx.wotsSign(pad, make([]byte, sk.ctx.params.n), sk.pubSeed, sk.skSeed, addr)
+	pad.wipe()
+	if !isZero(pad.buf) {
+		t.Fatal("Scratchpad is not zeroed by wipe")
+	}
+}
+
+func TestLockMemory(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 3, 1, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	skSeed := sk.skSeed
+	err = sk.LockMemory()
+	if runtime.GOOS != "linux" {
+		if err == nil {
+			t.Fatal("Locking memory outside Linux did not give an error")
+		}
+		return
+	}
+	if err != nil {
+		t.Skipf("Locking memory is not permitted: %s", err)
+	}
+	if !sk.MemoryLocked() || !isZero(skSeed) {
+		t.Fatal("Seeds are not moved into locked memory")
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+	if err != nil {
+		t.Fatalf("Creating verifier failed with error %s", err)
+	}
+	msg := []byte("Block")
+	sig, err := sk.SignMsg(chIdx, msg)
+	if err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	if accept, err := cv.Verify(sig, msg); !accept || err != nil {
+		t.Fatalf("MsgSignature with locked seeds not accepted: %v", err)
+	}
+	sk.Destroy()
+	if sk.MemoryLocked() {
+		t.Fatal("Locked memory is not released by Destroy")
+	}
+}
@@ -0,0 +1,246 @@
+package mbpqs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+/* A key file can be encrypted with a passphrase. The encrypted key file holds
+ * the state of the PrivateKey, see marshalKeyState, encrypted with AES-256-GCM
+ * under a key derived from the passphrase with scrypt:
+ *
+ *   version || kind || kdf || logN || r || p || salt || nonce || ciphertext
+ *
+ * The header before the ciphertext is authenticated as additional data. The
+ * derived key is kept with the PrivateKey, such that a state change only
+ * costs a fresh nonce instead of a run of scrypt. The file is still replaced
+ * atomically on every state change.
+ */
+
+// Key derivation functions of encrypted key files.
+const kdfScrypt = 1
+
+// Sizes in an encrypted key file.
+const (
+	encryptedKeySaltSize   = 16
+	encryptedKeyNonceSize  = 12
+	encryptedKeyHeaderSize = 12 + encryptedKeySaltSize + encryptedKeyNonceSize
+)
+
+// ScryptParams are the cost parameters of scrypt, which derives the key of
+// an encrypted key file from its passphrase.
+type ScryptParams struct {
+	LogN uint8  // Base 2 logarithm of the CPU and memory cost N.
+	R    uint32 // Block size.
+	P    uint32 // Parallelization.
+}
+
+// DefaultScryptParams are the scrypt parameters recommended for interactive
+// logins, which take about 64 MiB of memory.
+var DefaultScryptParams = ScryptParams{LogN: 16, R: 8, P: 1}
+
+// Bounds of the scrypt parameters, which also apply to the header of an
+// encrypted key file, as it is read before it is authenticated. scrypt takes
+// about 128·r·N bytes of memory, and runs p times over it.
+const (
+	maxScryptMemory = 1 << 30
+	maxScryptP      = 16
+)
+
+// Checks whether scrypt accepts the parameters, and whether they are within
+// the bounds.
+func (sp ScryptParams) check() error {
+	if sp.LogN == 0 || sp.LogN > 30 {
+		return fmt.Errorf("scrypt cost 2^%d is not between 2^1 and 2^30", sp.LogN)
+	}
+	if sp.R == 0 || sp.P == 0 || sp.P > maxScryptP || uint64(sp.R)*uint64(sp.P) >= 1<<30 {
+		return fmt.Errorf("scrypt parameters r = %d and p = %d are not supported", sp.R, sp.P)
+	}
+	if uint64(sp.R) > maxScryptMemory/128>>sp.LogN {
+		return fmt.Errorf("scrypt cost 2^%d with r = %d takes more than %d MiB of memory", sp.LogN, sp.R, maxScryptMemory>>20)
+	}
+	return nil
+}
+
+// The key derived from the passphrase of an encrypted key file.
+type keyFileCipher struct {
+	kdf  ScryptParams
+	salt []byte
+	key  []byte // The 32-byte AES key, zeroed by wipe.
+}
+
+// Derives the key of an encrypted key file from passphrase. A random salt is
+// chosen if salt is nil.
+func newKeyFileCipher(passphrase []byte, kdf ScryptParams, salt []byte) (*keyFileCipher, error) {
+	if err := kdf.check(); err != nil {
+		return nil, err
+	}
+	if salt == nil {
+		var err error
+		if salt, err = randomBytes(encryptedKeySaltSize); err != nil {
+			return nil, err
+		}
+	}
+	key, err := scrypt.Key(passphrase, salt, 1<<kdf.LogN, int(kdf.R), int(kdf.P), 32)
+	if err != nil {
+		return nil, err
+	}
+	return &keyFileCipher{kdf: kdf, salt: salt, key: key}, nil
+}
+
+// Returns the AES-GCM instance of the key.
+func (kc *keyFileCipher) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kc.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypts the state of a PrivateKey into the contents of an encrypted key file.
+func (kc *keyFileCipher) seal(state []byte) ([]byte, error) {
+	aead, err := kc.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomBytes(encryptedKeyNonceSize)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, encryptedKeyHeaderSize, encryptedKeyHeaderSize+len(state)+aead.Overhead())
+	buf[0] = encodingVersion
+	buf[1] = kindEncryptedKey
+	buf[2] = kdfScrypt
+	buf[3] = kc.kdf.LogN
+	binary.BigEndian.PutUint32(buf[4:8], kc.kdf.R)
+	binary.BigEndian.PutUint32(buf[8:12], kc.kdf.P)
+	copy(buf[12:], kc.salt)
+	copy(buf[12+encryptedKeySaltSize:], nonce)
+	return aead.Seal(buf, nonce, state, buf), nil
+}
+
+// Zeroes the key.
+func (kc *keyFileCipher) wipe() {
+	clear(kc.key)
+}
+
+// Decrypts the contents data of an encrypted key file with passphrase.
+// Returns the state of the PrivateKey, and the key to encrypt its next state.
+func openKeyFile(data, passphrase []byte) ([]byte, *keyFileCipher, error) {
+	if len(data) < encryptedKeyHeaderSize {
+		return nil, nil, fmt.Errorf("encoding too short for header (%d bytes)", len(data))
+	}
+	if data[0] != encodingVersion {
+		return nil, nil, fmt.Errorf("unsupported encoding version %d", data[0])
+	}
+	if data[1] != kindEncryptedKey {
+		return nil, nil, fmt.Errorf("encoding holds kind %d instead of %d", data[1], kindEncryptedKey)
+	}
+	if data[2] != kdfScrypt {
+		return nil, nil, fmt.Errorf("unknown key derivation function %d", data[2])
+	}
+	kdf := ScryptParams{
+		LogN: data[3],
+		R:    binary.BigEndian.Uint32(data[4:8]),
+		P:    binary.BigEndian.Uint32(data[8:12]),
+	}
+	salt := append([]byte{}, data[12:12+encryptedKeySaltSize]...)
+	nonce := data[12+encryptedKeySaltSize : encryptedKeyHeaderSize]
+	kc, err := newKeyFileCipher(passphrase, kdf, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := kc.aead()
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := aead.Open(nil, nonce, data[encryptedKeyHeaderSize:], data[:encryptedKeyHeaderSize])
+	if err != nil {
+		kc.wipe()
+		return nil, nil, fmt.Errorf("wrong passphrase, or the key file is corrupted")
+	}
+	return state, kc, nil
+}
+
+// PersistEncrypted saves the PrivateKey to a new key file at path, like
+// Persist, which is encrypted with a key derived from passphrase with scrypt.
+func (sk *PrivateKey) PersistEncrypted(path string, passphrase []byte, kdf ScryptParams) error {
+	kc, err := newKeyFileCipher(passphrase, kdf, nil)
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(path); err == nil {
+		kc.wipe()
+		return fmt.Errorf("key file %s already exists", path)
+	}
+	if err = sk.PersistTo(&keyFile{path: path, enc: kc}); err != nil {
+		kc.wipe()
+		return err
+	}
+	return nil
+}
+
+// LoadEncryptedPrivateKey loads a PrivateKey from the encrypted key file at
+// path, like LoadPrivateKey. Its state changes are saved encrypted under the
+// same passphrase.
+func LoadEncryptedPrivateKey(path string, passphrase []byte, t int) (*PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state, kc, err := openKeyFile(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %s", path, err)
+	}
+	st, err := keyStateFromBytes(state)
+	clear(state)
+	var sk *PrivateKey
+	if err == nil {
+		sk, err = privateKeyFromState(st)
+	}
+	if err != nil {
+		kc.wipe()
+		return nil, fmt.Errorf("key file %s: %s", path, err)
+	}
+	sk.ctx.threads = t
+	sk.store = &keyFile{path: path, enc: kc}
+	return sk, nil
+}
+
+// ChangePassphrase encrypts the key file of the PrivateKey under a new
+// passphrase, with a fresh salt. A key file which is not encrypted yet is
+// encrypted from then on.
+func (sk *PrivateKey) ChangePassphrase(passphrase []byte, kdf ScryptParams) error {
+	kc, err := newKeyFileCipher(passphrase, kdf, nil)
+	if err != nil {
+		return err
+	}
+	sk.mux.Lock()
+	defer sk.mux.Unlock()
+	old, ok := sk.store.(*keyFile)
+	if !ok {
+		kc.wipe()
+		return fmt.Errorf("the private key has no key file, please use PersistEncrypted")
+	}
+	sk.store = &keyFile{path: old.path, enc: kc}
+	if err = sk.saveState(); err != nil {
+		sk.store = old
+		kc.wipe()
+		return err
+	}
+	old.wipe()
+	return nil
+}
+
+// Encrypted returns whether the key file of the PrivateKey is encrypted.
+func (sk *PrivateKey) Encrypted() bool {
+	sk.mux.Lock()
+	defer sk.mux.Unlock()
+	kf, ok := sk.store.(*keyFile)
+	return ok && kf.enc != nil
+}
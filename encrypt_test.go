@@ -0,0 +1,136 @@
+package mbpqs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Cheap scrypt parameters for tests.
+var testScryptParams = ScryptParams{LogN: 4, R: 8, P: 1}
+
+func TestEncryptedKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orderer.key")
+	pass := []byte("correct horse battery staple")
+	sk, pk, err := GenerateKeyPair(InitParam(32, 3, 4, 0, 1, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	skSeed := append([]byte{}, sk.skSeed...)
+	if err = sk.PersistEncrypted(path, pass, testScryptParams); err != nil {
+		t.Fatalf("Persisting encrypted key failed with error %s", err)
+	}
+	if !sk.Encrypted() {
+		t.Fatal("Persisted key is not encrypted")
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	authNode := signAndVerify(t, sk, pk, chIdx, rtSig.NextAuthNode())
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Reading key file failed with error %s", err)
+	}
+	if bytes.Contains(data, skSeed) {
+		t.Fatal("Encrypted key file holds the skSeed")
+	}
+	if _, err = LoadPrivateKey(path, 0); err == nil {
+		t.Fatal("Loading an encrypted key file without passphrase did not give an error")
+	}
+	if _, err = LoadEncryptedPrivateKey(path, []byte("wrong"), 0); err == nil {
+		t.Fatal("Loading an encrypted key file with a wrong passphrase did not give an error")
+	}
+	// The KDF parameters in the header are authenticated.
+	tampered := filepath.Join(t.TempDir(), "tampered.key")
+	data[3]++
+	if err = os.WriteFile(tampered, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadEncryptedPrivateKey(tampered, pass, 0); err == nil {
+		t.Fatal("Loading a key file with altered KDF parameters did not give an error")
+	}
+
+	// The cost in the header is bounded before scrypt runs.
+	data[3] = 22
+	if err = os.WriteFile(tampered, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadEncryptedPrivateKey(tampered, pass, 0); err == nil {
+		t.Fatal("Loading a key file with an excessive scrypt cost did not give an error")
+	}
+
+	// Restart: the channel continues where it was left, and the state stays encrypted.
+	sk, err = LoadEncryptedPrivateKey(path, pass, 0)
+	if err != nil {
+		t.Fatalf("Loading encrypted key failed with error %s", err)
+	}
+	authNode = signAndVerify(t, sk, pk, chIdx, authNode)
+	sk, err = LoadEncryptedPrivateKey(path, pass, 0)
+	if err != nil {
+		t.Fatalf("Loading encrypted key failed with error %s", err)
+	}
+	signAndVerify(t, sk, pk, chIdx, authNode)
+
+	newPass := []byte("another passphrase")
+	if err = sk.ChangePassphrase(newPass, testScryptParams); err != nil {
+		t.Fatalf("Changing passphrase failed with error %s", err)
+	}
+	if _, err = LoadEncryptedPrivateKey(path, pass, 0); err == nil {
+		t.Fatal("Loading with the old passphrase did not give an error")
+	}
+	if _, err = LoadEncryptedPrivateKey(path, newPass, 0); err != nil {
+		t.Fatalf("Loading with the new passphrase failed with error %s", err)
+	}
+	key := sk.store.(*keyFile).enc.key
+	sk.Destroy()
+	if !isZero(key) {
+		t.Fatal("Key of the encrypted key file is not zeroed by Destroy")
+	}
+}
+
+func TestScryptParamsCheck(t *testing.T) {
+	for _, tc := range []struct {
+		sp ScryptParams
+		ok bool
+	}{
+		{DefaultScryptParams, true},
+		{ScryptParams{LogN: 20, R: 8, P: 1}, true},
+		{ScryptParams{LogN: 21, R: 8, P: 1}, false},
+		{ScryptParams{LogN: 14, R: 1 << 10, P: 1}, false},
+		{ScryptParams{LogN: 16, R: 8, P: 16}, true},
+		{ScryptParams{LogN: 16, R: 8, P: 17}, false},
+		{ScryptParams{LogN: 30, R: 1, P: 1}, false},
+		{ScryptParams{LogN: 4, R: 0, P: 1}, false},
+	} {
+		if err := tc.sp.check(); (err == nil) != tc.ok {
+			t.Errorf("Checking %+v gave error %v", tc.sp, err)
+		}
+	}
+}
+
+func TestEncryptPlainKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orderer.key")
+	sk, _, err := GenerateKeyPair(InitParam(32, 2, 3, 0, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	pass := []byte("passphrase")
+	if err = sk.ChangePassphrase(pass, testScryptParams); err == nil {
+		t.Fatal("Encrypting a key without key file did not give an error")
+	}
+	if err = sk.Persist(path); err != nil {
+		t.Fatalf("Persisting key failed with error %s", err)
+	}
+	if err = sk.ChangePassphrase(pass, ScryptParams{LogN: 4}); err == nil {
+		t.Fatal("Encrypting with invalid scrypt parameters did not give an error")
+	}
+	if err = sk.ChangePassphrase(pass, testScryptParams); err != nil {
+		t.Fatalf("Encrypting key file failed with error %s", err)
+	}
+	if _, err = LoadEncryptedPrivateKey(path, pass, 0); err != nil {
+		t.Fatalf("Loading encrypted key failed with error %s", err)
+	}
+}
@@ -0,0 +1,107 @@
+package mbpqs
+
+import (
+	"errors"
+	"fmt"
+)
+
+/* Every verification function rejects a signature with an error, which is a
+ * VerifyError wrapping one of the Err values below, and never accepts it with
+ * a non-nil error. The Err values can be matched with errors.Is:
+ *
+ *   accept, err := pk.VerifyMsg(sig, msg, authNode)
+ *   if errors.Is(err, mbpqs.ErrInvalidSignature) { ... }
+ *
+ * Signatures are decoded from bytes a peer controls, so a signature is
+ * checked to be well-formed for the PublicKey before any hash is computed
+ * with it. A malformed signature is rejected, it never makes a verification
+ * function panic.
+ */
+
+// The reasons a signature is rejected.
+var (
+	// ErrInvalidSignature: the signature does not verify.
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrMalformedSignature: the signature is missing or is not well-formed.
+	ErrMalformedSignature = errors.New("malformed signature")
+	// ErrParamsMismatch: the signature is made with other parameters than
+	// the ones of the PublicKey.
+	ErrParamsMismatch = errors.New("parameters do not match the public key")
+	// ErrMissingAuthNode: no authentication node is given to verify the
+	// signature against.
+	ErrMissingAuthNode = errors.New("authentication node is missing")
+	// ErrWrongChannel: the signature is made in another channel.
+	ErrWrongChannel = errors.New("wrong channel")
+	// ErrWrongLayer: the signature is made with a key of another chain tree.
+	ErrWrongLayer = errors.New("wrong chain tree")
+	// ErrOutOfOrder: the signature is replayed, skips signatures, or is not
+	// the kind of signature the key may make.
+	ErrOutOfOrder = errors.New("signature out of order")
+	// ErrChannelClosed: the channel is closed before the signature.
+	ErrChannelClosed = errors.New("channel is closed")
+)
+
+// VerifyError is the error a signature is rejected with.
+type VerifyError struct {
+	Reason error  // One of the Err values, which tells why the signature is rejected.
+	Detail string // Describes the rejected signature, may be empty.
+}
+
+func (e *VerifyError) Error() string {
+	if e.Detail == "" {
+		return e.Reason.Error()
+	}
+	return e.Reason.Error() + ": " + e.Detail
+}
+
+// Unwrap returns the reason the signature is rejected.
+func (e *VerifyError) Unwrap() error {
+	return e.Reason
+}
+
+// Returns a VerifyError for reason, with the formatted detail.
+func verifyError(reason error, format string, a ...interface{}) error {
+	return &VerifyError{Reason: reason, Detail: fmt.Sprintf(format, a...)}
+}
+
+// Checks that sig is a well-formed signature with the parameters of the
+// PublicKey, such that verifying it can not panic.
+func (pk *PublicKey) checkSignature(sig Signature) error {
+	ctx := signatureContext(sig)
+	if ctx == nil {
+		return verifyError(ErrMalformedSignature, "%T without context", sig)
+	}
+	if *ctx.params != *pk.ctx.params {
+		return verifyError(ErrParamsMismatch, "signature is made with %s instead of %s", ctx.params, pk.ctx.params)
+	}
+	n := int(ctx.params.n)
+	wotsSize := int(ctx.wotsSigBytes)
+	var layer, chainSeqNo uint32
+	var sized bool
+	switch s := sig.(type) {
+	case *RootSignature:
+		if uint64(s.seqNo) >= uint64(1)<<ctx.params.rootH {
+			return verifyError(ErrMalformedSignature, "RootSignature of root tree leaf %d", s.seqNo)
+		}
+		if len(s.wotsSig) != wotsSize || len(s.authPath) != int(ctx.params.rootH)*n || len(s.rootHash) != n {
+			return verifyError(ErrMalformedSignature, "RootSignature fields have the wrong size")
+		}
+		return nil
+	case *GrowSignature:
+		layer, chainSeqNo = s.layer, s.chainSeqNo
+		sized = len(s.wotsSig) == wotsSize && len(s.rootHash) == n
+	case *MsgSignature:
+		layer, chainSeqNo = s.layer, s.chainSeqNo
+		sized = len(s.drv) == n && len(s.wotsSig) == wotsSize && len(s.authPath) == n
+	case *CloseSignature:
+		layer, chainSeqNo = s.layer, s.chainSeqNo
+		sized = len(s.wotsSig) == wotsSize && len(s.authPath) == n
+	}
+	if !sized {
+		return verifyError(ErrMalformedSignature, "%T fields have the wrong size", sig)
+	}
+	if layer == 0 || chainSeqNo >= ctx.chainTreeHeight(layer) {
+		return verifyError(ErrMalformedSignature, "%T of key %d in chain tree %d", sig, chainSeqNo, layer)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package mbpqs
+
+import (
+	"errors"
+	"testing"
+)
+
+// Checks that a verification result is a rejection with the given reason.
+func expectReject(t *testing.T, what string, accept bool, err, reason error) {
+	t.Helper()
+	if accept || !errors.Is(err, reason) {
+		t.Fatalf("%s gave %v, %v instead of a rejection with %q", what, accept, err, reason)
+	}
+	var ve *VerifyError
+	if !errors.As(err, &ve) {
+		t.Fatalf("%s gave a %T instead of a VerifyError", what, err)
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 3, 0, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	msg := []byte("Block in the channel")
+	sig, err := sk.SignMsg(chIdx, msg)
+	if err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	authNode := rtSig.NextAuthNode()
+
+	// Signatures which do not verify.
+	forgedRt := *rtSig
+	forgedRt.rootHash = append([]byte{}, rtSig.rootHash...)
+	forgedRt.rootHash[0] ^= 1
+	accept, err := pk.VerifyChannel(&forgedRt)
+	expectReject(t, "Forged RootSignature", accept, err, ErrInvalidSignature)
+	accept, err = pk.VerifyMsg(sig, []byte("Forged block"), authNode)
+	expectReject(t, "Forged message", accept, err, ErrInvalidSignature)
+	accept, err = pk.VerifyMsg(sig, msg, sig.NextAuthNode(authNode))
+	expectReject(t, "Wrong authentication node", accept, err, ErrInvalidSignature)
+
+	// Malformed signatures and missing input, as a peer may send them.
+	truncated := *sig
+	truncated.wotsSig = sig.wotsSig[:10]
+	noLayer := *sig
+	noLayer.layer = 0
+	beyond := *sig
+	beyond.chainSeqNo = 1000
+	leaf := *rtSig
+	leaf.seqNo = 4
+	for name, s := range map[string]Signature{
+		"Missing signature":                  nil,
+		"Nil MsgSignature":                   (*MsgSignature)(nil),
+		"Empty GrowSignature":                &GrowSignature{},
+		"Empty CloseSignature":               &CloseSignature{},
+		"Truncated MsgSignature":             &truncated,
+		"MsgSignature in layer 0":            &noLayer,
+		"MsgSignature beyond its chain tree": &beyond,
+	} {
+		accept, err = pk.Verify(s, msg, authNode)
+		expectReject(t, name, accept, err, ErrMalformedSignature)
+	}
+	accept, err = pk.VerifyChannel(&leaf)
+	expectReject(t, "RootSignature of a leaf outside the root tree", accept, err, ErrMalformedSignature)
+	accept, err = pk.VerifyChannel(&RootSignature{})
+	expectReject(t, "Empty RootSignature", accept, err, ErrMalformedSignature)
+	accept, err = pk.Verify(sig, msg)
+	expectReject(t, "MsgSignature without authentication node", accept, err, ErrMissingAuthNode)
+	accept, err = pk.VerifyMsg(sig, msg, nil)
+	expectReject(t, "MsgSignature with nil authentication node", accept, err, ErrMissingAuthNode)
+	accept, err = pk.VerifyProof(nil, msg)
+	expectReject(t, "Missing proof", accept, err, ErrMalformedSignature)
+	accept, err = pk.VerifySuccessor(&SuccessionCertificate{})
+	expectReject(t, "Empty succession certificate", accept, err, ErrMalformedSignature)
+
+	// A signature with other parameters than the public key.
+	other, _, err := GenerateKeyPair(InitParam(32, 2, 3, 0, 0, 16), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	_, otherRtSig, err := other.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	accept, err = pk.VerifyChannel(otherRtSig)
+	expectReject(t, "RootSignature with other parameters", accept, err, ErrParamsMismatch)
+
+	// Signatures out of place in a ChannelVerifier.
+	_, err = pk.NewChannelVerifier(chIdx+1, rtSig)
+	expectReject(t, "RootSignature of an earlier channel", false, err, ErrWrongChannel)
+	cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+	if err != nil {
+		t.Fatalf("Creating verifier failed with error %s", err)
+	}
+	otherChannel := *sig
+	otherChannel.chIdx++
+	accept, err = cv.VerifyMsg(&otherChannel, msg)
+	expectReject(t, "MsgSignature of another channel", accept, err, ErrWrongChannel)
+	otherLayer := *sig
+	otherLayer.layer = 2
+	accept, err = cv.VerifyMsg(&otherLayer, msg)
+	expectReject(t, "MsgSignature of another chain tree", accept, err, ErrWrongLayer)
+	accept, err = cv.Verify(rtSig, nil)
+	expectReject(t, "Second RootSignature", accept, err, ErrOutOfOrder)
+	if accept, err = cv.VerifyMsg(sig, msg); !accept || err != nil {
+		t.Fatalf("Correct MsgSignature not accepted: %v", err)
+	}
+	accept, err = cv.VerifyMsg(sig, msg)
+	expectReject(t, "Replayed MsgSignature", accept, err, ErrOutOfOrder)
+	accept, err = cv.VerifyAutoGrow(nil, msg)
+	expectReject(t, "Missing AutoGrowSignature", accept, err, ErrMalformedSignature)
+	cs, err := sk.CloseChannel(chIdx)
+	if err != nil {
+		t.Fatalf("Closing channel failed with error %s", err)
+	}
+	if accept, err = cv.Verify(cs, nil); !accept || err != nil {
+		t.Fatalf("Correct CloseSignature not accepted: %v", err)
+	}
+	accept, err = cv.Verify(cs, nil)
+	expectReject(t, "Signature after the CloseSignature", accept, err, ErrChannelClosed)
+}
@@ -0,0 +1,9 @@
+module github.com/Breus/mbpqs
+
+go 1.24.0
+
+require (
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.48.0
+	golang.org/x/sys v0.41.0
+)
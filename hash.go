@@ -1,13 +1,11 @@
 package mbpqs
 
 import (
-	"crypto/sha256"
-	"crypto/sha512"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
 	"hash"
 	"io"
-	"reflect"
-
-	"github.com/templexxx/xor"
 )
 
 const (
@@ -21,6 +19,20 @@ const (
 	hashPaddingPRF = 3
 )
 
+/* The hash functions F, H, H_msg and PRF are all instantiated with a single
+ * underlying hash function, the hash backend. The backend is selected by the
+ * HashFunction in the Params, see hash_sha2.go and hash_shake.go.
+ */
+type hashBackend interface {
+	// Returns a new hash, which appends n-byte digests on Sum.
+	newHash() hash.Hash
+	// Returns a function which computes the n-byte digest of prefix || suffix
+	// into out, by restoring the precomputed state after the prefix into the
+	// hash h. The hash h must be created by newHash of the same backend.
+	// The second function zeroes the precomputed state.
+	precompute(prefix []byte) (func(h hash.Hash, suffix, out []byte), func())
+}
+
 /* Many of the hashes computed by MBPQS share the same prefix (pubSeed or skSeed).
  * Instead of computing the digest of this prefix over and over again, we can precompute the state of the hash after consuming these prefixes.
  * This struct contains the functions that encapsulate the precomputed hashes.
@@ -31,91 +43,76 @@ type precomputedHashes struct {
 
 	// Precomputed prfAddrInto for the current skSeed.
 	prfAddrSkSeedInto func(pad scratchPad, addr address, out []byte)
+
+	// Zeroes the precomputed state of the skSeed, which is as secret as the skSeed.
+	wipeSkSeed func()
+
+	// SHA-256 state after the prefix of the PRF keyed with pubSeed, if the
+	// WOTS+ chains are advanced by the multi-lane backend, see sha256x8.go.
+	pubSeedLanes *[8]uint32
 }
 
 // Scratchpad for hashing operations. Has pre-allocated memory to avoid many memory allocations.
 type hashScratchPad struct {
-	// Defines the hash function.
+	// Defines the hash function, created by the hash backend.
 	h hash.Hash
-	// Hash value, holds the hash (digest) value.
-	hVal reflect.Value
 }
 
 // This function initializes the precomputedHashes with their precomputed values.
 func (ctx *Context) precomputeHashes(pubSeed, skSeed []byte) (
 	ph precomputedHashes) {
-	var hashPrfSk, hashPrfPub hash.Hash
-	if ctx.params.n == 32 {
-		hashPrfSk = sha256.New()
-		hashPrfPub = sha256.New()
-	} else { // n = 64
-		hashPrfSk = sha512.New()
-		hashPrfPub = sha512.New()
-	}
-
-	// Add paddingPRF and skSeed to the running hash.
-	if skSeed != nil {
-		hashPrfSk.Write(encodeUint64(hashPaddingPRF, int(ctx.params.n)))
-		hashPrfSk.Write(skSeed)
-	}
+	// Both prefixes are paddingPRF followed by the seed.
+	prefix := make([]byte, ctx.hashPadLen+ctx.params.n)
+	encodeUint64Into(hashPaddingPRF, prefix[:ctx.hashPadLen])
 
-	hashPrfPub.Write(encodeUint64(hashPaddingPRF, int(ctx.params.n)))
-	hashPrfPub.Write(pubSeed)
-
-	/* See https://stackoverflow.com/questions/45385707/ for why this trick is prefered.
-	 * This might break if sha{256,512}.digest is changed later.
-	 */
-	hashValPrfPub := reflect.ValueOf(hashPrfPub).Elem()
-	hashValPrfSk := reflect.ValueOf(hashPrfSk).Elem()
+	copy(prefix[ctx.hashPadLen:], pubSeed)
+	prfPub, _ := ctx.hash.precompute(prefix)
 	ph.prfAddrPubSeedInto = func(pad scratchPad, addr address, out []byte) {
-		// Write the precomputed hash value on the hashPad.
-		pad.hashPad.hVal.Set(hashValPrfPub)
-
 		// Write the latest hash function state (with addr) on the hashPad.
 		addrBuf := pad.prfAddrBuf()
 		addr.writeInto(addrBuf)
-		pad.hashPad.h.Write(addrBuf)
-
-		// hash.Sum appends the hash to the input byte slice. As our input
-		// byte slice hash enough capacity, it will write it in/out in there.
-		pad.hashPad.h.Sum(out[:0])
-
+		prfPub(pad.hashPad.h, addrBuf, out)
 	}
+	ph.pubSeedLanes = ctx.pubSeedLaneState(pubSeed)
 	if skSeed == nil {
 		return
 	}
 
+	copy(prefix[ctx.hashPadLen:], skSeed)
+	prfSk, wipe := ctx.hash.precompute(prefix)
+	clear(prefix)
+	ph.wipeSkSeed = wipe
 	ph.prfAddrSkSeedInto = func(pad scratchPad, addr address, out []byte) {
 		// This is exactly the same as for the pubSeed, but now for the skSeed.
-		pad.hashPad.hVal.Set(hashValPrfSk)
 		addrBuf := pad.prfAddrBuf()
 		addr.writeInto(addrBuf)
-		pad.hashPad.h.Write(addrBuf)
-		pad.hashPad.h.Sum(out[:0]) // Again, in/out on the input.
+		prfSk(pad.hashPad.h, addrBuf, out)
 	}
 
 	return
 }
 
+// Zeroes the state of the hash, which may hold secrets. The hash can not be
+// used afterwards.
+func (pad hashScratchPad) wipe() {
+	if w, ok := pad.h.(interface{ wipe() }); ok {
+		w.wipe()
+	}
+}
+
 // Compute the hash of in(put) into out, which must be a n-byte slice.
 func (ctx *Context) hashInto(pad scratchPad, in, out []byte) {
-	if ctx.params.n == 32 {
-		ret := sha256.Sum256(in)
-		copy(out, ret[:])
-	} else { // N == 64
-		ret := sha512.Sum512(in)
-		copy(out, ret[:])
-	}
+	h := pad.hashPad.h
+	h.Reset()
+	h.Write(in)
+	// hash.Sum appends the hash to the input byte slice. As our input
+	// byte slice has enough capacity, it will write it in/out in there.
+	h.Sum(out[:0])
 }
 
 // Creating a newHashScratchPad for the appropriate hash function.
 func (ctx *Context) newHashScratchPad() (pad hashScratchPad) {
-	if ctx.params.n == 32 {
-		pad.h = sha256.New()
-	} else { // n == 64
-		pad.h = sha512.New()
-	}
-	pad.hVal = reflect.ValueOf(pad.h).Elem()
+	pad.h = ctx.hash.newHash()
 	return
 }
 
@@ -143,16 +140,17 @@ func (ctx *Context) f(in, pubSeed []byte, addr address) []byte {
 
 // Compute F used in WOTS and put it into out
 func (ctx *Context) fInto(pad scratchPad, in []byte, ph precomputedHashes, addr address, out []byte) {
-	buf := pad.fBuf()
-	encodeUint64Into(hashPaddingF, buf[:ctx.params.n])
+	n, p := ctx.params.n, ctx.hashPadLen
+	buf := pad.fBuf()[:p+2*n]
+	encodeUint64Into(hashPaddingF, buf[:p])
 	// Generate the n byte key.
 	addr.setKeyAndMask(0)
-	ph.prfAddrPubSeedInto(pad, addr, buf[ctx.params.n:ctx.params.n*2])
+	ph.prfAddrPubSeedInto(pad, addr, buf[p:p+n])
 	// Generate the n byte bitmask.
 	addr.setKeyAndMask(1)
-	ph.prfAddrPubSeedInto(pad, addr, buf[2*ctx.params.n:])
+	ph.prfAddrPubSeedInto(pad, addr, buf[p+n:])
 	// Xor the input with the bitmask in place.
-	xor.BytesSameLen(buf[2*ctx.params.n:], in, buf[2*ctx.params.n:])
+	subtle.XORBytes(buf[p+n:], in, buf[p+n:])
 	ctx.hashInto(pad, buf, out)
 }
 
@@ -168,25 +166,26 @@ func (ctx *Context) h(left, right, pubSeed []byte, addr address) []byte {
 
 func (ctx *Context) hInto(pad scratchPad, left, right []byte,
 	ph precomputedHashes, addr address, out []byte) {
+	n, p := ctx.params.n, ctx.hashPadLen
 	// Working in the hBuf from the scratchpad to avoid allocations.
-	buf := pad.hBuf()
-	// First n-byte padding including the type number.
-	encodeUint64Into(hashPaddingH, buf[:ctx.params.n])
+	buf := pad.hBuf()[:p+3*n]
+	// First padding including the type number.
+	encodeUint64Into(hashPaddingH, buf[:p])
 	// Generate n-byte key, so keyAndMask = 0
 	addr.setKeyAndMask(0)
 	// Place the generated key on the scratchpad.
-	ph.prfAddrPubSeedInto(pad, addr, buf[ctx.params.n:ctx.params.n*2])
+	ph.prfAddrPubSeedInto(pad, addr, buf[p:p+n])
 	// Now we generated the 2n-byte masking value and put it on the scratchpad.
 	// First the most significant n-bytes:
 	addr.setKeyAndMask(1)
-	ph.prfAddrPubSeedInto(pad, addr, buf[ctx.params.n*2:ctx.params.n*3])
+	ph.prfAddrPubSeedInto(pad, addr, buf[p+n:p+2*n])
 	// Least-significant n-bytes of the 2n-byte mask:
 	addr.setKeyAndMask(2)
-	ph.prfAddrPubSeedInto(pad, addr, buf[ctx.params.n*3:])
+	ph.prfAddrPubSeedInto(pad, addr, buf[p+2*n:])
 
 	// Xorring 2n-byte mask r with the input.
-	xor.BytesSameLen(buf[ctx.params.n*2:ctx.params.n*3], left, buf[ctx.params.n*2:ctx.params.n*3])
-	xor.BytesSameLen(buf[ctx.params.n*3:], right, buf[ctx.params.n*3:])
+	subtle.XORBytes(buf[p+n:p+2*n], left, buf[p+n:p+2*n])
+	subtle.XORBytes(buf[p+2*n:], right, buf[p+2*n:])
 
 	ctx.hashInto(pad, buf, out)
 }
@@ -194,6 +193,12 @@ func (ctx *Context) hInto(pad scratchPad, left, right []byte,
 // Compute H_msg(toByte(2,32) || KEY(3n) || i(*))
 // Randomized hasher.
 func (ctx *Context) hashMessage(pad scratchPad, msg,
+	R, root []byte, idx uint64) ([]byte, error) {
+	return ctx.hashMessageReader(pad, bytes.NewReader(msg), R, root, idx)
+}
+
+// Compute H_msg(toByte(2,32) || KEY(3n) || i(*)) over the message read from msg.
+func (ctx *Context) hashMessageReader(pad scratchPad, msg io.Reader,
 	R, root []byte, idx uint64) ([]byte, error) {
 	ret := make([]byte, ctx.params.n)
 	err := ctx.hashMessageInto(pad, msg, R, root, idx, ret)
@@ -203,28 +208,21 @@ func (ctx *Context) hashMessage(pad scratchPad, msg,
 	return ret, nil
 }
 
-func (ctx *Context) hashMessageInto(pad scratchPad, msg,
+// Streams the message from msg into H_msg, and puts the digest into out.
+func (ctx *Context) hashMessageInto(pad scratchPad, msg io.Reader,
 	R, root []byte, idx uint64, out []byte) error {
-	var h io.Writer
-	if ctx.params.n == 32 {
-		h = sha256.New()
-	} else { // n == 64
-		h = sha512.New()
-	}
+	h := ctx.hash.newHash()
 	// Same as reference XMSS implementation: padding | R | root | indx | M
-	h.Write(encodeUint64(hashPaddingHashMsg, int(ctx.params.n)))
+	h.Write(encodeUint64(hashPaddingHashMsg, int(ctx.hashPadLen)))
 	h.Write(R)
 	h.Write(root)
 	h.Write(encodeUint64(idx, int(ctx.params.n)))
 
-	//TODO: check if equal
-	h.Write(msg)
-	// _, err := io.Copy(h, msg)
-	// if err != nil {
-	// 	return err
-	// }
+	if _, err := io.Copy(h, msg); err != nil {
+		return fmt.Errorf("reading message failed: %s", err)
+	}
 
-	(h.(hash.Hash)).Sum(out[:0])
+	h.Sum(out[:0])
 	return nil
 }
 
@@ -237,13 +235,14 @@ func (ctx *Context) prfUint64(pad scratchPad, i uint64, key []byte) []byte {
 
 // Compute PRF(toByte(3,32 || KEY ||i) into out
 func (ctx *Context) prfUint64Into(pad scratchPad, i uint64, key, out []byte) {
-	buf := pad.prfBuf()
-	// Put the n-byte padding into the buffer.
-	encodeUint64Into(hashPaddingPRF, buf[:ctx.params.n])
+	n, p := ctx.params.n, ctx.hashPadLen
+	buf := pad.prfBuf()[:p+n+32]
+	// Put the padding into the buffer.
+	encodeUint64Into(hashPaddingPRF, buf[:p])
 	// Append the n-byte key to it.
-	copy(buf[ctx.params.n:], key)
+	copy(buf[p:], key)
 	// Append the input i to it.
-	encodeUint64Into(i, buf[ctx.params.n*2:])
+	encodeUint64Into(i, buf[p+n:])
 	// Hash it into out.
 	ctx.hashInto(pad, buf, out)
 }
@@ -257,9 +256,10 @@ func (ctx *Context) prfAddr(pad scratchPad, addr address, key []byte) []byte {
 
 // Compute PRF(toByte(3,32) || KEY || ADDR) and store into out
 func (ctx *Context) prfAddrInto(pad scratchPad, addr address, key, out []byte) {
-	buf := pad.prfBuf()
-	encodeUint64Into(hashPaddingPRF, buf[:ctx.params.n])
-	copy(buf[ctx.params.n:], key)
-	addr.writeInto(buf[ctx.params.n*2:])
+	n, p := ctx.params.n, ctx.hashPadLen
+	buf := pad.prfBuf()[:p+n+32]
+	encodeUint64Into(hashPaddingPRF, buf[:p])
+	copy(buf[p:], key)
+	addr.writeInto(buf[p+n:])
 	ctx.hashInto(pad, buf, out)
 }
@@ -0,0 +1,83 @@
+package mbpqs
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"reflect"
+)
+
+/* The SHA-2 hash backend uses SHA-256 for n = 32, SHA-512 for n = 64, and
+ * SHA-256 truncated to 192 bits (SHA-256/192 from NIST SP 800-208) for n = 24.
+ */
+type sha2Backend struct {
+	n   int
+	new func() hash.Hash // Creates the underlying SHA-2 hash.
+}
+
+// A SHA-2 hash which appends n-byte digests on Sum. It keeps a reflect.Value
+// of its digest state, such that precomputed states can be restored into it.
+type sha2Hash struct {
+	hash.Hash
+	n    int
+	hVal reflect.Value
+	sum  [sha512.Size]byte
+}
+
+// Returns the SHA-2 hash backend for n-byte digests.
+func newSHA2Backend(n uint32) hashBackend {
+	if n == 64 {
+		return &sha2Backend{n: 64, new: sha512.New}
+	}
+	return &sha2Backend{n: int(n), new: sha256.New}
+}
+
+func (b *sha2Backend) newHash() hash.Hash {
+	h := b.new()
+	return &sha2Hash{
+		Hash: h,
+		n:    b.n,
+		hVal: reflect.ValueOf(h).Elem(),
+	}
+}
+
+func (b *sha2Backend) precompute(prefix []byte) (func(h hash.Hash, suffix, out []byte), func()) {
+	ph := b.new()
+	ph.Write(prefix)
+
+	/* See https://stackoverflow.com/questions/45385707/ for why this trick is prefered.
+	 * This might break if sha{256,512}.digest is changed later.
+	 */
+	hashVal := reflect.ValueOf(ph).Elem()
+	compute := func(h hash.Hash, suffix, out []byte) {
+		sh := h.(*sha2Hash)
+		// Write the precomputed hash value on the hash.
+		sh.hVal.Set(hashVal)
+		sh.Write(suffix)
+		sh.Sum(out[:0])
+	}
+	wipe := func() {
+		// The zero digest also clears the buffered part of the prefix.
+		hashVal.Set(reflect.Zero(hashVal.Type()))
+	}
+	return compute, wipe
+}
+
+// Size returns the size of the (truncated) digest.
+func (h *sha2Hash) Size() int {
+	return h.n
+}
+
+// Sum appends the (truncated) digest to b.
+func (h *sha2Hash) Sum(b []byte) []byte {
+	if h.n == h.Hash.Size() {
+		return h.Hash.Sum(b)
+	}
+	return append(b, h.Hash.Sum(h.sum[:0])[:h.n]...)
+}
+
+// Zeroes the digest state and the truncation buffer.
+func (h *sha2Hash) wipe() {
+	h.hVal.Set(reflect.Zero(h.hVal.Type()))
+	clear(h.sum[:])
+}
@@ -0,0 +1,89 @@
+package mbpqs
+
+import (
+	"crypto/sha3"
+	"hash"
+)
+
+/* The SHAKE256 hash backend uses SHAKE256 with n-byte output, which is
+ * SHAKE256/192 for n = 24 and SHAKE256/256 for n = 32 from NIST SP 800-208,
+ * and SHAKE256 for n = 64 from RFC 8391.
+ */
+type shakeBackend struct {
+	n int
+}
+
+// A SHAKE256 instance which appends n-byte digests on Sum.
+type shakeHash struct {
+	s sha3.SHAKE
+	n int
+}
+
+// Returns the SHAKE256 hash backend for n-byte digests.
+func newSHAKEBackend(n uint32) hashBackend {
+	return &shakeBackend{n: int(n)}
+}
+
+func (b *shakeBackend) newHash() hash.Hash {
+	return &shakeHash{s: *sha3.NewSHAKE256(), n: b.n}
+}
+
+func (b *shakeBackend) precompute(prefix []byte) (func(h hash.Hash, suffix, out []byte), func()) {
+	ph := sha3.NewSHAKE256()
+	ph.Write(prefix)
+	// The state of SHAKE is a plain value, which can simply be copied.
+	state := *ph
+	ph.Reset()
+	compute := func(h hash.Hash, suffix, out []byte) {
+		sh := h.(*shakeHash)
+		sh.s = state
+		sh.s.Write(suffix)
+		sh.s.Read(out[:sh.n])
+	}
+	wipe := func() {
+		state = sha3.SHAKE{}
+	}
+	return compute, wipe
+}
+
+func (h *shakeHash) Write(p []byte) (int, error) {
+	return h.s.Write(p)
+}
+
+// Sum appends the n-byte output to b, without changing the state.
+func (h *shakeHash) Sum(b []byte) []byte {
+	s := h.s
+	ret, out := sliceForAppend(b, h.n)
+	s.Read(out)
+	return ret
+}
+
+func (h *shakeHash) Reset() {
+	h.s.Reset()
+}
+
+// Zeroes the state of the SHAKE instance.
+func (h *shakeHash) wipe() {
+	h.s = sha3.SHAKE{}
+}
+
+// Size returns the size of the output.
+func (h *shakeHash) Size() int {
+	return h.n
+}
+
+func (h *shakeHash) BlockSize() int {
+	return h.s.BlockSize()
+}
+
+// Extends in with n bytes. Returns the extended slice and its last n bytes.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
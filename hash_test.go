@@ -28,6 +28,9 @@ func testHashMessage(ctx *Context, expect string, t *testing.T) {
 func TestHashMessage(t *testing.T) {
 	testHashMessage(NewContextFromOid(1), "153f0c190e9e929f680c61757f1a8e48c6f532d2fef936b4227d9c99aa05efdf", t)
 	testHashMessage(NewContextFromOid(4), "231602b3934f501086caf489aaa191befaed2b10bbc211b0516a96f11c76481383600892e4da35f20ccb6c252e1cbfb00640303efb235101b8d541544f74dce4", t)
+	testHashMessage(NewContextFromOid(6), "ac52c2da514f0a3c2df13457c7a788149e6fbf78a4d2a314", t)
+	testHashMessage(NewContextFromOid(9), "9432f0a85e1671f8783d7a4a489e8a7f8c407d31e9b940dfa9fbbaa127545bde", t)
+	testHashMessage(NewContextFromOid(12), "8235435234b7aca00cc101ea9c63c9e603f87b21a8bcfc17", t)
 }
 
 func testPrf(ctx *Context, expect string, t *testing.T) {
@@ -48,6 +51,9 @@ func testPrf(ctx *Context, expect string, t *testing.T) {
 func TestPrf(t *testing.T) {
 	testPrf(NewContextFromOid(1), "c2d06093b5c98d5a6274066c923e194f18e53eeaf533bca12b92b789eb6866f0", t)
 	testPrf(NewContextFromOid(4), "15a9ffa22a35fdf1308f08d7bfff0b049b3e4e93bbc1252f56846c775ccb00e6476073f6b02f2aba9ea514d497f6a4e71799e32ef2dfbb1f83b189f16d2acfa8", t)
+	testPrf(NewContextFromOid(6), "2bac4683bb78defdcbec29d958ad13f503d38232b51ff0d7", t)
+	testPrf(NewContextFromOid(9), "13897db10a2008ba8d0a45add7e9ccc1a562811de497d6569eed934ff8c6a488", t)
+	testPrf(NewContextFromOid(12), "ff6d109e006167320e6fdaf254c0eded08f1bd9f24f1caef", t)
 }
 
 func testF(ctx *Context, expect string, t *testing.T) {
@@ -70,6 +76,9 @@ func testF(ctx *Context, expect string, t *testing.T) {
 func TestF(t *testing.T) {
 	testF(NewContextFromOid(1), "81d77ae441c1daa5eee9897a826266dc3cc03cf2d7e1393391467655965cd7e9", t)
 	testF(NewContextFromOid(4), "4bc706c40b665a2e30ea47f1997a785c0e09295ae85687023e829b49f6ec95ea0cf5aaab320d4b8f0c215ce76acec674c7becade6d7eab4abd971cc3bed680aa", t)
+	testF(NewContextFromOid(6), "96e1b0f70b510ad620520987733804901038495f15aa7713", t)
+	testF(NewContextFromOid(9), "019842eb6814f8c4b5d8bc005b42cf166b839726613570f900f34688b08e59c5", t)
+	testF(NewContextFromOid(12), "ac64abc7f3d5c0f39d255128e29c0edb0db64d792ecd896a", t)
 }
 
 func testH(ctx *Context, expect string, t *testing.T) {
@@ -94,4 +103,49 @@ func testH(ctx *Context, expect string, t *testing.T) {
 func TestH(t *testing.T) {
 	testH(NewContextFromOid(1), "6ed9fa805fc4aa2ee130be19801ce4a232b002ea709a915dbe0beddb11eca4e9", t)
 	testH(NewContextFromOid(4), "cd341b0001f4adb53bedb31e3e54e4f4a2e520daf6d6bfeb1f2fbb5982f40adaa2c1e8b715b72644bf49b016404273ebf94ebe5b0d1911e9478ac94cd2aec537", t)
+	testH(NewContextFromOid(6), "0c861d4048d5b973bf9f0bcc32749e83762c1a82a9d98973", t)
+	testH(NewContextFromOid(9), "616ea92592db491b379746532f2d0f64db5698931f8f53e09a698e1e5f5dccc3", t)
+	testH(NewContextFromOid(12), "1af2a790b6946c2fb34692243571ba4feea61ef570ba6264", t)
+}
+
+// Generate, sign and verify with n=24, where the masks are xored at offsets
+// which are not a multiple of n.
+func TestHash24RoundTrip(t *testing.T) {
+	msg := []byte("Block signed with a 24-byte hash")
+	for _, hash := range []HashFunction{SHA2, SHAKE256} {
+		for _, w := range []uint16{4, 16, 256} {
+			p := InitParamHash(24, 3, 4, 1, 0, w, hash)
+			sk, pk, err := GenerateKeyPair(p, 0)
+			if err != nil {
+				t.Fatalf("KeyGen with %s failed with error %s", p, err)
+			}
+			chIdx, rtSig, err := sk.AddChannel()
+			if err != nil {
+				t.Fatalf("Adding channel with %s failed with error %s", p, err)
+			}
+			cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+			if err != nil {
+				t.Fatalf("Verifying RootSignature with %s failed with error %s", p, err)
+			}
+			for i := 0; i < 3; i++ {
+				sig, err := sk.SignChannelMsg(chIdx, msg)
+				if err != nil {
+					t.Fatalf("Signing with %s failed with error %s", p, err)
+				}
+				if accept, err := cv.VerifyMsg(sig, msg); !accept || err != nil {
+					t.Fatalf("Correct MsgSignature with %s not accepted: %v", p, err)
+				}
+				if accept, _ := cv.VerifyMsg(sig, []byte("Another block")); accept {
+					t.Fatalf("MsgSignature with %s accepted for another message", p)
+				}
+			}
+			growSig, err := sk.GrowChannel(chIdx)
+			if err != nil {
+				t.Fatalf("Growing channel with %s failed with error %s", p, err)
+			}
+			if accept, err := cv.VerifyGrow(growSig); !accept || err != nil {
+				t.Fatalf("Correct GrowSignature with %s not accepted: %v", p, err)
+			}
+		}
+	}
 }
@@ -0,0 +1,371 @@
+package mbpqs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+/* The journal of a PrivateKey is an append-only file which records every
+ * one-time key the PrivateKey uses, with the digest it signs. A key is
+ * recorded after it is reserved in the state store, before the state which
+ * counts it as used is saved, and before its signature is made. No signature
+ * is released for a key which is not recorded, and a failing journal leaves
+ * channel keys unused. If saving the state fails after a key is recorded, the
+ * key is recorded again with the same digest when the signature is retried,
+ * which the auditor does not count as reuse: signing the same digest again
+ * reveals nothing new. The journal is:
+ *
+ *   header || root || entry_0 || entry_1 || ...
+ *
+ * where every entry is encoded as:
+ *
+ *   kind || chIdx || layer || index || seqNo || digest || hash
+ *
+ * The hash of an entry is SHA-256 over the hash of the previous entry and the
+ * entry without its hash; the first entry chains to SHA-256 over the header
+ * and root. Altering or removing an entry breaks the chain. Removing the last
+ * entries does not, which is why the auditor compares the journal with the
+ * state of the PrivateKey, and why the hash of the last entry may be published
+ * with Head.
+ */
+
+// JournalKind tells what a journal entry is signed for.
+type JournalKind uint8
+
+// Kinds of journal entries.
+const (
+	JournalRoot      JournalKind = 1 // A root tree leaf signs a channel root.
+	JournalMsg       JournalKind = 2 // A chain tree key signs a message.
+	JournalGrow      JournalKind = 3 // The last key of a chain tree signs the next chain tree.
+	JournalClose     JournalKind = 4 // A chain tree key signs the closure of its channel.
+	JournalSuccessor JournalKind = 5 // The last root tree leaf signs the successor.
+)
+
+func (k JournalKind) String() string {
+	switch k {
+	case JournalRoot:
+		return "root"
+	case JournalMsg:
+		return "msg"
+	case JournalGrow:
+		return "grow"
+	case JournalClose:
+		return "close"
+	case JournalSuccessor:
+		return "successor"
+	}
+	return fmt.Sprintf("JournalKind(%d)", uint8(k))
+}
+
+// OTSKey identifies a one-time key of a key pair.
+type OTSKey struct {
+	Root    bool   // Whether the key is a root tree leaf, instead of a chain tree key.
+	Channel uint32 // The channel of a chain tree key.
+	Layer   uint32 // The chain tree layer of a chain tree key.
+	Index   uint32 // The root tree leaf, or the chainSeqNo in the chain tree.
+}
+
+func (k OTSKey) String() string {
+	if k.Root {
+		return fmt.Sprintf("root tree leaf %d", k.Index)
+	}
+	return fmt.Sprintf("channel %d, layer %d, key %d", k.Channel, k.Layer, k.Index)
+}
+
+// JournalEntry records the use of a one-time key.
+type JournalEntry struct {
+	Kind   JournalKind
+	Key    OTSKey
+	SeqNo  SignatureSeqNo // The channel seqNo of a MsgSignature or CloseSignature.
+	Digest []byte         // The n-byte digest signed by the key.
+}
+
+func (e JournalEntry) String() string {
+	return fmt.Sprintf("%s with %s, seqNo %d, digest %x", e.Kind, e.Key, e.SeqNo, e.Digest)
+}
+
+// The journal of a PrivateKey, see OpenJournal.
+type journal struct {
+	f    *os.File // The journal file, opened for appending. Nil without journal.
+	head []byte   // The hash of the last entry.
+	mux  sync.Mutex
+}
+
+// Returns the size of an encoded journal entry.
+func (ctx *Context) journalEntrySize() int {
+	return 17 + int(ctx.params.n) + sha256.Size
+}
+
+// Encodes the entry into buf, and returns the hash which chains it to prev.
+func (e *JournalEntry) writeInto(buf, prev []byte) []byte {
+	buf[0] = byte(e.Kind)
+	binary.BigEndian.PutUint32(buf[1:5], e.Key.Channel)
+	binary.BigEndian.PutUint32(buf[5:9], e.Key.Layer)
+	binary.BigEndian.PutUint32(buf[9:13], e.Key.Index)
+	binary.BigEndian.PutUint32(buf[13:17], uint32(e.SeqNo))
+	body := 17 + copy(buf[17:len(buf)-sha256.Size], e.Digest)
+	h := sha256.New()
+	h.Write(prev)
+	h.Write(buf[:body])
+	return h.Sum(buf[:body])[body:]
+}
+
+// Decodes the journal in data. Returns the context and root of the key pair
+// it belongs to, its entries, the hash of the last entry, and the length of
+// data up to the last complete entry.
+func readJournal(data []byte) (*Context, []byte, []JournalEntry, []byte, int, error) {
+	ctx, buf, err := readHeader(kindJournal, data)
+	if err != nil {
+		return nil, nil, nil, nil, 0, err
+	}
+	n := ctx.params.n
+	if len(buf) < int(n) {
+		return nil, nil, nil, nil, 0, fmt.Errorf("journal too short for its root")
+	}
+	root, buf := readBytes(buf, n)
+	size := len(data) - len(buf)
+	head := sha256.Sum256(data[:size])
+	prev := head[:]
+
+	var entries []JournalEntry
+	entrySize := ctx.journalEntrySize()
+	tmp := make([]byte, entrySize)
+	for len(buf) >= entrySize {
+		e := JournalEntry{
+			Kind: JournalKind(buf[0]),
+			Key: OTSKey{
+				Channel: binary.BigEndian.Uint32(buf[1:5]),
+				Layer:   binary.BigEndian.Uint32(buf[5:9]),
+				Index:   binary.BigEndian.Uint32(buf[9:13]),
+			},
+			SeqNo: SignatureSeqNo(binary.BigEndian.Uint32(buf[13:17])),
+		}
+		e.Key.Root = e.Kind == JournalRoot || e.Kind == JournalSuccessor
+		e.Digest, _ = readBytes(buf[17:], n)
+		hash := e.writeInto(tmp, prev)
+		if subtle.ConstantTimeCompare(hash, buf[entrySize-sha256.Size:entrySize]) != 1 {
+			return nil, nil, nil, nil, 0, fmt.Errorf("journal entry %d is altered", len(entries))
+		}
+		entries = append(entries, e)
+		prev = buf[entrySize-sha256.Size : entrySize]
+		buf = buf[entrySize:]
+		size += entrySize
+	}
+	return ctx, root, entries, append([]byte{}, prev...), size, nil
+}
+
+// ReadJournal reads the journal at path, and checks its hash chain. A torn
+// entry at the end, of a write which did not complete, is ignored.
+func ReadJournal(path string) ([]JournalEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	_, _, entries, _, _, err := readJournal(data)
+	if err != nil {
+		return nil, fmt.Errorf("journal %s: %s", path, err)
+	}
+	return entries, nil
+}
+
+// OpenJournal makes the PrivateKey record every one-time key it uses in the
+// journal at path, which is created if it does not exist. An existing journal
+// must belong to the key pair, and its hash chain must be intact. A torn entry
+// at its end, of a write which did not complete, is removed: its key was not
+// used. OpenJournal should be called before the PrivateKey signs.
+func (sk *PrivateKey) OpenJournal(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	var head []byte
+	if len(data) == 0 {
+		data = make([]byte, headerSize(sk.ctx.params)+int(sk.ctx.params.n))
+		off := sk.ctx.params.writeHeaderInto(kindJournal, data)
+		copy(data[off:], sk.root)
+		if err = writeSynced(f, data); err != nil {
+			f.Close()
+			return fmt.Errorf("creating journal %s failed: %s", path, err)
+		}
+		sum := sha256.Sum256(data)
+		head = sum[:]
+	} else {
+		ctx, root, _, last, size, err := readJournal(data)
+		if err == nil && (*ctx.params != *sk.ctx.params || !bytes.Equal(root, sk.root)) {
+			err = fmt.Errorf("the journal belongs to another key")
+		}
+		if err == nil && size < len(data) {
+			err = f.Truncate(int64(size))
+		}
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("journal %s: %s", path, err)
+		}
+		head = last
+	}
+	if _, err = f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return err
+	}
+
+	j := &sk.journal
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if j.f != nil {
+		j.f.Close()
+	}
+	j.f, j.head = f, head
+	return nil
+}
+
+// CloseJournal stops recording in the journal of the PrivateKey, and closes it.
+func (sk *PrivateKey) CloseJournal() error {
+	j := &sk.journal
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f, j.head = nil, nil
+	return err
+}
+
+// Head returns the hash of the last entry in the journal of the PrivateKey,
+// which proves, once published, that no entry up to it is removed later.
+func (sk *PrivateKey) Head() []byte {
+	j := &sk.journal
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	return append([]byte{}, j.head...)
+}
+
+// Appends the entry to the journal, if the PrivateKey has one. The entry is
+// synced to disk before the key may be used.
+func (sk *PrivateKey) journalKey(e JournalEntry) error {
+	j := &sk.journal
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	buf := make([]byte, sk.ctx.journalEntrySize())
+	hash := e.writeInto(buf, j.head)
+	copy(buf[len(buf)-sha256.Size:], hash)
+	if err := writeSynced(j.f, buf); err != nil {
+		return fmt.Errorf("writing journal failed: %s", err)
+	}
+	j.head = hash
+	return nil
+}
+
+// Writes data to f, and syncs it to disk.
+func writeSynced(f *os.File, data []byte) error {
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// JournalAudit is the result of replaying a journal against the state of a
+// PrivateKey.
+type JournalAudit struct {
+	Entries int            // The amount of entries in the journal.
+	Reused  []JournalEntry // Entries of keys which are recorded before in the journal, for another digest.
+	Ahead   []JournalEntry // Entries of keys which the PrivateKey counts as unused.
+	Skipped []OTSKey       // Keys which the PrivateKey counts as used, without entry.
+}
+
+// OK returns whether no key is used twice according to the audit. Skipped
+// keys are not a problem in itself: a crash skips the keys reserved ahead in
+// the key file, and a failing journal skips the root tree leaf it should
+// record. Keys ahead of the PrivateKey are, as the PrivateKey may use them
+// again.
+func (a *JournalAudit) OK() bool {
+	return len(a.Reused) == 0 && len(a.Ahead) == 0
+}
+
+// AuditJournal replays the journal at path against the state of the
+// PrivateKey, and reports every key which is recorded for two digests, which is
+// recorded but counted as unused by the PrivateKey, or which is counted as
+// used by the PrivateKey but not recorded.
+func (sk *PrivateKey) AuditJournal(path string) (*JournalAudit, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ctx, root, entries, _, _, err := readJournal(data)
+	if err != nil {
+		return nil, fmt.Errorf("journal %s: %s", path, err)
+	}
+	if *ctx.params != *sk.ctx.params || !bytes.Equal(root, sk.root) {
+		return nil, fmt.Errorf("journal %s belongs to another key", path)
+	}
+	return sk.ctx.auditJournal(entries, sk.Status()), nil
+}
+
+// Replays the journal entries against the KeyStatus st.
+func (ctx *Context) auditJournal(entries []JournalEntry, st KeyStatus) *JournalAudit {
+	a := &JournalAudit{Entries: len(entries)}
+	recorded := make(map[OTSKey]JournalEntry) // The first entry of every key.
+	for _, e := range entries {
+		// A key recorded again for the same digest is a retried signature.
+		if first, ok := recorded[e.Key]; !ok {
+			recorded[e.Key] = e
+		} else if first.Kind != e.Kind || !bytes.Equal(first.Digest, e.Digest) {
+			a.Reused = append(a.Reused, e)
+		}
+		if !statusUsed(st, e.Key) {
+			a.Ahead = append(a.Ahead, e)
+		}
+	}
+
+	for leaf := uint64(0); leaf < st.ChannelsUsed; leaf++ {
+		key := OTSKey{Root: true, Index: uint32(leaf)}
+		if _, ok := recorded[key]; !ok {
+			a.Skipped = append(a.Skipped, key)
+		}
+	}
+	last := OTSKey{Root: true, Index: ctx.successorLeaf()}
+	if _, ok := recorded[last]; st.SuccessorSigned && !ok {
+		a.Skipped = append(a.Skipped, last)
+	}
+	for _, cs := range st.Channels {
+		for layer := uint32(1); layer <= cs.Layer; layer++ {
+			keys := ctx.chainTreeHeight(layer)
+			if layer == cs.Layer {
+				keys = cs.ChainSeqNo
+			}
+			for idx := uint32(0); idx < keys; idx++ {
+				key := OTSKey{Channel: cs.Index, Layer: layer, Index: idx}
+				if _, ok := recorded[key]; !ok {
+					a.Skipped = append(a.Skipped, key)
+				}
+			}
+		}
+	}
+	return a
+}
+
+// Returns whether the KeyStatus st counts the key as used.
+func statusUsed(st KeyStatus, key OTSKey) bool {
+	if key.Root {
+		return uint64(key.Index) < st.ChannelsUsed || (st.SuccessorSigned && uint64(key.Index) == st.RootLeaves-1)
+	}
+	if uint64(key.Channel) >= uint64(len(st.Channels)) {
+		return false
+	}
+	cs := st.Channels[key.Channel]
+	return key.Layer < cs.Layer || (key.Layer == cs.Layer && key.Index < cs.ChainSeqNo)
+}
@@ -0,0 +1,202 @@
+package mbpqs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orderer.journal")
+	sk, _, err := GenerateKeyPair(InitParam(32, 2, 2, 1, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	if err = sk.ReserveSuccessorLeaf(); err != nil {
+		t.Fatalf("Reserving successor leaf failed with error %s", err)
+	}
+	if err = sk.OpenJournal(path); err != nil {
+		t.Fatalf("Opening journal failed with error %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err = sk.AddChannel(); err != nil {
+			t.Fatalf("Adding channel failed with error %s", err)
+		}
+	}
+	// One message in the first chain tree, a GrowSignature and two messages in the second.
+	for i := 0; i < 3; i++ {
+		if _, err = sk.SignChannelMsgAutoGrow(0, []byte("Block")); err != nil {
+			t.Fatalf("Signing message failed with error %s", err)
+		}
+	}
+	if _, err = sk.CloseChannel(1); err != nil {
+		t.Fatalf("Closing channel failed with error %s", err)
+	}
+	head := sk.Head()
+
+	// Reopening continues the hash chain.
+	if err = sk.CloseJournal(); err != nil {
+		t.Fatalf("Closing journal failed with error %s", err)
+	}
+	if err = sk.OpenJournal(path); err != nil {
+		t.Fatalf("Reopening journal failed with error %s", err)
+	}
+	if string(sk.Head()) != string(head) {
+		t.Fatal("Reopened journal has another head")
+	}
+	next, nextPk, err := GenerateKeyPair(InitParam(32, 2, 2, 1, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	if _, err = sk.SignSuccessor(nextPk); err != nil {
+		t.Fatalf("Signing successor failed with error %s", err)
+	}
+
+	entries, err := ReadJournal(path)
+	if err != nil {
+		t.Fatalf("Reading journal failed with error %s", err)
+	}
+	kinds := []JournalKind{JournalRoot, JournalRoot, JournalMsg, JournalGrow,
+		JournalMsg, JournalMsg, JournalClose, JournalSuccessor}
+	if len(entries) != len(kinds) {
+		t.Fatalf("Journal has %d entries instead of %d", len(entries), len(kinds))
+	}
+	for i, e := range entries {
+		if e.Kind != kinds[i] {
+			t.Fatalf("Entry %d is %s instead of %s", i, e.Kind, kinds[i])
+		}
+	}
+	if e := entries[5]; e.Key != (OTSKey{Channel: 0, Layer: 2, Index: 1}) || e.SeqNo != 2 {
+		t.Fatalf("Entry 5 is %s", e)
+	}
+	audit, err := sk.AuditJournal(path)
+	if err != nil {
+		t.Fatalf("Auditing journal failed with error %s", err)
+	}
+	if !audit.OK() || len(audit.Skipped) != 0 || audit.Entries != len(kinds) {
+		t.Fatalf("Audit of a complete journal reports %+v", audit)
+	}
+
+	// A key used twice, a key without entry, and a key the PrivateKey did not
+	// use. A key recorded again for the same digest, as a retried signature
+	// does, is not reused.
+	st := sk.Status()
+	ahead := JournalEntry{Kind: JournalMsg, Key: OTSKey{Channel: 0, Layer: 2, Index: 2}}
+	reused := entries[2]
+	reused.Digest = entries[4].Digest
+	forged := append(append(append([]JournalEntry{}, entries[:4]...), entries[5:]...), reused, entries[3], ahead)
+	audit = sk.ctx.auditJournal(forged, st)
+	if audit.OK() || len(audit.Reused) != 1 || audit.Reused[0].Key != entries[2].Key ||
+		len(audit.Skipped) != 1 || audit.Skipped[0] != entries[4].Key ||
+		len(audit.Ahead) != 1 || audit.Ahead[0].Key != ahead.Key {
+		t.Fatalf("Audit of a forged journal reports %+v", audit)
+	}
+
+	// A torn entry at the end is removed, an altered entry breaks the chain.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sk.CloseJournal(); err != nil {
+		t.Fatalf("Closing journal failed with error %s", err)
+	}
+	torn := filepath.Join(t.TempDir(), "torn.journal")
+	if err = os.WriteFile(torn, append(data, make([]byte, 20)...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = sk.OpenJournal(torn); err != nil {
+		t.Fatalf("Opening journal with torn entry failed with error %s", err)
+	}
+	sk.CloseJournal()
+	if fi, err := os.Stat(torn); err != nil || fi.Size() != int64(len(data)) {
+		t.Fatalf("Torn entry is not removed: %v", err)
+	}
+	altered := filepath.Join(t.TempDir(), "altered.journal")
+	data[len(data)-sk.ctx.journalEntrySize()-40]++
+	if err = os.WriteFile(altered, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadJournal(altered); err == nil {
+		t.Fatal("Reading an altered journal did not give an error")
+	}
+	if err = sk.OpenJournal(altered); err == nil {
+		t.Fatal("Opening an altered journal did not give an error")
+	}
+	if err = next.OpenJournal(path); err == nil {
+		t.Fatal("Opening the journal of another key did not give an error")
+	}
+}
+
+// A journal which fails to record a key leaves the channel as it is, such
+// that the signature can be made once the journal works again.
+func TestJournalFailure(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 2, 1, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	if err = sk.Persist(filepath.Join(t.TempDir(), "orderer.key")); err != nil {
+		t.Fatalf("Persisting key failed with error %s", err)
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+	if err != nil {
+		t.Fatalf("Creating verifier failed with error %s", err)
+	}
+	// Makes the next journal write fail, and then signs with working journal.
+	failing := func(what string, sign func() (Signature, error)) Signature {
+		if err := sk.OpenJournal(filepath.Join(t.TempDir(), "orderer.journal")); err != nil {
+			t.Fatalf("Opening journal failed with error %s", err)
+		}
+		sk.journal.f.Close()
+		if _, err := sign(); err == nil {
+			t.Fatalf("%s with a failing journal did not give an error", what)
+		}
+		sk.CloseJournal()
+		sig, err := sign()
+		if err != nil {
+			t.Fatalf("%s after the journal failed gave error %s", what, err)
+		}
+		return sig
+	}
+
+	msg := []byte("Block")
+	sig := failing("Signing message", func() (Signature, error) { return sk.SignMsg(chIdx, msg) })
+	if accept, err := cv.VerifyMsg(sig.(*MsgSignature), msg); !accept || err != nil {
+		t.Fatalf("MsgSignature after the journal failed not accepted: %v", err)
+	}
+	sig = failing("Growing channel", func() (Signature, error) { return sk.GrowChannel(chIdx) })
+	if accept, err := cv.Verify(sig, nil); !accept || err != nil {
+		t.Fatalf("GrowSignature after the journal failed not accepted: %v", err)
+	}
+	sig = failing("Closing channel", func() (Signature, error) { return sk.CloseChannel(chIdx) })
+	if accept, err := cv.Verify(sig, nil); !accept || err != nil {
+		t.Fatalf("CloseSignature after the journal failed not accepted: %v", err)
+	}
+
+	// The last root tree leaf is not saved as used when the journal fails.
+	_, next, err := GenerateKeyPair(InitParam(32, 2, 2, 1, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	if err = sk.OpenJournal(filepath.Join(t.TempDir(), "orderer.journal")); err != nil {
+		t.Fatalf("Opening journal failed with error %s", err)
+	}
+	sk.journal.f.Close()
+	if _, err = sk.SignSuccessor(next); err == nil {
+		t.Fatal("Signing successor with a failing journal did not give an error")
+	}
+	if sk.Status().SuccessorSigned {
+		t.Fatal("Successor is marked as signed while the journal failed")
+	}
+	sk.CloseJournal()
+	sc, err := sk.SignSuccessor(next)
+	if err != nil {
+		t.Fatalf("Signing successor after the journal failed gave error %s", err)
+	}
+	if accept, err := pk.VerifySuccessor(sc); !accept || err != nil {
+		t.Fatalf("SuccessionCertificate after the journal failed not accepted: %v", err)
+	}
+}
@@ -0,0 +1,365 @@
+package mbpqs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/* A PrivateKey can be saved to a key file, which holds its seeds and the
+ * state of its channels, or to another StateStore, see store.go. Reusing a
+ * WOTS+ key is catastrophic, so indices are reserved in the store before they
+ * are used: GetSeqNo reserves a batch of root tree leaves (the lookahead), and
+ * ChannelSeqNos and ChainSeqNo reserve the next key of the channel, when they
+ * run out of reserved ones. When the PrivateKey is loaded again, it continues
+ * after the reserved indices. A crash can thus only skip keys, and never
+ * reuse them.
+ *
+ * Skipped root tree leaves only waste channel slots, as each RootSignature
+ * carries its full authentication path. Skipped channel keys are worse: the
+ * next MsgSignature can not be verified against the authentication node of
+ * the last released one. Therefore, channel keys are reserved one at a time,
+ * which only skips a key if the process crashes between reserving it and
+ * releasing its signature.
+ */
+
+// Default amount of root tree leaves reserved at once in the state store.
+const defaultRootLookahead = 1
+
+// A key file holding the state of a PrivateKey. It is the StateStore of
+// Persist and PersistEncrypted.
+type keyFile struct {
+	path string
+	enc  *keyFileCipher // The key to encrypt the state with, nil if the key file is not encrypted.
+}
+
+// Save atomically replaces the contents of the key file with the state st.
+func (kf *keyFile) Save(st *KeyState) error {
+	data := marshalKeyState(st)
+	defer clear(data)
+	if kf.enc != nil {
+		var err error
+		if data, err = kf.enc.seal(data); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(kf.path, data)
+}
+
+// Load returns the state in the key file, and nil if it does not exist.
+// Encrypted key files are loaded with LoadEncryptedPrivateKey instead.
+func (kf *keyFile) Load() (*KeyState, error) {
+	data, err := os.ReadFile(kf.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The seeds are copied out of the file contents.
+	defer clear(data)
+	if len(data) > 1 && data[1] == kindEncryptedKey {
+		return nil, fmt.Errorf("key file %s is encrypted, please use LoadEncryptedPrivateKey", kf.path)
+	}
+	st, err := keyStateFromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %s", kf.path, err)
+	}
+	return st, nil
+}
+
+// Zeroes the key of an encrypted key file.
+func (kf *keyFile) wipe() {
+	if kf.enc != nil {
+		kf.enc.wipe()
+	}
+}
+
+// Persist saves the PrivateKey to a new key file at path. From then on,
+// the PrivateKey saves every state change to this file, and reserves indices
+// in it before they are used. The key file must not be loaded by more than
+// one process at a time.
+//
+// The keys of a channel are reserved one at a time, so every MsgSignature
+// rewrites and syncs the whole key file, with the caches of all channels and
+// the root tree. If the process crashes between reserving a message key and
+// releasing its signature, the key is skipped: the next MsgSignature in the
+// channel can not be verified against the authentication node of the last
+// released one, and the channel should be closed.
+func (sk *PrivateKey) Persist(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("key file %s already exists", path)
+	}
+	return sk.PersistTo(&keyFile{path: path})
+}
+
+// LoadPrivateKey loads a PrivateKey from the key file at path, which uses
+// t threads for its computations. The PrivateKey continues after the indices
+// reserved in the key file, and saves its state changes to it.
+func LoadPrivateKey(path string, t int) (*PrivateKey, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return LoadPrivateKeyFrom(&keyFile{path: path}, t)
+}
+
+// SetLookahead sets the amount of root tree leaves which are reserved at
+// once in the state store. Larger values save writes to the store, but skip
+// more leaves if the process crashes. The value is at least 1. Channel keys
+// are always reserved one at a time.
+func (sk *PrivateKey) SetLookahead(root uint32) {
+	if root == 0 {
+		root = 1
+	}
+	sk.mux.Lock()
+	sk.rootLookahead = root
+	sk.mux.Unlock()
+}
+
+// Makes sure the root tree leaf sk.seqNo is reserved in the state store.
+// The lock of the PrivateKey should be held.
+func (sk *PrivateKey) reserveRootLeaf() error {
+	if sk.store == nil || sk.seqNo < sk.seqNoReserved {
+		return nil
+	}
+	reserved := uint64(sk.seqNo) + uint64(sk.rootLookahead)
+	if max := sk.rootLeafLimit(); reserved > max {
+		reserved = max
+	}
+	old := sk.seqNoReserved
+	sk.seqNoReserved = SignatureSeqNo(reserved)
+	if err := sk.saveState(); err != nil {
+		sk.seqNoReserved = old
+		return err
+	}
+	return nil
+}
+
+// Makes sure the next key in the current chain tree of channel ch is reserved
+// in the state store. The lock of the channel should be held.
+//
+// The last key of the chain tree is never reserved, as a state with chainSeqNo
+// past it has no key left to grow or close the channel with. It only signs the
+// next chain tree root or the closure of the channel, and its signature is
+// released once the grown or closed channel is saved. A crash before that can
+// only sign the same deterministic digest again.
+func (sk *PrivateKey) reserveChannelKey(ch *Channel) error {
+	if sk.store == nil || ch.chainSeqNo < ch.chainSeqNoReserved {
+		return nil
+	}
+	reserved := ch.chainSeqNo + 1
+	if lastKey := sk.ctx.chainTreeHeight(ch.layers) - 1; reserved > lastKey {
+		reserved = lastKey
+	}
+	if reserved <= ch.chainSeqNoReserved {
+		return nil
+	}
+	sk.mux.Lock()
+	defer sk.mux.Unlock()
+	oldChainSeqNo, oldSeqNo := ch.chainSeqNoReserved, ch.seqNoReserved
+	ch.chainSeqNoReserved = reserved
+	ch.seqNoReserved = ch.seqNo + SignatureSeqNo(reserved-ch.chainSeqNo)
+	if err := sk.saveState(); err != nil {
+		ch.chainSeqNoReserved, ch.seqNoReserved = oldChainSeqNo, oldSeqNo
+		return err
+	}
+	return nil
+}
+
+// Moves channel ch to its next chain tree, with the given internal node cache,
+// and saves this to the state store. Should be called once the last key of the
+// current chain tree is recorded to sign the next one.
+func (sk *PrivateKey) advanceLayer(ch *Channel, cache []byte) error {
+	sk.mux.Lock()
+	defer sk.mux.Unlock()
+	oldCache := ch.cache
+	oldChainSeqNoReserved, oldSeqNoReserved := ch.chainSeqNoReserved, ch.seqNoReserved
+	ch.layers++
+	ch.chainSeqNo = 0
+	ch.cache = cache
+	ch.chainSeqNoReserved = 0
+	ch.seqNoReserved = ch.seqNo
+	if err := sk.saveState(); err != nil {
+		// Roll back, such that the channel can be grown again. No
+		// GrowSignature is made, and signing the same deterministic chain
+		// tree root again does not reveal anything new.
+		ch.layers--
+		ch.chainSeqNo = sk.ctx.chainTreeHeight(ch.layers) - 1
+		ch.cache = oldCache
+		ch.chainSeqNoReserved, ch.seqNoReserved = oldChainSeqNoReserved, oldSeqNoReserved
+		return err
+	}
+	return nil
+}
+
+// Marks channel ch as closed, and saves this to the state store.
+// The lock of the channel should be held.
+func (sk *PrivateKey) closeChannel(ch *Channel) error {
+	sk.mux.Lock()
+	defer sk.mux.Unlock()
+	ch.closed = true
+	if err := sk.saveState(); err != nil {
+		ch.closed = false
+		return err
+	}
+	return nil
+}
+
+/* Encodes the state st of a PrivateKey as:
+ *
+ *   header || skSeed || skPrf || pubSeed || root || seqNo || #channels ||
+ *   channel_0 || ... || channel_(#channels-1) || succession ||
+ *   #closed || closed_0 || ... || closed_(#closed-1) || rootTree
+ *
+ * where each channel is encoded as:
+ *
+ *   layers || chainSeqNo || seqNo || len(cache) || cache
+ *
+ * succession holds the succession flags, see succession.go, closed_i are
+ * the indices of the closed channels, see close.go, and rootTree is the
+ * traversal state of the root tree, see marshalRootTreeState, which is empty
+ * if it is not built. Key files written before these were added end after
+ * the channels, after succession, or after the closed channels.
+ */
+func marshalKeyState(st *KeyState) []byte {
+	n := st.Params.n
+	var rootTree []byte
+	if st.RootTree != nil {
+		rootTree = marshalRootTreeState(st.RootTree)
+	}
+	size := headerSize(st.Params) + int(4*n) + 16 + len(rootTree)
+	var closed []uint32
+	for chIdx, ch := range st.Channels {
+		size += 16 + len(ch.Cache)
+		if ch.Closed {
+			closed = append(closed, uint32(chIdx))
+			size += 4
+		}
+	}
+	buf := make([]byte, size)
+	off := st.Params.writeHeaderInto(kindPrivateKey, buf)
+	off += copy(buf[off:], st.SkSeed)
+	off += copy(buf[off:], st.SkPrf)
+	off += copy(buf[off:], st.PubSeed)
+	off += copy(buf[off:], st.Root)
+	binary.BigEndian.PutUint32(buf[off:], uint32(st.SeqNo))
+	binary.BigEndian.PutUint32(buf[off+4:], uint32(len(st.Channels)))
+	off += 8
+	for _, ch := range st.Channels {
+		binary.BigEndian.PutUint32(buf[off:], ch.Layer)
+		binary.BigEndian.PutUint32(buf[off+4:], ch.ChainSeqNo)
+		binary.BigEndian.PutUint32(buf[off+8:], uint32(ch.SeqNo))
+		binary.BigEndian.PutUint32(buf[off+12:], uint32(len(ch.Cache)))
+		off += 16
+		off += copy(buf[off:], ch.Cache)
+	}
+	binary.BigEndian.PutUint32(buf[off:], st.Succession)
+	binary.BigEndian.PutUint32(buf[off+4:], uint32(len(closed)))
+	off += 8
+	for _, chIdx := range closed {
+		binary.BigEndian.PutUint32(buf[off:], chIdx)
+		off += 4
+	}
+	copy(buf[off:], rootTree)
+	return buf
+}
+
+// Decodes the state of a PrivateKey encoded by marshalKeyState. The state is
+// checked by LoadPrivateKeyFrom.
+func keyStateFromBytes(data []byte) (*KeyState, error) {
+	ctx, buf, err := readHeader(kindPrivateKey, data)
+	if err != nil {
+		return nil, err
+	}
+	n := ctx.params.n
+	if len(buf) < int(4*n)+8 {
+		return nil, fmt.Errorf("private key encoding too short")
+	}
+	st := &KeyState{Params: ctx.params}
+	st.SkSeed, buf = readBytes(buf, n)
+	st.SkPrf, buf = readBytes(buf, n)
+	st.PubSeed, buf = readBytes(buf, n)
+	st.Root, buf = readBytes(buf, n)
+	st.SeqNo = SignatureSeqNo(binary.BigEndian.Uint32(buf[0:4]))
+	nChannels := binary.BigEndian.Uint32(buf[4:8])
+	buf = buf[8:]
+	if uint64(nChannels) > uint64(1)<<ctx.params.rootH {
+		return nil, fmt.Errorf("private key holds %d channels", nChannels)
+	}
+	for i := uint32(0); i < nChannels; i++ {
+		if len(buf) < 16 {
+			return nil, fmt.Errorf("private key encoding too short for channel %d", i)
+		}
+		ch := ChannelState{
+			Layer:      binary.BigEndian.Uint32(buf[0:4]),
+			ChainSeqNo: binary.BigEndian.Uint32(buf[4:8]),
+			SeqNo:      SignatureSeqNo(binary.BigEndian.Uint32(buf[8:12])),
+		}
+		cacheLen := binary.BigEndian.Uint32(buf[12:16])
+		buf = buf[16:]
+		if uint64(len(buf)) < uint64(cacheLen) {
+			return nil, fmt.Errorf("private key encoding too short for cache of channel %d", i)
+		}
+		if cacheLen > 0 {
+			ch.Cache, buf = readBytes(buf, cacheLen)
+		}
+		st.Channels = append(st.Channels, ch)
+	}
+	if len(buf) >= 4 {
+		st.Succession = binary.BigEndian.Uint32(buf)
+		buf = buf[4:]
+	}
+	if len(buf) >= 4 {
+		nClosed := binary.BigEndian.Uint32(buf)
+		buf = buf[4:]
+		if uint64(len(buf)) < 4*uint64(nClosed) {
+			return nil, fmt.Errorf("private key encoding too short for closed channels")
+		}
+		for i := uint32(0); i < nClosed; i++ {
+			chIdx := binary.BigEndian.Uint32(buf)
+			buf = buf[4:]
+			if chIdx >= nChannels {
+				return nil, fmt.Errorf("private key closes channel %d, which does not exist", chIdx)
+			}
+			st.Channels[chIdx].Closed = true
+		}
+	}
+	if len(buf) != 0 {
+		if st.RootTree, err = ctx.rootTreeStateFromBytes(buf); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// Atomically replaces the file at path with data: the data is written to a
+// temporary file in the same directory, which is synced and renamed to path.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	// CreateTemp creates the file with mode 0600, as it should be for keys.
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// Sync the directory, such that the rename itself is durable.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package mbpqs
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// Signs a message in channel chIdx and verifies it against authNode.
+// Returns the authentication node for the next signature.
+func signAndVerify(t *testing.T, sk *PrivateKey, pk *PublicKey, chIdx uint32, authNode []byte) []byte {
+	msg := []byte("Message in a persisted channel")
+	sig, err := sk.SignMsg(chIdx, msg)
+	if err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	accept, err := pk.VerifyMsg(sig, msg, authNode)
+	if err != nil {
+		t.Fatalf("Verifying message failed with error %s", err)
+	}
+	if !accept {
+		t.Fatalf("Correct signature with seqNo %d not accepted", sig.seqNo)
+	}
+	return sig.NextAuthNode(authNode)
+}
+
+func TestPersistAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orderer.key")
+	sk, pk, err := GenerateKeyPair(InitParam(32, 3, 4, 0, 1, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	if err = sk.Persist(path); err != nil {
+		t.Fatalf("Persisting key failed with error %s", err)
+	}
+	if err = sk.Persist(path); err == nil {
+		t.Fatal("Persisting over an existing key file did not give an error")
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	authNode := signAndVerify(t, sk, pk, chIdx, rtSig.NextAuthNode())
+	authNode = signAndVerify(t, sk, pk, chIdx, authNode)
+
+	// Restart: the channel continues where it was left.
+	sk, err = LoadPrivateKey(path, 0)
+	if err != nil {
+		t.Fatalf("Loading key failed with error %s", err)
+	}
+	authNode = signAndVerify(t, sk, pk, chIdx, authNode)
+	growSig, err := sk.GrowChannel(chIdx)
+	if err != nil {
+		t.Fatalf("Growing channel failed with error %s", err)
+	}
+	if accept, err := pk.VerifyGrow(growSig, authNode); !accept || err != nil {
+		t.Fatalf("Correct GrowSignature not accepted: %v", err)
+	}
+
+	// Restart again: the channel continues in its new chain tree.
+	sk, err = LoadPrivateKey(path, 0)
+	if err != nil {
+		t.Fatalf("Loading key failed with error %s", err)
+	}
+	signAndVerify(t, sk, pk, chIdx, growSig.NextAuthNode())
+	if sk.Channels[chIdx].layers != 2 {
+		t.Fatalf("Loaded channel has %d layers instead of 2", sk.Channels[chIdx].layers)
+	}
+	chIdx, rtSig, err = sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel after loading failed with error %s", err)
+	}
+	if chIdx != 1 {
+		t.Fatalf("Added channel has index %d instead of 1", chIdx)
+	}
+	if accept, err := pk.VerifyChannel(rtSig); !accept || err != nil {
+		t.Fatalf("RootSignature after loading not accepted: %v", err)
+	}
+}
+
+// A crash after reserving indices may only skip keys, never reuse them.
+func TestReservationSkipsKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orderer.key")
+	sk, _, err := GenerateKeyPair(InitParam(32, 3, 8, 0, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	sk.SetLookahead(4)
+	if err = sk.Persist(path); err != nil {
+		t.Fatalf("Persisting key failed with error %s", err)
+	}
+	chIdx, _, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	if _, err = sk.SignMsg(chIdx, []byte("Hello")); err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+
+	// Load the key file as it is at the moment of a crash.
+	crashed, err := LoadPrivateKey(path, 0)
+	if err != nil {
+		t.Fatalf("Loading key failed with error %s", err)
+	}
+	if crashed.seqNo != 4 {
+		t.Fatalf("Loaded root seqNo is %d instead of the reserved 4", crashed.seqNo)
+	}
+	ch, crashedCh := sk.Channels[chIdx], crashed.Channels[chIdx]
+	// Channel keys are reserved one at a time, so the loaded channel
+	// continues right after the signed message.
+	if crashedCh.chainSeqNo != 1 || crashedCh.seqNo != 1 {
+		t.Fatalf("Loaded channel continues at chainSeqNo %d and seqNo %d instead of 1",
+			crashedCh.chainSeqNo, crashedCh.seqNo)
+	}
+
+	// Keys used by the running key after the crash snapshot are either
+	// reserved before it, or beyond the ones used by the loaded key.
+	for i := 0; i < 4; i++ {
+		if _, err = sk.SignMsg(chIdx, []byte("Hello")); err != nil {
+			t.Fatalf("Signing message failed with error %s", err)
+		}
+	}
+	if ch.chainSeqNoReserved != 5 || ch.seqNoReserved != 5 {
+		t.Fatalf("Reserved chainSeqNo %d and seqNo %d instead of 5",
+			ch.chainSeqNoReserved, ch.seqNoReserved)
+	}
+	crashed, err = LoadPrivateKey(path, 0)
+	if err != nil {
+		t.Fatalf("Loading key failed with error %s", err)
+	}
+	if crashed.Channels[chIdx].chainSeqNo < ch.chainSeqNo {
+		t.Fatalf("Loaded channel reuses keys from chainSeqNo %d", crashed.Channels[chIdx].chainSeqNo)
+	}
+}
+
+// Adding a channel without a root tree leaf left fails without saving the
+// channel, so the key file can still be loaded.
+func TestAddChannelWithoutLeaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orderer.key")
+	sk, _, err := GenerateKeyPair(InitParam(32, 2, 2, 0, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	if err = sk.Persist(path); err != nil {
+		t.Fatalf("Persisting key failed with error %s", err)
+	}
+	for i := 0; i < 6; i++ {
+		_, _, err = sk.AddChannel()
+		if i < 4 && err != nil {
+			t.Fatalf("Adding channel %d failed with error %s", i, err)
+		}
+		if i >= 4 && err == nil {
+			t.Fatal("Adding a channel without root tree leaves left did not give an error")
+		}
+	}
+	loaded, err := LoadPrivateKey(path, 0)
+	if err != nil {
+		t.Fatalf("Loading key failed with error %s", err)
+	}
+	if len(loaded.Channels) != 4 {
+		t.Fatalf("Loaded key has %d channels instead of 4", len(loaded.Channels))
+	}
+}
+
+// A StateStore which fails to save once crashed, like a process which dies
+// before the state is written.
+type crashingStore struct {
+	MemoryStateStore
+	crashed bool
+}
+
+func (cs *crashingStore) Save(st *KeyState) error {
+	if cs.crashed {
+		return fmt.Errorf("crashed")
+	}
+	return cs.MemoryStateStore.Save(st)
+}
+
+// Returns the PrivateKey as it is loaded after a crash.
+func (cs *crashingStore) restart(t *testing.T) *PrivateKey {
+	cs.crashed = false
+	sk, err := LoadPrivateKeyFrom(cs, 0)
+	if err != nil {
+		t.Fatalf("Loading key after a crash failed with error %s", err)
+	}
+	return sk
+}
+
+// A crash while the last key of a chain tree grows or closes the channel
+// leaves a state in which that key can still be used.
+func TestCrashWithLastKey(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 3, 3, 1, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	store := &crashingStore{}
+	if err = sk.PersistTo(store); err != nil {
+		t.Fatalf("Persisting key failed with error %s", err)
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+	if err != nil {
+		t.Fatalf("Verifying RootSignature failed with error %s", err)
+	}
+	msg := []byte("Block before a crash")
+	signAll := func(sk *PrivateKey, count int) {
+		for i := 0; i < count; i++ {
+			sig, err := sk.SignMsg(chIdx, msg)
+			if err != nil {
+				t.Fatalf("Signing message failed with error %s", err)
+			}
+			if accept, err := cv.VerifyMsg(sig, msg); !accept || err != nil {
+				t.Fatalf("Correct MsgSignature not accepted: %v", err)
+			}
+		}
+	}
+
+	signAll(sk, 2)
+	store.crashed = true
+	if _, err = sk.GrowChannel(chIdx); err == nil {
+		t.Fatal("Growing channel with a crashed store did not give an error")
+	}
+	sk = store.restart(t)
+	if ch := sk.Channels[chIdx]; ch.layers != 1 || ch.chainSeqNo != 2 {
+		t.Fatalf("Loaded channel is at layer %d and chainSeqNo %d instead of the last key", ch.layers, ch.chainSeqNo)
+	}
+	growSig, err := sk.GrowChannel(chIdx)
+	if err != nil {
+		t.Fatalf("Growing channel after a crash failed with error %s", err)
+	}
+	if accept, err := cv.VerifyGrow(growSig); !accept || err != nil {
+		t.Fatalf("Correct GrowSignature not accepted: %v", err)
+	}
+
+	signAll(sk, 3)
+	store.crashed = true
+	if _, err = sk.CloseChannel(chIdx); err == nil {
+		t.Fatal("Closing channel with a crashed store did not give an error")
+	}
+	sk = store.restart(t)
+	closeSig, err := sk.CloseChannel(chIdx)
+	if err != nil {
+		t.Fatalf("Closing channel after a crash failed with error %s", err)
+	}
+	if accept, err := cv.VerifyClose(closeSig); !accept || err != nil {
+		t.Fatalf("Correct CloseSignature not accepted: %v", err)
+	}
+
+	// A state past the last key of a chain tree is rejected.
+	st, err := store.Load()
+	if err != nil {
+		t.Fatalf("Loading state failed with error %s", err)
+	}
+	st.Channels[chIdx].ChainSeqNo = sk.ctx.chainTreeHeight(st.Channels[chIdx].Layer)
+	if _, err = privateKeyFromState(st); err == nil {
+		t.Fatal("Loading a channel past its last key did not give an error")
+	}
+}
@@ -0,0 +1,590 @@
+package mbpqs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+)
+
+/* Binary encoding of MBPQS objects. Every object starts with a header:
+ *
+ *   version (1 byte) || kind (1 byte) || OID (4 bytes) [|| params]
+ *
+ * The OID is the index of the parameters in paramSets. If the parameters
+ * are not listed there, the OID is customOid and the parameters follow
+ * explicitly. All integers are encoded in Big Endian.
+ */
+
+// Version of the binary encoding.
+const encodingVersion = 1
+
+// Kinds of objects in the binary encoding.
+const (
+	kindRootSignature = 1
+	kindGrowSignature = 2
+	kindMsgSignature  = 3
+	kindPublicKey     = 4
+	kindPrivateKey    = 5
+	kindProof         = 6
+	kindSuccession    = 7
+	kindClose         = 8
+	kindEncryptedKey  = 9
+	kindKeyShare      = 10
+	kindJournal       = 11
+)
+
+// Type of the PEM block holding an armored PublicKey.
+const pemTypePublicKey = "MBPQS PUBLIC KEY"
+
+// Returns the size of the header of a binary object with the given parameters.
+func headerSize(params *Params) int {
+	if params.oid() == customOid {
+		return 6 + paramsBytes
+	}
+	return 6
+}
+
+// Writes the header of a binary object of the given kind into buf and
+// returns the amount of bytes written.
+func (params *Params) writeHeaderInto(kind byte, buf []byte) int {
+	oid := params.oid()
+	buf[0] = encodingVersion
+	buf[1] = kind
+	binary.BigEndian.PutUint32(buf[2:6], oid)
+	if oid != customOid {
+		return 6
+	}
+	params.writeInto(buf[6 : 6+paramsBytes])
+	return 6 + paramsBytes
+}
+
+// Reads the header of a binary object of the given kind from buf.
+// Returns a new Context for the encoded parameters and the remainder of buf.
+func readHeader(kind byte, buf []byte) (*Context, []byte, error) {
+	if len(buf) < 6 {
+		return nil, nil, fmt.Errorf("encoding too short for header (%d bytes)", len(buf))
+	}
+	if buf[0] != encodingVersion {
+		return nil, nil, fmt.Errorf("unsupported encoding version %d", buf[0])
+	}
+	if buf[1] != kind {
+		return nil, nil, fmt.Errorf("encoding holds kind %d instead of %d", buf[1], kind)
+	}
+	var params Params
+	oid := binary.BigEndian.Uint32(buf[2:6])
+	buf = buf[6:]
+	if oid == customOid {
+		if len(buf) < paramsBytes {
+			return nil, nil, fmt.Errorf("encoding too short for parameters")
+		}
+		params = *paramsFromBytes(buf[:paramsBytes])
+		buf = buf[paramsBytes:]
+	} else {
+		if oid >= uint32(len(paramSets)) {
+			return nil, nil, fmt.Errorf("unknown parameter set OID %d", oid)
+		}
+		params = *paramSets[oid]
+	}
+	ctx, err := newContext(&params)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ctx, buf, nil
+}
+
+// Returns a copy of the next l bytes in buf, and the remainder of buf.
+func readBytes(buf []byte, l uint32) ([]byte, []byte) {
+	ret := make([]byte, l)
+	copy(ret, buf[:l])
+	return ret, buf[l:]
+}
+
+// Returns the size of the encoding of a RootSignature.
+func (ctx *Context) rootSignatureSize() int {
+	return headerSize(ctx.params) + ctx.rootSignatureBodySize()
+}
+
+// Returns the size of the encoding of a RootSignature without its header.
+func (ctx *Context) rootSignatureBodySize() int {
+	return 4 + int(ctx.wotsSigBytes) + int((ctx.params.rootH+1)*ctx.params.n)
+}
+
+// Returns the size of the encoding of a GrowSignature.
+func (ctx *Context) growSignatureSize() int {
+	return headerSize(ctx.params) + ctx.growSignatureBodySize()
+}
+
+// Returns the size of the encoding of a GrowSignature without its header.
+func (ctx *Context) growSignatureBodySize() int {
+	return 12 + int(ctx.wotsSigBytes+ctx.params.n)
+}
+
+// Returns the size of the encoding of a MsgSignature.
+func (ctx *Context) msgSignatureSize() int {
+	return headerSize(ctx.params) + ctx.msgSignatureBodySize()
+}
+
+// Returns the size of the encoding of a MsgSignature without its header.
+func (ctx *Context) msgSignatureBodySize() int {
+	return 16 + int(ctx.wotsSigBytes+2*ctx.params.n)
+}
+
+// MarshalBinary encodes the RootSignature as:
+// header || seqNo || wotsSig || authPath || rootHash.
+func (rtSig *RootSignature) MarshalBinary() ([]byte, error) {
+	if rtSig.ctx == nil {
+		return nil, fmt.Errorf("signature has no context")
+	}
+	ctx := rtSig.ctx
+	buf := make([]byte, ctx.rootSignatureSize())
+	off := ctx.params.writeHeaderInto(kindRootSignature, buf)
+	rtSig.writeInto(buf[off:])
+	return buf, nil
+}
+
+// Writes the RootSignature without header into buf.
+func (rtSig *RootSignature) writeInto(buf []byte) {
+	binary.BigEndian.PutUint32(buf, uint32(rtSig.seqNo))
+	off := 4
+	off += copy(buf[off:], rtSig.wotsSig)
+	off += copy(buf[off:], rtSig.authPath)
+	copy(buf[off:], rtSig.rootHash)
+}
+
+// UnmarshalBinary decodes a RootSignature encoded by MarshalBinary.
+func (rtSig *RootSignature) UnmarshalBinary(data []byte) error {
+	ctx, buf, err := readHeader(kindRootSignature, data)
+	if err != nil {
+		return err
+	}
+	if len(data) != ctx.rootSignatureSize() {
+		return fmt.Errorf("RootSignature encoding should be %d bytes, but is %d",
+			ctx.rootSignatureSize(), len(data))
+	}
+	rtSig.readFrom(ctx, buf)
+	return nil
+}
+
+// Reads the RootSignature without header from buf, and returns the remainder of buf.
+func (rtSig *RootSignature) readFrom(ctx *Context, buf []byte) []byte {
+	n := ctx.params.n
+	rtSig.ctx = ctx
+	rtSig.seqNo = SignatureSeqNo(binary.BigEndian.Uint32(buf))
+	buf = buf[4:]
+	rtSig.wotsSig, buf = readBytes(buf, ctx.wotsSigBytes)
+	rtSig.authPath, buf = readBytes(buf, ctx.params.rootH*n)
+	rtSig.rootHash, buf = readBytes(buf, n)
+	return buf
+}
+
+// MarshalBinary encodes the GrowSignature as:
+// header || chIdx || layer || chainSeqNo || wotsSig || rootHash.
+func (gs *GrowSignature) MarshalBinary() ([]byte, error) {
+	if gs.ctx == nil {
+		return nil, fmt.Errorf("signature has no context")
+	}
+	ctx := gs.ctx
+	buf := make([]byte, ctx.growSignatureSize())
+	off := ctx.params.writeHeaderInto(kindGrowSignature, buf)
+	gs.writeInto(buf[off:])
+	return buf, nil
+}
+
+// Writes the GrowSignature without header into buf.
+func (gs *GrowSignature) writeInto(buf []byte) {
+	binary.BigEndian.PutUint32(buf, gs.chIdx)
+	binary.BigEndian.PutUint32(buf[4:], gs.layer)
+	binary.BigEndian.PutUint32(buf[8:], gs.chainSeqNo)
+	off := 12
+	off += copy(buf[off:], gs.wotsSig)
+	copy(buf[off:], gs.rootHash)
+}
+
+// UnmarshalBinary decodes a GrowSignature encoded by MarshalBinary.
+func (gs *GrowSignature) UnmarshalBinary(data []byte) error {
+	ctx, buf, err := readHeader(kindGrowSignature, data)
+	if err != nil {
+		return err
+	}
+	if len(data) != ctx.growSignatureSize() {
+		return fmt.Errorf("GrowSignature encoding should be %d bytes, but is %d",
+			ctx.growSignatureSize(), len(data))
+	}
+	gs.readFrom(ctx, buf)
+	return nil
+}
+
+// Reads the GrowSignature without header from buf, and returns the remainder of buf.
+func (gs *GrowSignature) readFrom(ctx *Context, buf []byte) []byte {
+	gs.ctx = ctx
+	gs.chIdx = binary.BigEndian.Uint32(buf[0:4])
+	gs.layer = binary.BigEndian.Uint32(buf[4:8])
+	gs.chainSeqNo = binary.BigEndian.Uint32(buf[8:12])
+	buf = buf[12:]
+	gs.wotsSig, buf = readBytes(buf, ctx.wotsSigBytes)
+	gs.rootHash, buf = readBytes(buf, ctx.params.n)
+	return buf
+}
+
+// MarshalBinary encodes the MsgSignature as:
+// header || chIdx || layer || chainSeqNo || seqNo || drv || wotsSig || authPath.
+func (ms *MsgSignature) MarshalBinary() ([]byte, error) {
+	if ms.ctx == nil {
+		return nil, fmt.Errorf("signature has no context")
+	}
+	ctx := ms.ctx
+	buf := make([]byte, ctx.msgSignatureSize())
+	off := ctx.params.writeHeaderInto(kindMsgSignature, buf)
+	ms.writeInto(buf[off:])
+	return buf, nil
+}
+
+// Writes the MsgSignature without header into buf.
+func (ms *MsgSignature) writeInto(buf []byte) {
+	binary.BigEndian.PutUint32(buf, ms.chIdx)
+	binary.BigEndian.PutUint32(buf[4:], ms.layer)
+	binary.BigEndian.PutUint32(buf[8:], ms.chainSeqNo)
+	binary.BigEndian.PutUint32(buf[12:], uint32(ms.seqNo))
+	off := 16
+	off += copy(buf[off:], ms.drv)
+	off += copy(buf[off:], ms.wotsSig)
+	copy(buf[off:], ms.authPath)
+}
+
+// UnmarshalBinary decodes a MsgSignature encoded by MarshalBinary.
+func (ms *MsgSignature) UnmarshalBinary(data []byte) error {
+	ctx, buf, err := readHeader(kindMsgSignature, data)
+	if err != nil {
+		return err
+	}
+	if len(data) != ctx.msgSignatureSize() {
+		return fmt.Errorf("MsgSignature encoding should be %d bytes, but is %d",
+			ctx.msgSignatureSize(), len(data))
+	}
+	ms.readFrom(ctx, buf)
+	return nil
+}
+
+// Reads the MsgSignature without header from buf, and returns the remainder of buf.
+func (ms *MsgSignature) readFrom(ctx *Context, buf []byte) []byte {
+	n := ctx.params.n
+	ms.ctx = ctx
+	ms.chIdx = binary.BigEndian.Uint32(buf[0:4])
+	ms.layer = binary.BigEndian.Uint32(buf[4:8])
+	ms.chainSeqNo = binary.BigEndian.Uint32(buf[8:12])
+	ms.seqNo = SignatureSeqNo(binary.BigEndian.Uint32(buf[12:16]))
+	buf = buf[16:]
+	ms.drv, buf = readBytes(buf, n)
+	ms.wotsSig, buf = readBytes(buf, ctx.wotsSigBytes)
+	ms.authPath, buf = readBytes(buf, n)
+	return buf
+}
+
+// Returns the size of the encoding of a CloseSignature.
+func (ctx *Context) closeSignatureSize() int {
+	return headerSize(ctx.params) + 16 + int(ctx.wotsSigBytes+ctx.params.n)
+}
+
+// MarshalBinary encodes the CloseSignature as:
+// header || chIdx || layer || chainSeqNo || seqNo || wotsSig || authPath.
+func (cs *CloseSignature) MarshalBinary() ([]byte, error) {
+	if cs.ctx == nil {
+		return nil, fmt.Errorf("signature has no context")
+	}
+	ctx := cs.ctx
+	buf := make([]byte, ctx.closeSignatureSize())
+	off := ctx.params.writeHeaderInto(kindClose, buf)
+	binary.BigEndian.PutUint32(buf[off:], cs.chIdx)
+	binary.BigEndian.PutUint32(buf[off+4:], cs.layer)
+	binary.BigEndian.PutUint32(buf[off+8:], cs.chainSeqNo)
+	binary.BigEndian.PutUint32(buf[off+12:], uint32(cs.seqNo))
+	off += 16
+	off += copy(buf[off:], cs.wotsSig)
+	copy(buf[off:], cs.authPath)
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a CloseSignature encoded by MarshalBinary.
+func (cs *CloseSignature) UnmarshalBinary(data []byte) error {
+	ctx, buf, err := readHeader(kindClose, data)
+	if err != nil {
+		return err
+	}
+	if len(data) != ctx.closeSignatureSize() {
+		return fmt.Errorf("CloseSignature encoding should be %d bytes, but is %d",
+			ctx.closeSignatureSize(), len(data))
+	}
+	cs.ctx = ctx
+	cs.chIdx = binary.BigEndian.Uint32(buf[0:4])
+	cs.layer = binary.BigEndian.Uint32(buf[4:8])
+	cs.chainSeqNo = binary.BigEndian.Uint32(buf[8:12])
+	cs.seqNo = SignatureSeqNo(binary.BigEndian.Uint32(buf[12:16]))
+	buf = buf[16:]
+	cs.wotsSig, buf = readBytes(buf, ctx.wotsSigBytes)
+	cs.authPath, _ = readBytes(buf, ctx.params.n)
+	return nil
+}
+
+// Returns the size of the encoding of a Proof for a MsgSignature in chain
+// tree layer with the given chainSeqNo.
+func (ctx *Context) proofSize(layer, chainSeqNo uint32) uint64 {
+	size := uint64(headerSize(ctx.params) + ctx.msgSignatureBodySize() + ctx.rootSignatureBodySize())
+	size += uint64(layer-1) * uint64(ctx.growSignatureBodySize())
+	for l := uint32(1); l <= layer; l++ {
+		size += uint64(ctx.proofLeaves(l, layer, chainSeqNo)) * uint64(ctx.params.n)
+	}
+	return size
+}
+
+// MarshalBinary encodes the Proof as:
+// header || msgSig || rtSig || growSigs || leaves,
+// where the signatures are encoded without their header. There is a
+// GrowSignature for every chain tree before the one of msgSig, and the
+// leaves of the chain trees follow each other from the first chain tree on.
+func (p *Proof) MarshalBinary() ([]byte, error) {
+	if p.ctx == nil {
+		return nil, fmt.Errorf("proof has no context")
+	}
+	ctx := p.ctx
+	buf := make([]byte, ctx.proofSize(p.msgSig.layer, p.msgSig.chainSeqNo))
+	off := ctx.params.writeHeaderInto(kindProof, buf)
+	p.msgSig.writeInto(buf[off:])
+	off += ctx.msgSignatureBodySize()
+	p.rtSig.writeInto(buf[off:])
+	off += ctx.rootSignatureBodySize()
+	for _, gs := range p.grows {
+		gs.writeInto(buf[off:])
+		off += ctx.growSignatureBodySize()
+	}
+	for _, leaves := range p.leaves {
+		off += copy(buf[off:], leaves)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a Proof encoded by MarshalBinary.
+func (p *Proof) UnmarshalBinary(data []byte) error {
+	ctx, buf, err := readHeader(kindProof, data)
+	if err != nil {
+		return err
+	}
+	if len(buf) < ctx.msgSignatureBodySize()+ctx.rootSignatureBodySize() {
+		return fmt.Errorf("Proof encoding too short (%d bytes)", len(data))
+	}
+	ms := new(MsgSignature)
+	buf = ms.readFrom(ctx, buf)
+	// Bound the layer by the encoding before computing its size.
+	if ms.layer == 0 || uint64(ms.layer-1)*uint64(ctx.growSignatureBodySize()) > uint64(len(data)) {
+		return fmt.Errorf("Proof encoding too short for chain tree %d", ms.layer)
+	}
+	if ms.chainSeqNo >= ctx.chainTreeHeight(ms.layer)-1 {
+		return fmt.Errorf("MsgSignature in Proof has invalid chainSeqNo %d", ms.chainSeqNo)
+	}
+	if size := ctx.proofSize(ms.layer, ms.chainSeqNo); uint64(len(data)) != size {
+		return fmt.Errorf("Proof encoding should be %d bytes, but is %d", size, len(data))
+	}
+	p.ctx = ctx
+	p.msgSig = ms
+	p.rtSig = new(RootSignature)
+	buf = p.rtSig.readFrom(ctx, buf)
+	p.grows = make([]*GrowSignature, ms.layer-1)
+	for i := range p.grows {
+		p.grows[i] = new(GrowSignature)
+		buf = p.grows[i].readFrom(ctx, buf)
+	}
+	p.leaves = make([][]byte, ms.layer)
+	for i := range p.leaves {
+		l := uint32(i) + 1
+		p.leaves[i], buf = readBytes(buf, ctx.proofLeaves(l, ms.layer, ms.chainSeqNo)*ctx.params.n)
+	}
+	return nil
+}
+
+// MarshalBinary encodes the SuccessionCertificate as:
+// header || rtSig || successor,
+// where rtSig is encoded without its header, and successor is the encoding
+// of the successor PublicKey, which may have other parameters.
+func (sc *SuccessionCertificate) MarshalBinary() ([]byte, error) {
+	if sc.rtSig == nil || sc.rtSig.ctx == nil || sc.next == nil {
+		return nil, fmt.Errorf("incomplete succession certificate")
+	}
+	next, err := sc.next.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	ctx := sc.rtSig.ctx
+	buf := make([]byte, headerSize(ctx.params)+ctx.rootSignatureBodySize()+len(next))
+	off := ctx.params.writeHeaderInto(kindSuccession, buf)
+	sc.rtSig.writeInto(buf[off:])
+	copy(buf[off+ctx.rootSignatureBodySize():], next)
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a SuccessionCertificate encoded by MarshalBinary.
+func (sc *SuccessionCertificate) UnmarshalBinary(data []byte) error {
+	ctx, buf, err := readHeader(kindSuccession, data)
+	if err != nil {
+		return err
+	}
+	if len(buf) < ctx.rootSignatureBodySize() {
+		return fmt.Errorf("SuccessionCertificate encoding too short (%d bytes)", len(data))
+	}
+	rtSig := new(RootSignature)
+	buf = rtSig.readFrom(ctx, buf)
+	next := new(PublicKey)
+	if err = next.UnmarshalBinary(buf); err != nil {
+		return fmt.Errorf("successor in SuccessionCertificate: %s", err)
+	}
+	sc.rtSig, sc.next = rtSig, next
+	return nil
+}
+
+// UnmarshalSignature decodes a binary encoded RootSignature, GrowSignature,
+// MsgSignature or CloseSignature, depending on the kind stored in its header.
+func UnmarshalSignature(data []byte) (Signature, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("encoding too short for header (%d bytes)", len(data))
+	}
+	var sig interface {
+		Signature
+		UnmarshalBinary([]byte) error
+	}
+	switch data[1] {
+	case kindRootSignature:
+		sig = new(RootSignature)
+	case kindGrowSignature:
+		sig = new(GrowSignature)
+	case kindMsgSignature:
+		sig = new(MsgSignature)
+	case kindClose:
+		sig = new(CloseSignature)
+	default:
+		return nil, fmt.Errorf("encoding does not hold a signature (kind %d)", data[1])
+	}
+	if err := sig.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// Returns the size of the encoding of a PublicKey.
+func (ctx *Context) publicKeySize() int {
+	return headerSize(ctx.params) + int(2*ctx.params.n)
+}
+
+// MarshalBinary encodes the PublicKey as: header || root || pubSeed.
+func (pk *PublicKey) MarshalBinary() ([]byte, error) {
+	if pk.ctx == nil {
+		return nil, fmt.Errorf("public key has no context")
+	}
+	buf := make([]byte, pk.ctx.publicKeySize())
+	off := pk.ctx.params.writeHeaderInto(kindPublicKey, buf)
+	off += copy(buf[off:], pk.root)
+	copy(buf[off:], pk.pubSeed)
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a PublicKey encoded by MarshalBinary.
+// The precomputed hashes are restored, so that the PublicKey can be
+// used for verification straight away.
+func (pk *PublicKey) UnmarshalBinary(data []byte) error {
+	ctx, buf, err := readHeader(kindPublicKey, data)
+	if err != nil {
+		return err
+	}
+	if len(data) != ctx.publicKeySize() {
+		return fmt.Errorf("PublicKey encoding should be %d bytes, but is %d",
+			ctx.publicKeySize(), len(data))
+	}
+	pk.ctx = ctx
+	pk.root, buf = readBytes(buf, ctx.params.n)
+	pk.pubSeed, _ = readBytes(buf, ctx.params.n)
+	pk.ph = ctx.precomputeHashes(pk.pubSeed, nil)
+	return nil
+}
+
+// Returns the size of the encoding of a KeyShare.
+func (ctx *Context) keyShareSize() int {
+	return headerSize(ctx.params) + 2 + int(4*ctx.params.n)
+}
+
+// MarshalBinary encodes the KeyShare as:
+// header || threshold || index || root || value.
+func (ks *KeyShare) MarshalBinary() ([]byte, error) {
+	if ks.ctx == nil {
+		return nil, fmt.Errorf("key share has no context")
+	}
+	buf := make([]byte, ks.ctx.keyShareSize())
+	off := ks.ctx.params.writeHeaderInto(kindKeyShare, buf)
+	buf[off] = ks.threshold
+	buf[off+1] = ks.index
+	off += 2
+	off += copy(buf[off:], ks.root)
+	copy(buf[off:], ks.value)
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a KeyShare encoded by MarshalBinary.
+func (ks *KeyShare) UnmarshalBinary(data []byte) error {
+	ctx, buf, err := readHeader(kindKeyShare, data)
+	if err != nil {
+		return err
+	}
+	if len(data) != ctx.keyShareSize() {
+		return fmt.Errorf("KeyShare encoding should be %d bytes, but is %d",
+			ctx.keyShareSize(), len(data))
+	}
+	if buf[0] < 2 || buf[1] == 0 {
+		return fmt.Errorf("invalid key share %d with threshold %d", buf[1], buf[0])
+	}
+	ks.ctx = ctx
+	ks.threshold, ks.index = buf[0], buf[1]
+	ks.root, buf = readBytes(buf[2:], ctx.params.n)
+	ks.value, _ = readBytes(buf, 3*ctx.params.n)
+	return nil
+}
+
+// MarshalBinary encodes the KeyState like a key file, for StateStores which
+// keep it elsewhere. The encoding holds the seeds unencrypted.
+func (st *KeyState) MarshalBinary() ([]byte, error) {
+	if st.Params == nil {
+		return nil, fmt.Errorf("key state has no parameters")
+	}
+	return marshalKeyState(st), nil
+}
+
+// UnmarshalBinary decodes a KeyState encoded by MarshalBinary. The state is
+// checked once it is loaded, by LoadPrivateKeyFrom.
+func (st *KeyState) UnmarshalBinary(data []byte) error {
+	dec, err := keyStateFromBytes(data)
+	if err != nil {
+		return err
+	}
+	*st = *dec
+	return nil
+}
+
+// MarshalText encodes the PublicKey as a PEM block of type "MBPQS PUBLIC KEY".
+func (pk *PublicKey) MarshalText() ([]byte, error) {
+	buf, err := pk.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemTypePublicKey, Bytes: buf}), nil
+}
+
+// UnmarshalText decodes a PublicKey armored by MarshalText.
+func (pk *PublicKey) UnmarshalText(text []byte) error {
+	block, rest := pem.Decode(text)
+	if block == nil {
+		return fmt.Errorf("no PEM block found")
+	}
+	if block.Type != pemTypePublicKey {
+		return fmt.Errorf("PEM block has type %q instead of %q", block.Type, pemTypePublicKey)
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return fmt.Errorf("trailing data after PEM block")
+	}
+	return pk.UnmarshalBinary(block.Bytes)
+}
@@ -0,0 +1,166 @@
+package mbpqs
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHeaderOid(t *testing.T) {
+	for oid, p := range paramSets {
+		buf := make([]byte, headerSize(p))
+		if l := p.writeHeaderInto(kindMsgSignature, buf); l != 6 {
+			t.Fatalf("header of OID %d has %d bytes instead of 6", oid, l)
+		}
+		ctx, rest, err := readHeader(kindMsgSignature, buf)
+		if err != nil {
+			t.Fatalf("reading header of OID %d failed with error %s", oid, err)
+		}
+		if len(rest) != 0 || *ctx.params != *p {
+			t.Fatalf("header of OID %d did not decode to the same parameters", oid)
+		}
+	}
+	p := InitParam(32, 3, 7, 2, 1, 4)
+	buf := make([]byte, headerSize(p))
+	p.writeHeaderInto(kindRootSignature, buf)
+	ctx, _, err := readHeader(kindRootSignature, buf)
+	if err != nil {
+		t.Fatalf("reading header of custom parameters failed with error %s", err)
+	}
+	if *ctx.params != *p {
+		t.Fatal("header did not decode to the same custom parameters")
+	}
+	if _, _, err = readHeader(kindMsgSignature, buf); err == nil {
+		t.Fatal("reading a header of the wrong kind did not give an error")
+	}
+
+	// The hash function is part of the custom parameters.
+	p = InitParamHash(32, 3, 7, 2, 1, 4, SHAKE256)
+	buf = make([]byte, headerSize(p))
+	p.writeHeaderInto(kindRootSignature, buf)
+	ctx, _, err = readHeader(kindRootSignature, buf)
+	if err != nil {
+		t.Fatalf("reading header of custom parameters failed with error %s", err)
+	}
+	if ctx.params.hash != SHAKE256 {
+		t.Fatalf("header decoded to hash function %s instead of SHAKE256", ctx.params.hash)
+	}
+	buf[len(buf)-1] = 0xff
+	if _, _, err = readHeader(kindRootSignature, buf); err == nil {
+		t.Fatal("reading a header with an unknown hash function did not give an error")
+	}
+}
+
+func TestSignatureMarshalling(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 3, 0, 1, 16), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	msg := []byte("Message to be sent over the wire")
+	msgSig, err := sk.SignMsg(chIdx, msg)
+	if err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	if _, err = sk.SignMsg(chIdx, msg); err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	growSig, err := sk.GrowChannel(chIdx)
+	if err != nil {
+		t.Fatalf("Growing channel failed with error %s", err)
+	}
+
+	// Send each signature over the 'wire', and verify the received one.
+	rtBuf, err := rtSig.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Marshalling RootSignature failed with error %s", err)
+	}
+	var rtSig2 RootSignature
+	if err = rtSig2.UnmarshalBinary(rtBuf); err != nil {
+		t.Fatalf("Unmarshalling RootSignature failed with error %s", err)
+	}
+	if accept, err := pk.VerifyChannel(&rtSig2); !accept || err != nil {
+		t.Fatalf("Received RootSignature not accepted: %v", err)
+	}
+
+	msgBuf, err := msgSig.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Marshalling MsgSignature failed with error %s", err)
+	}
+	sig, err := UnmarshalSignature(msgBuf)
+	if err != nil {
+		t.Fatalf("Unmarshalling MsgSignature failed with error %s", err)
+	}
+	msgSig2, ok := sig.(*MsgSignature)
+	if !ok {
+		t.Fatalf("UnmarshalSignature returned %T instead of *MsgSignature", sig)
+	}
+	if accept, err := pk.VerifyMsg(msgSig2, msg, rtSig2.NextAuthNode()); !accept || err != nil {
+		t.Fatalf("Received MsgSignature not accepted: %v", err)
+	}
+
+	growBuf, err := growSig.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Marshalling GrowSignature failed with error %s", err)
+	}
+	var growSig2 GrowSignature
+	if err = growSig2.UnmarshalBinary(growBuf); err != nil {
+		t.Fatalf("Unmarshalling GrowSignature failed with error %s", err)
+	}
+	if !bytes.Equal(growSig2.NextAuthNode(), growSig.NextAuthNode()) {
+		t.Fatal("Received GrowSignature signs a different root")
+	}
+
+	// Truncated and extended encodings must be rejected.
+	if err = msgSig2.UnmarshalBinary(msgBuf[:len(msgBuf)-1]); err == nil {
+		t.Fatal("Unmarshalling a truncated MsgSignature did not give an error")
+	}
+	if err = rtSig2.UnmarshalBinary(append(rtBuf, 0)); err == nil {
+		t.Fatal("Unmarshalling an extended RootSignature did not give an error")
+	}
+	if err = growSig2.UnmarshalBinary(msgBuf); err == nil {
+		t.Fatal("Unmarshalling a MsgSignature as GrowSignature did not give an error")
+	}
+}
+
+func TestPublicKeyMarshalling(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(64, 2, 2, 0, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	_, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+
+	text, err := pk.MarshalText()
+	if err != nil {
+		t.Fatalf("Armoring PublicKey failed with error %s", err)
+	}
+	if !bytes.HasPrefix(text, []byte("-----BEGIN MBPQS PUBLIC KEY-----")) {
+		t.Fatalf("Armored PublicKey has unexpected form:\n%s", text)
+	}
+	var pk2 PublicKey
+	if err = pk2.UnmarshalText(text); err != nil {
+		t.Fatalf("Loading armored PublicKey failed with error %s", err)
+	}
+	if !bytes.Equal(pk2.root, pk.root) || !bytes.Equal(pk2.pubSeed, pk.pubSeed) {
+		t.Fatal("Loaded PublicKey differs from the original")
+	}
+	if accept, err := pk2.VerifyChannel(rtSig); !accept || err != nil {
+		t.Fatalf("Loaded PublicKey does not accept a correct RootSignature: %v", err)
+	}
+
+	buf, err := pk.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Marshalling PublicKey failed with error %s", err)
+	}
+	if err = pk2.UnmarshalBinary(buf[:len(buf)-1]); err == nil {
+		t.Fatal("Unmarshalling a truncated PublicKey did not give an error")
+	}
+	if err = pk2.UnmarshalText(append(text, "garbage"...)); err == nil {
+		t.Fatal("Loading an armored PublicKey with trailing data did not give an error")
+	}
+}
@@ -1,8 +1,10 @@
 package mbpqs
 
 import (
+	"bytes"
 	"crypto/subtle"
 	"fmt"
+	"io"
 	"sync"
 )
 
@@ -13,6 +15,10 @@ type Channel struct {
 	seqNo      SignatureSeqNo // The unique sequence number of the next available key.
 	mux        sync.Mutex     // Used when mutual exclusion for the channel is required.
 	cache      []byte         // Cached internal nodes of current chain tree.
+	closed     bool           // Whether the channel is closed by a CloseSignature.
+	// The first chainSeqNo and seqNo which are not reserved in the state store yet.
+	chainSeqNoReserved uint32
+	seqNoReserved      SignatureSeqNo
 }
 
 // PrivateKey is a MBPQS private key */
@@ -35,6 +41,20 @@ type PrivateKey struct {
 	ctx     *Context          // Context containing the MBPQS parameters.
 	ph      precomputedHashes // Precomputed hashes from the pubSeed and skSeed.
 	mux     sync.Mutex        // Used when mutual exclusion for the PrivateKey is required.
+	addMux  sync.Mutex        // Serializes AddChannel, see createChannel.
+	/* The store the state of the PrivateKey is saved to, nil if the
+	 * PrivateKey only lives in memory. Indices are reserved in the store
+	 * ahead of use, see keyfile.go and store.go.
+	 */
+	store         StateStore
+	seqNoReserved SignatureSeqNo // The first root tree leaf not reserved in the store yet.
+	rootLookahead uint32         // The amount of root tree leaves to reserve at once.
+	rootCache     rootTreeCache  // Traversal state of the root tree, see traversal.go.
+	watermarks    watermarks     // Low-watermark callbacks, see status.go.
+	journal       journal        // Journal of the used one-time keys, see journal.go.
+	succession    uint32         // Succession flags, see succession.go.
+	destroyed     bool           // Whether the secrets are zeroed by Destroy, see destroy.go.
+	locked        []byte         // Locked memory holding skSeed and skPrf, see destroy.go.
 }
 
 // PublicKey is a MBPQS public key.
@@ -60,6 +80,14 @@ func InitParam(n, rtH, chanH, gf uint32, c, w uint16) *Params {
 	}
 }
 
+// InitParamHash returns the parameters of InitParam, with F, H, H_msg and
+// PRF instantiated with hash function hash.
+func InitParamHash(n, rtH, chanH, gf uint32, c, w uint16, hash HashFunction) *Params {
+	p := InitParam(n, rtH, chanH, gf, c, w)
+	p.hash = hash
+	return p
+}
+
 // GenerateKeyPair generates a new MBPQS keypair for given parameters.
 func GenerateKeyPair(p *Params, t int) (*PrivateKey, *PublicKey, error) {
 	// Create new context including given parameters.
@@ -87,25 +115,104 @@ func GenerateKeyPair(p *Params, t int) (*PrivateKey, *PublicKey, error) {
 	}
 
 	// Derive a keypair from the initialized Context.
-	return ctx.deriveKeyPair(pubSeed, skSeed, skPrf)
+	return ctx.deriveKeyPair(skSeed, skPrf, pubSeed)
+}
+
+// DeriveKeyPair derives the MBPQS keypair for given parameters from the
+// n-byte seeds skSeed, skPrf and pubSeed. The same seeds always result in the
+// same keypair, which allows to regenerate a key from escrowed seeds.
+// Mind that a regenerated PrivateKey starts with all its keys unused.
+func DeriveKeyPair(p *Params, t int, skSeed, skPrf, pubSeed []byte) (*PrivateKey, *PublicKey, error) {
+	ctx, err := newContext(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx.threads = t
+	// Copy the seeds, such that the keys do not share memory with the caller.
+	return ctx.deriveKeyPair(append([]byte{}, skSeed...),
+		append([]byte{}, skPrf...), append([]byte{}, pubSeed...))
+}
+
+// GenerateKeyPairFromSeed derives the MBPQS keypair for given parameters
+// from a single n-byte master seed. The seeds skSeed, skPrf and pubSeed are
+// expanded from it as PRF(seed, 0), PRF(seed, 1) and PRF(seed, 2).
+func GenerateKeyPairFromSeed(p *Params, t int, seed []byte) (*PrivateKey, *PublicKey, error) {
+	ctx, err := newContext(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx.threads = t
+	if len(seed) != int(ctx.params.n) {
+		return nil, nil, fmt.Errorf("seed should have length %d", ctx.params.n)
+	}
+	pad := ctx.newScratchPad()
+	defer pad.wipe()
+	skSeed := ctx.prfUint64(pad, 0, seed)
+	skPrf := ctx.prfUint64(pad, 1, seed)
+	pubSeed := ctx.prfUint64(pad, 2, seed)
+	return ctx.deriveKeyPair(skSeed, skPrf, pubSeed)
+}
+
+// PublicKey returns the PublicKey belonging to the PrivateKey.
+func (sk *PrivateKey) PublicKey() *PublicKey {
+	return sk.derivePublicKey()
+}
+
+// String returns a description of the PrivateKey for humans, without its secrets.
+func (sk *PrivateKey) String() string {
+	sk.mux.Lock()
+	defer sk.mux.Unlock()
+	return fmt.Sprintf("PrivateKey{%s, root: %x, seqNo: %d, channels: %d}",
+		sk.ctx.params, sk.root, sk.seqNo, len(sk.Channels))
+}
+
+// String returns a description of the PublicKey for humans.
+func (pk *PublicKey) String() string {
+	return fmt.Sprintf("PublicKey{%s, root: %x}", pk.ctx.params, pk.root)
+}
+
+// String returns a description of the state of the channel for humans.
+func (ch *Channel) String() string {
+	ch.mux.Lock()
+	defer ch.mux.Unlock()
+	return fmt.Sprintf("Channel{layers: %d, chainSeqNo: %d, seqNo: %d}",
+		ch.layers, ch.chainSeqNo, ch.seqNo)
 }
 
 // SignChannelRoot is used to sign the n-byte channel root hash with the PrivateKey
 func (sk *PrivateKey) SignChannelRoot(chRt []byte) (*RootSignature, error) {
 	// Create a new scratchpad to do the signing computations on to avoid memory allocations.
 	pad := sk.ctx.newScratchPad()
+	defer pad.wipe()
 	seqNo, err := sk.GetSeqNo()
 	if err != nil {
 		return nil, err
 	}
+	return sk.signChannelRootAt(pad, seqNo, chRt)
+}
+
+// Signs the channel root chRt with root tree leaf seqNo, which is reserved
+// by GetSeqNo.
+func (sk *PrivateKey) signChannelRootAt(pad scratchPad, seqNo SignatureSeqNo, chRt []byte) (*RootSignature, error) {
+	if err := sk.journalKey(JournalEntry{
+		Kind:   JournalRoot,
+		Key:    OTSKey{Root: true, Index: uint32(seqNo)},
+		Digest: chRt,
+	}); err != nil {
+		return nil, err
+	}
 
 	// Set otsAddr to calculate wotsSign over the message.
 	var otsAddr address           // All fields should be 0, that's why init is enough.
 	otsAddr.setOTS(uint32(seqNo)) // Except the OTS address which is seqNo = index.
 
-	// Compute the root tree to build the authentication path
-	rt := sk.ctx.genRootTree(pad, sk.ph)
-	authPath := rt.AuthPath(uint32(seqNo))
+	// Retrieve the authentication path from the root tree cache, and save
+	// the advanced cache. It only saves time when the PrivateKey is loaded
+	// again, so failing to save it does not fail the signature.
+	authPath := sk.rootAuthPath(pad, uint32(seqNo))
+	sk.mux.Lock()
+	sk.saveState()
+	sk.mux.Unlock()
 	sig := RootSignature{
 		ctx:      sk.ctx,
 		seqNo:    seqNo,
@@ -113,11 +220,18 @@ func (sk *PrivateKey) SignChannelRoot(chRt []byte) (*RootSignature, error) {
 		authPath: authPath,
 		rootHash: chRt,
 	}
+	sk.checkRootWatermark()
 	return &sig, nil
 }
 
 // VerifyChannelRoot is used to verify the signature on the channel root.
 func (pk *PublicKey) VerifyChannelRoot(rtSig *RootSignature, chRt []byte) (bool, error) {
+	if err := pk.checkSignature(rtSig); err != nil {
+		return false, err
+	}
+	if len(chRt) != int(pk.ctx.params.n) {
+		return false, verifyError(ErrMalformedSignature, "signed root of %d bytes", len(chRt))
+	}
 	// Create a new scratchpad to do the verifiyng computations on.
 	pad := pk.ctx.newScratchPad()
 	// Derive the wotsPk from the signature.
@@ -162,7 +276,7 @@ func (pk *PublicKey) VerifyChannelRoot(rtSig *RootSignature, chRt []byte) (bool,
 	chRt = curHash
 
 	if subtle.ConstantTimeCompare(chRt, pk.root) != 1 {
-		return false, fmt.Errorf("invalid signature")
+		return false, verifyError(ErrInvalidSignature, "%s does not hash up to the root", rtSig)
 	}
 	return true, nil
 }
@@ -172,35 +286,97 @@ func (sk *PrivateKey) GetSeqNo() (SignatureSeqNo, error) {
 	sk.mux.Lock()
 	// Unlock the lock when the funtion is finished.
 	defer sk.mux.Unlock()
+	if sk.destroyed {
+		return 0, fmt.Errorf("the private key is destroyed")
+	}
 	// Check if there are still root keys left to sign channels.
-	if uint64(sk.seqNo) >= (1 << sk.ctx.params.rootH) {
+	if uint64(sk.seqNo) >= sk.rootLeafLimit() {
 		return 0, fmt.Errorf("no unused channel signing keys left")
 	}
+	// Reserve the leaf in the state store before it is used.
+	if err := sk.reserveRootLeaf(); err != nil {
+		return 0, err
+	}
 	sk.seqNo++
 	return sk.seqNo - 1, nil
 }
 
 // SignChannelMsg signs the message 'msg' in the channel with index chIdx.
-// Be cautious: this
 func (sk *PrivateKey) SignChannelMsg(chIdx uint32, msg []byte) (*MsgSignature, error) {
+	return sk.SignChannelReader(chIdx, bytes.NewReader(msg))
+}
+
+// SignChannelReader signs the message read from msg in the channel with index
+// chIdx. The message is streamed into the message hash, so it does not need
+// to fit in memory. The channel is locked while the message is read, and its
+// next key is only used once the whole message is read successfully.
+func (sk *PrivateKey) SignChannelReader(chIdx uint32, msg io.Reader) (*MsgSignature, error) {
+	_, sig, err := sk.signChannelReader(chIdx, msg, false)
+	return sig, err
+}
+
+// AutoGrowSignature holds the signatures made by signing a message with
+// automatic channel growth. Verifiers should verify Grow, if it is set,
+// before Msg.
+type AutoGrowSignature struct {
+	Grow *GrowSignature // The GrowSignature of the last chain tree, if the channel is grown.
+	Msg  *MsgSignature  // The signature over the message.
+}
+
+// Signatures returns the signatures in the order they should be verified.
+func (as *AutoGrowSignature) Signatures() []Signature {
+	if as.Grow == nil {
+		return []Signature{as.Msg}
+	}
+	return []Signature{as.Grow, as.Msg}
+}
+
+// SignChannelMsgAutoGrow signs the message 'msg' in the channel with index
+// chIdx. If the current chain tree of the channel has no keys left to sign
+// messages with, the channel is grown first, and the GrowSignature is
+// returned along with the MsgSignature.
+func (sk *PrivateKey) SignChannelMsgAutoGrow(chIdx uint32, msg []byte) (*AutoGrowSignature, error) {
+	return sk.SignChannelReaderAutoGrow(chIdx, bytes.NewReader(msg))
+}
+
+// SignChannelReaderAutoGrow is SignChannelMsgAutoGrow for the message read
+// from msg. The message is read before the channel is grown. If the message
+// can not be signed after the channel is grown, the error is returned together
+// with an AutoGrowSignature holding only the GrowSignature, which should still
+// be published to verifiers.
+func (sk *PrivateKey) SignChannelReaderAutoGrow(chIdx uint32, msg io.Reader) (*AutoGrowSignature, error) {
+	growSig, sig, err := sk.signChannelReader(chIdx, msg, true)
+	if err != nil && growSig == nil {
+		return nil, err
+	}
+	return &AutoGrowSignature{Grow: growSig, Msg: sig}, err
+}
+
+// Signs the message read from msg in the channel with index chIdx. If
+// autoGrow is set, the channel is grown when its chain tree has no message
+// signing keys left, and the GrowSignature is returned as well.
+func (sk *PrivateKey) signChannelReader(chIdx uint32, msg io.Reader, autoGrow bool) (*GrowSignature, *MsgSignature, error) {
 	// Returns an error if the channel does not exist.
 	if chIdx >= uint32(len(sk.Channels)) {
-		return nil, fmt.Errorf("channel does not exist, please create it first")
+		return nil, nil, fmt.Errorf("channel does not exist, please create it first")
 	}
 	ch := sk.getChannel(chIdx)
-	// If the function call does not have the 'lastOne' flag, check if it is the last key
-	// in the chain, so that it will not be used to sign a message instead of the next chain.
-	if sk.ctx.chainTreeHeight(ch.layers)-1 == uint32(ch.chainSeqNo) {
-		return nil, fmt.Errorf("please grow the channel before signing new messages in it")
-	}
 
 	// Create scratchpad to avoid memory allocations.
 	pad := sk.ctx.newScratchPad()
-	// Retrieve and update chainSeqNo and channel seqNo
-	chainSeqNo, seqNo, err := sk.ChannelSeqNos(chIdx)
-	if err != nil {
-		return nil, err
+	defer pad.wipe()
+	ch.mux.Lock()
+	// Check whether there is a next key before reading the message. Growing
+	// the channel does not change the channel seqNo of the next key.
+	grow := autoGrow && sk.chainTreeFull(ch) && uint32(ch.seqNo) != ^uint32(0)
+	if !grow {
+		if _, _, err := sk.nextChannelSeqNos(ch); err != nil {
+			ch.mux.Unlock()
+			return nil, nil, err
+		}
 	}
+	seqNo := ch.seqNo
+
 	// 64-bit sigIdx, seed value for drv to avoid collisions with seqNo's in the root tree!
 	// This value includes the channelID in the first 32 bits of the seed, and the seqNo in the last 32 bits.
 	sigIdx := uint64(chIdx)<<32 + uint64(seqNo)
@@ -208,42 +384,42 @@ func (sk *PrivateKey) SignChannelMsg(chIdx uint32, msg []byte) (*MsgSignature, e
 	// Compute drv (R) pseudorandomly from the seed.
 	drv := sk.ctx.prfUint64(pad, sigIdx, sk.skPrf)
 
-	chLayer := sk.getChannelLayer(chIdx)
+	hashMsg, err := sk.ctx.hashMessageReader(pad, msg, drv, sk.root, sigIdx)
+	var growSig *GrowSignature
+	if err == nil && grow {
+		growSig, err = sk.growChannelLocked(chIdx, ch)
+	}
+	// Retrieve the chainSeqNo of the next key, which is only used once
+	// the message is hashed.
+	var chainSeqNo uint32
+	if err == nil {
+		chainSeqNo, _, err = sk.nextChannelSeqNos(ch)
+	}
+	chLayer := ch.layers
+	if err == nil {
+		err = sk.useChannelSeqNos(ch, &JournalEntry{
+			Kind:   JournalMsg,
+			Key:    OTSKey{Channel: chIdx, Layer: chLayer, Index: chainSeqNo},
+			SeqNo:  seqNo,
+			Digest: hashMsg,
+		})
+	}
+	cache := ch.cache
+	status := sk.channelStatus(chIdx, ch)
+	ch.mux.Unlock()
+	if err != nil {
+		return growSig, nil, err
+	}
+	sk.checkChannelWatermark(status)
 
-	var authPathNode []byte
-	// Compute the chainTree.
-	c := uint32(sk.ctx.params.c)
-	if c == 0 { // There is no cache.
-		// get nodeHeight to generate chainTree till
-		nh := sk.ctx.getNodeHeight(chLayer, chainSeqNo)
-		ct := sk.genChainTreeTill(pad, chIdx, chLayer, nh)
-		// Select the authentication node in the tree.
-		authPathNode = sk.ctx.authPath(chainSeqNo, chLayer, ct)
-	} else if c == 1 { // There is a cache, and the required authnode is in the cache.
-		authPathNode = ch.cache[((chainSeqNo+1)/c-1)*sk.ctx.params.n : ((chainSeqNo+1)/c-1)*sk.ctx.params.n+sk.ctx.params.n]
-	} else {
-		return nil, fmt.Errorf("Caching parameter c must be 1 or 0 and was %d", c)
-	}
-	// } else { // There is a chache, and the required authnode can be computed from a node in the cache.
-	// 	h := sk.ctx.params.chanH
-	// 	nh := h - 2 - chainSeqNo
-	// 	closesNode := (nh/c + ((h - 1) % c))
-	// 	ct := sk.genChainTreeTill(pad, chIdx, chLayer, nh+1)
-	// 	authPathNode = ct.node(nh-closesNode, 0)
-	// }
+	authPathNode := sk.chainAuthPath(pad, chIdx, chLayer, chainSeqNo, cache)
 
 	// Set OTSaddr to calculate the Wots sig over the message.
-
 	var otsAddr address
 	otsAddr.setOTS(uint32(chainSeqNo))
 	otsAddr.setLayer(chLayer)
 	otsAddr.setTree(uint64(chIdx))
 
-	hashMsg, err := sk.ctx.hashMessage(pad, msg, drv, sk.root, sigIdx)
-	if err != nil {
-		return nil, err
-	}
-
 	// These fields can only be set after check for required rootSignature is made.
 	sig := &MsgSignature{
 		ctx:        sk.ctx,
@@ -256,57 +432,69 @@ func (sk *PrivateKey) SignChannelMsg(chIdx uint32, msg []byte) (*MsgSignature, e
 		authPath:   authPathNode,
 	}
 
-	return sig, nil
+	return growSig, sig, nil
+}
+
+// Returns the authentication path node of key chainSeqNo in chain tree chLayer
+// of channel chIdx, which has the given internal node cache.
+func (sk *PrivateKey) chainAuthPath(pad scratchPad, chIdx, chLayer, chainSeqNo uint32, cache []byte) []byte {
+	// Get the height of the authentication node in the chainTree.
+	nh := sk.ctx.getNodeHeight(chLayer, chainSeqNo)
+	if sk.ctx.params.c == 0 { // There is no cache.
+		// Compute the chainTree till the authentication node.
+		ct := sk.genChainTreeTill(pad, chIdx, chLayer, nh)
+		// Select the authentication node in the tree.
+		return sk.ctx.authPath(chainSeqNo, chLayer, ct)
+	}
+	// There is a cache, compute the authentication node from the closest cached node.
+	return sk.cachedChainTreeNode(pad, cache, chIdx, chLayer, nh)
 }
 
 // Create a new channel, returns its index and the signature of its first chainTreeRoot.
 func (sk *PrivateKey) createChannel() (uint32, *RootSignature, error) {
+	// Channels are added one at a time, such that the index of the new
+	// channel does not change while its first chain tree is computed.
+	sk.addMux.Lock()
+	defer sk.addMux.Unlock()
+	// Reserve the root tree leaf before the channel is added, such that no
+	// channel is saved without a RootSignature.
+	seqNo, err := sk.GetSeqNo()
+	if err != nil {
+		return 0, nil, err
+	}
 	// Determine the channelIndex.
+	sk.mux.Lock()
 	chIdx := uint32(len(sk.Channels))
+	sk.mux.Unlock()
 	// Scratchpad to avoid computation allocations.
 	pad := sk.ctx.newScratchPad()
+	defer pad.wipe()
 	// Create a new channel, because it does not exist yet.
 	ch := sk.deriveChannel(chIdx)
 
 	// Create the first chainTree for the channel
 	ct := sk.genChainTree(pad, chIdx, 1)
 	// Initialize internal node cache if c > 0.
-	if sk.ctx.params.c > 0 {
-		h := sk.ctx.params.chanH
-		c := uint32(sk.ctx.params.c)
-		n := sk.ctx.params.n
-		cacheBuf := make([]byte, n*((h-1)/c))
-		//First cache node height .
-		nh := h - 1 - c
-
-		// Fill the cache untill it reaches it's last node.
-		var idx uint32
-		for nh >= (h-1)%c {
-			// Put the node at height nh in the cache.
-			copy(cacheBuf[idx*n:idx*n+n], ct.node(nh, 0))
-			// Decrease the node height with c.
-			if nh == (h-1)%c {
-				break
-			}
-			nh -= c
-			// Increase cacheBuf index counter.
-			idx++
-		}
-		ch.cache = cacheBuf
-	}
-	// Appending the created channel to the channellist in the PK.
-	sk.Channels = append(sk.Channels, ch)
+	ch.cache = sk.ctx.chainTreeCache(ct, 1)
 	// Update the channel.
-	ch.mux.Lock()
 	ch.layers++
 	ch.chainSeqNo = 0
-	ch.mux.Unlock()
+	// Appending the created channel to the channellist in the PK, and
+	// save it to the state store before any of its keys can be used.
+	sk.mux.Lock()
+	sk.Channels = append(sk.Channels, ch)
+	if err := sk.saveState(); err != nil {
+		sk.Channels = sk.Channels[:chIdx]
+		sk.mux.Unlock()
+		return 0, nil, err
+	}
+	sk.mux.Unlock()
 
 	// Get the root, and sign it.
 	root := ct.getRootNode()
 
 	// Sign the root.
-	rtSig, err := sk.SignChannelRoot(root)
+	rtSig, err := sk.signChannelRootAt(pad, seqNo, root)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -315,16 +503,52 @@ func (sk *PrivateKey) createChannel() (uint32, *RootSignature, error) {
 
 // VerifyChannelMsg return true if the signature/message pair is valid.
 func (pk *PublicKey) VerifyChannelMsg(sig *MsgSignature, msg, authNode []byte) (bool, error) {
-	pad := pk.ctx.newScratchPad()
+	return pk.VerifyChannelReader(sig, bytes.NewReader(msg), authNode)
+}
 
+// VerifyChannelReader returns true if the signature is valid for the message
+// read from msg. The message is streamed into the message hash.
+func (pk *PublicKey) VerifyChannelReader(sig *MsgSignature, msg io.Reader, authNode []byte) (bool, error) {
+	if _, err := pk.verifyMsgSig(pk.ctx.newScratchPad(), sig, msg, authNode); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Verifies the MsgSignature over the message read from msg against the
+// previous authentication node authNode. Returns the leaf of its key if the
+// signature is accepted.
+func (pk *PublicKey) verifyMsgSig(pad scratchPad, sig *MsgSignature, msg io.Reader, authNode []byte) ([]byte, error) {
+	if err := pk.checkSignature(sig); err != nil {
+		return nil, err
+	}
+	if authNode == nil {
+		return nil, verifyError(ErrMissingAuthNode, "no authentication node for %s", sig)
+	}
+	leaf, node, err := pk.msgSigNode(pad, sig, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare the computed value with the previous authentication path node.
+	if subtle.ConstantTimeCompare(node, authNode) != 1 {
+		return nil, verifyError(ErrInvalidSignature, "%s does not hash up to the authentication node", sig)
+	}
+	return leaf, nil
+}
+
+// Computes the leaf of the key which made the MsgSignature over the message
+// read from msg, and the chain tree node it hashes up to with the
+// authentication path of the signature, which is N(H-1-chainSeqNo, 0).
+func (pk *PublicKey) msgSigNode(pad scratchPad, sig *MsgSignature, msg io.Reader) ([]byte, []byte, error) {
 	// 64-bit drvSeed value to avoid collisions with seqNo's in the root tree!
 	// This value includes the channelID in the first 32 bits of the seed, and the seqNo in the last 32 bits.
 	sigIdx := uint64(sig.chIdx)<<32 + uint64(sig.seqNo)
 
 	// Hash the message with H_msg.
-	hashMsg, err := pk.ctx.hashMessage(pad, msg, sig.drv, pk.root, sigIdx)
+	hashMsg, err := pk.ctx.hashMessageReader(pad, msg, sig.drv, pk.root, sigIdx)
 	if err != nil {
-		return false, err
+		return nil, nil, err
 	}
 
 	// Derive SubTreeAddr
@@ -346,7 +570,7 @@ func (pk *PublicKey) VerifyChannelMsg(sig *MsgSignature, msg, authNode []byte) (
 	lTreeAddr.setSubTreeFrom(addr)
 	lTreeAddr.setType(lTreeAddrType)
 	lTreeAddr.setLTree(uint32(sig.chainSeqNo))
-	curHash := pk.ctx.lTree(pad, wotsPk, pk.ph, lTreeAddr)
+	leaf := append([]byte{}, pk.ctx.lTree(pad, wotsPk, pk.ph, lTreeAddr)...)
 
 	// Now hash the leaf with the authentication path.
 	var nodeAddr address
@@ -355,11 +579,7 @@ func (pk *PublicKey) VerifyChannelMsg(sig *MsgSignature, msg, authNode []byte) (
 	nodeAddr.setTreeHeight(pk.ctx.getNodeHeight(sig.layer, sig.chainSeqNo))
 	nodeAddr.setTreeIndex(0)
 
-	pk.ctx.hInto(pad, sig.authPath, curHash, pk.ph, nodeAddr, curHash)
-
-	// Compare the computed value with the previous authentication path node.
-	if subtle.ConstantTimeCompare(curHash, authNode) != 1 {
-		return false, nil
-	}
-	return true, nil
+	node := make([]byte, pk.ctx.params.n)
+	pk.ctx.hInto(pad, sig.authPath, leaf, pk.ph, nodeAddr, node)
+	return leaf, node, nil
 }
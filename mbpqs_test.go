@@ -1,6 +1,10 @@
 package mbpqs
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"testing"
 )
 
@@ -241,7 +245,7 @@ func TestChannelCreation(t *testing.T) {
 	}
 }
 
-//Signing growing and signing :-)
+// Signing growing and signing :-)
 func TestSignGrowSign(t *testing.T) {
 	var chanH uint32 = 6
 	msg := []byte("Message to be signed.")
@@ -265,3 +269,248 @@ func TestSignGrowSign(t *testing.T) {
 		l = sk.Channels[chIdx].layers
 	}
 }
+
+func TestDeriveKeyPair(t *testing.T) {
+	p := InitParam(32, 2, 3, 0, 0, 16)
+	skSeed := make([]byte, 32)
+	skPrf := make([]byte, 32)
+	pubSeed := make([]byte, 32)
+	for i := 0; i < 32; i++ {
+		skSeed[i] = byte(i)
+		skPrf[i] = byte(2 * i)
+		pubSeed[i] = byte(3 * i)
+	}
+	sk, pk, err := DeriveKeyPair(p, 0, skSeed, skPrf, pubSeed)
+	if err != nil {
+		t.Fatalf("Deriving keypair failed with error %s", err)
+	}
+	// The seeds must end up in their own fields.
+	if !bytes.Equal(sk.skSeed, skSeed) || !bytes.Equal(sk.skPrf, skPrf) ||
+		!bytes.Equal(sk.pubSeed, pubSeed) || !bytes.Equal(pk.pubSeed, pubSeed) {
+		t.Fatal("Derived keypair holds the seeds in the wrong fields")
+	}
+	// Fixed seeds result in a fixed public key.
+	expect := "ab38a3ba4bea58c576c8272de10e2ed480716653882b02bed07e63265b0b55d2"
+	if root := hex.EncodeToString(pk.root); root != expect {
+		t.Fatalf("Derived public key has root %s instead of %s", root, expect)
+	}
+
+	// A key regenerated from the same seeds verifies the same signatures.
+	sk2, _, err := DeriveKeyPair(p, 0, skSeed, skPrf, pubSeed)
+	if err != nil {
+		t.Fatalf("Deriving keypair failed with error %s", err)
+	}
+	_, rtSig, err := sk2.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	if accept, err := pk.VerifyChannel(rtSig); !accept || err != nil {
+		t.Fatalf("Regenerated key does not match the public key: %v", err)
+	}
+
+	// GenerateKeyPair draws skSeed, skPrf and pubSeed in this order, and
+	// derives its keypair from them in the same way, so its key can be
+	// regenerated from the seeds.
+	random := make([]byte, 96)
+	for i := range random {
+		random[i] = byte(i)
+	}
+	randReader = bytes.NewReader(random)
+	sk, pk, err = GenerateKeyPair(p, 0)
+	randReader = rand.Reader
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	sk2, pk2, err := DeriveKeyPair(p, 0, random[:32], random[32:64], random[64:])
+	if err != nil {
+		t.Fatalf("Deriving keypair failed with error %s", err)
+	}
+	if !bytes.Equal(pk.root, pk2.root) || !bytes.Equal(pk.pubSeed, random[64:]) ||
+		!bytes.Equal(sk.skSeed, sk2.skSeed) || !bytes.Equal(sk.skPrf, sk2.skPrf) {
+		t.Fatal("GenerateKeyPair does not derive its keypair from its seeds in order")
+	}
+}
+
+func TestGenerateKeyPairFromSeed(t *testing.T) {
+	p := InitParam(32, 2, 3, 0, 0, 16)
+	seed := make([]byte, 32)
+	for i := 0; i < 32; i++ {
+		seed[i] = byte(i)
+	}
+	_, pk, err := GenerateKeyPairFromSeed(p, 0, seed)
+	if err != nil {
+		t.Fatalf("Deriving keypair from seed failed with error %s", err)
+	}
+	_, pk2, err := GenerateKeyPairFromSeed(p, 0, seed)
+	if err != nil {
+		t.Fatalf("Deriving keypair from seed failed with error %s", err)
+	}
+	if !bytes.Equal(pk.root, pk2.root) {
+		t.Fatal("Keypairs derived from the same seed differ")
+	}
+	expect := "85d0e9814649b9091bbc2340549a59ca1e447b0dfef8651c3e4161f0f6a383f8"
+	if root := hex.EncodeToString(pk.root); root != expect {
+		t.Fatalf("Public key derived from seed has root %s instead of %s", root, expect)
+	}
+	if _, _, err = GenerateKeyPairFromSeed(p, 0, seed[:16]); err == nil {
+		t.Fatal("Deriving keypair from a short seed did not give an error")
+	}
+}
+
+// Sign and verify in a channel with every hash function and security parameter.
+func TestHashFunctions(t *testing.T) {
+	msg := []byte("Block signed with another hash function")
+	roots := make(map[string]bool)
+	for _, hash := range []HashFunction{SHA2, SHAKE256} {
+		for _, n := range []uint32{24, 32, 64} {
+			p := InitParamHash(n, 2, 3, 1, 1, 16, hash)
+			sk, pk, err := GenerateKeyPair(p, 0)
+			if err != nil {
+				t.Fatalf("KeyGen with %s failed with error %s", p, err)
+			}
+			if roots[string(pk.root)] {
+				t.Fatalf("Root with %s equals the root with another hash function", p)
+			}
+			roots[string(pk.root)] = true
+			chIdx, rtSig, err := sk.AddChannel()
+			if err != nil {
+				t.Fatalf("Adding channel with %s failed with error %s", p, err)
+			}
+			cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+			if err != nil {
+				t.Fatalf("Verifying RootSignature with %s failed with error %s", p, err)
+			}
+			for i := 0; i < 2; i++ {
+				sig, err := sk.SignMsg(chIdx, msg)
+				if err != nil {
+					t.Fatalf("Signing with %s failed with error %s", p, err)
+				}
+				if accept, err := cv.VerifyMsg(sig, msg); !accept || err != nil {
+					t.Fatalf("Correct MsgSignature with %s not accepted: %v", p, err)
+				}
+			}
+			growSig, err := sk.GrowChannel(chIdx)
+			if err != nil {
+				t.Fatalf("Growing channel with %s failed with error %s", p, err)
+			}
+			if accept, err := cv.VerifyGrow(growSig); !accept || err != nil {
+				t.Fatalf("Correct GrowSignature with %s not accepted: %v", p, err)
+			}
+			sig, err := sk.SignMsg(chIdx, msg)
+			if err != nil {
+				t.Fatalf("Signing with %s failed with error %s", p, err)
+			}
+			if accept, _ := cv.VerifyMsg(sig, []byte("Another block")); accept {
+				t.Fatalf("MsgSignature with %s accepted for another message", p)
+			}
+		}
+	}
+}
+
+// A failing reader for SignChannelReader.
+type failingReader struct{ read int }
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read >= 1<<16 {
+		return 0, fmt.Errorf("disk on fire")
+	}
+	r.read += len(p)
+	return len(p), nil
+}
+
+func TestSignChannelReader(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 4, 0, 0, 16), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	// A message which is larger than the buffers used to stream it.
+	msg := bytes.Repeat([]byte("Large block in the channel. "), 1<<16)
+
+	// A failing reader does not use a key.
+	if _, err = sk.SignChannelReader(chIdx, &failingReader{}); err == nil {
+		t.Fatal("Signing a failing reader did not give an error")
+	}
+	sig, err := sk.SignChannelReader(chIdx, bytes.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Signing reader failed with error %s", err)
+	}
+	if sig.seqNo != 0 || sig.chainSeqNo != 0 {
+		t.Fatalf("Signature after a failing reader has seqNo %d and chainSeqNo %d", sig.seqNo, sig.chainSeqNo)
+	}
+
+	// The streamed and the buffered message give the same results.
+	authNode := rtSig.NextAuthNode()
+	if accept, err := pk.VerifyChannelReader(sig, bytes.NewReader(msg), authNode); !accept || err != nil {
+		t.Fatalf("Correct signature not accepted from reader: %v", err)
+	}
+	if accept, err := pk.VerifyChannelMsg(sig, msg, authNode); !accept || err != nil {
+		t.Fatalf("Correct signature not accepted from bytes: %v", err)
+	}
+	if accept, _ := pk.VerifyChannelReader(sig, bytes.NewReader(msg[1:]), authNode); accept {
+		t.Fatal("Signature accepted for another message")
+	}
+	if accept, err := pk.VerifyChannelReader(sig, &failingReader{}, authNode); accept || err == nil {
+		t.Fatal("Verifying a failing reader did not give an error")
+	}
+}
+
+func TestSignChannelMsgAutoGrow(t *testing.T) {
+	var chanH uint32 = 2
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, chanH, 1, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+	if err != nil {
+		t.Fatalf("Creating verifier failed with error %s", err)
+	}
+
+	// Chain trees of heights 2, 3 and 4 hold 1, 2 and 3 messages.
+	grows := 0
+	for i := 0; i < 6; i++ {
+		msg := []byte(fmt.Sprintf("Block %d", i))
+		sig, err := sk.SignChannelMsgAutoGrow(chIdx, msg)
+		if err != nil {
+			t.Fatalf("Signing message %d failed with error %s", i, err)
+		}
+		if sig.Grow != nil {
+			grows++
+		}
+		if accept, err := cv.VerifyAutoGrow(sig, msg); !accept || err != nil {
+			t.Fatalf("Correct signatures for message %d not accepted: %v", i, err)
+		}
+	}
+	if grows != 2 || cv.Layer() != 3 {
+		t.Fatalf("Channel is grown %d times, verifier is at layer %d", grows, cv.Layer())
+	}
+
+	// A failing reader does not grow the channel, nor use a key.
+	if _, err = sk.SignChannelReaderAutoGrow(chIdx, &failingReader{}); err == nil {
+		t.Fatal("Signing a failing reader did not give an error")
+	}
+	if sk.getChannelLayer(chIdx) != 3 {
+		t.Fatal("Channel is grown for a failing reader")
+	}
+	msg := []byte("Block 6")
+	sig, err := sk.SignChannelMsgAutoGrow(chIdx, msg)
+	if err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	sigs := sig.Signatures()
+	if len(sigs) != 2 {
+		t.Fatalf("Signing with a full chain tree gave %d signatures instead of 2", len(sigs))
+	}
+	for _, s := range sigs {
+		if accept, err := cv.Verify(s, msg); !accept || err != nil {
+			t.Fatalf("Correct signature %v not accepted: %v", s, err)
+		}
+	}
+}
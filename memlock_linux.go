@@ -0,0 +1,35 @@
+//go:build linux
+
+package mbpqs
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// MADV_DONTDUMP, which the syscall package does not define on every architecture.
+const madvDontDump = 0x10
+
+// Allocates size bytes outside the Go heap, which are locked into RAM such
+// that they are never swapped, and excluded from core dumps.
+func allocLockedMemory(size int) ([]byte, error) {
+	buf, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, fmt.Errorf("allocating memory failed: %s", err)
+	}
+	if err = syscall.Mlock(buf); err != nil {
+		syscall.Munmap(buf)
+		return nil, fmt.Errorf("locking memory failed: %s", err)
+	}
+	// Older kernels do not know MADV_DONTDUMP, the memory is locked anyway.
+	syscall.Madvise(buf, madvDontDump)
+	return buf, nil
+}
+
+// Zeroes and releases memory allocated by allocLockedMemory.
+func freeLockedMemory(buf []byte) {
+	clear(buf)
+	syscall.Munlock(buf)
+	syscall.Munmap(buf)
+}
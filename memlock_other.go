@@ -0,0 +1,17 @@
+//go:build !linux
+
+package mbpqs
+
+import (
+	"fmt"
+)
+
+// Locked memory is only implemented for Linux, see memlock_linux.go.
+func allocLockedMemory(size int) ([]byte, error) {
+	return nil, fmt.Errorf("locking memory is only supported on Linux")
+}
+
+// Zeroes memory allocated by allocLockedMemory.
+func freeLockedMemory(buf []byte) {
+	clear(buf)
+}
@@ -1,21 +1,54 @@
 package mbpqs
 
+import (
+	"encoding/binary"
+	"fmt"
+)
+
 // Params includes the MBPQS parameters.
 type Params struct {
-	n     uint32 // the security parameter, length of message digest and three nodes in bytes.
-	w     uint16 // the Winternitz parameter, used in WOTS-T.
-	rootH uint32 // the height of the three (# levels -1).
-	chanH uint32 // the inital chain tree height.
-	c     uint16 // cache skip
-	gf    uint32 // growth factor, optional parameter default = 0.
+	n     uint32       // the security parameter, length of message digest and three nodes in bytes.
+	w     uint16       // the Winternitz parameter, used in WOTS-T.
+	rootH uint32       // the height of the three (# levels -1).
+	chanH uint32       // the inital chain tree height.
+	c     uint16       // cache skip
+	gf    uint32       // growth factor, optional parameter default = 0.
+	hash  HashFunction // the hash function F, H, H_msg and PRF are instantiated with.
 }
 
+// HashFunction selects the hash function MBPQS is instantiated with.
+type HashFunction uint8
+
+const (
+	// SHA2 uses SHA-256 for n=32, SHA-512 for n=64 and SHA-256/192 for n=24.
+	SHA2 HashFunction = iota
+	// SHAKE256 uses SHAKE256 with n-byte output.
+	SHAKE256
+)
+
+// OID used for parameters which are not listed in paramSets.
+// Such parameters are encoded explicitly after the OID.
+const customOid = 0xffffffff
+
+// Size of explicitly encoded parameters: n, w, rootH, chanH, c, gf and hash.
+const paramsBytes = 21
+
+// The parameter sets with a registered OID, which is their index.
 var paramSets = []*Params{
 	&Params{n: 32, rootH: 10, w: 16, c: 0, chanH: 2},
 	&Params{n: 32, rootH: 16, w: 16, c: 0, chanH: 2},
 	&Params{n: 32, rootH: 20, w: 16, c: 0, chanH: 2},
 	&Params{n: 64, rootH: 10, w: 16, c: 0, chanH: 2},
 	&Params{n: 64, rootH: 16, w: 16, c: 0, chanH: 2},
+	&Params{n: 24, rootH: 10, w: 16, c: 0, chanH: 2},
+	&Params{n: 24, rootH: 16, w: 16, c: 0, chanH: 2},
+	&Params{n: 24, rootH: 20, w: 16, c: 0, chanH: 2},
+	&Params{n: 32, rootH: 10, w: 16, c: 0, chanH: 2, hash: SHAKE256},
+	&Params{n: 32, rootH: 16, w: 16, c: 0, chanH: 2, hash: SHAKE256},
+	&Params{n: 32, rootH: 20, w: 16, c: 0, chanH: 2, hash: SHAKE256},
+	&Params{n: 24, rootH: 10, w: 16, c: 0, chanH: 2, hash: SHAKE256},
+	&Params{n: 24, rootH: 16, w: 16, c: 0, chanH: 2, hash: SHAKE256},
+	&Params{n: 24, rootH: 20, w: 16, c: 0, chanH: 2, hash: SHAKE256},
 }
 
 // NewContextFromOid returns a new context for the given Root tree.
@@ -24,6 +57,33 @@ func NewContextFromOid(oid uint32) *Context {
 	return ctx
 }
 
+// String returns a description of the parameters for humans.
+func (params *Params) String() string {
+	return fmt.Sprintf("%s n=%d w=%d rootH=%d chanH=%d gf=%d c=%d",
+		params.hash, params.n, params.w, params.rootH, params.chanH, params.gf, params.c)
+}
+
+// String returns the name of the hash function.
+func (hf HashFunction) String() string {
+	switch hf {
+	case SHA2:
+		return "SHA2"
+	case SHAKE256:
+		return "SHAKE256"
+	default:
+		return fmt.Sprintf("HashFunction(%d)", uint8(hf))
+	}
+}
+
+// Returns the length of the padding which separates F, H, H_msg and PRF.
+// It is n bytes, except for n=24 where it is 4 bytes as in NIST SP 800-208.
+func (params *Params) hashPaddingLen() uint32 {
+	if params.n == 24 {
+		return 4
+	}
+	return params.n
+}
+
 // Returns the 2log of the Winternitz parameter
 func (params *Params) wotsLogW() uint8 {
 	switch params.w {
@@ -66,3 +126,38 @@ func (params *Params) wotsLen() uint32 {
 func (params *Params) wotsSignatureSize() uint32 {
 	return params.wotsLen() * params.n
 }
+
+// Returns the OID of the parameters: their index in paramSets, or
+// customOid if they are not listed there.
+func (params *Params) oid() uint32 {
+	for i, p := range paramSets {
+		if *p == *params {
+			return uint32(i)
+		}
+	}
+	return customOid
+}
+
+// Write the parameters explicitly into the paramsBytes-byte buffer buf.
+func (params *Params) writeInto(buf []byte) {
+	binary.BigEndian.PutUint32(buf[0:4], params.n)
+	binary.BigEndian.PutUint16(buf[4:6], params.w)
+	binary.BigEndian.PutUint32(buf[6:10], params.rootH)
+	binary.BigEndian.PutUint32(buf[10:14], params.chanH)
+	binary.BigEndian.PutUint16(buf[14:16], params.c)
+	binary.BigEndian.PutUint32(buf[16:20], params.gf)
+	buf[20] = byte(params.hash)
+}
+
+// Read explicitly encoded parameters from the paramsBytes-byte buffer buf.
+func paramsFromBytes(buf []byte) *Params {
+	return &Params{
+		n:     binary.BigEndian.Uint32(buf[0:4]),
+		w:     binary.BigEndian.Uint16(buf[4:6]),
+		rootH: binary.BigEndian.Uint32(buf[6:10]),
+		chanH: binary.BigEndian.Uint32(buf[10:14]),
+		c:     binary.BigEndian.Uint16(buf[14:16]),
+		gf:    binary.BigEndian.Uint32(buf[16:20]),
+		hash:  HashFunction(buf[20]),
+	}
+}
@@ -0,0 +1,238 @@
+package mbpqs
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+/* A Proof shows that a single MsgSignature belongs to a channel of a
+ * PublicKey, without replaying the signatures before it. It holds the
+ * RootSignature of the channel, the GrowSignatures of the chain trees before
+ * the one of the MsgSignature, and for every chain tree the leafs of the keys
+ * which are needed to hash up to its root:
+ *
+ *  - for a chain tree which is grown, the leafs of all keys but the last one,
+ *    which connect the leaf of the GrowSignature N(0,0) to the root;
+ *  - for the chain tree of the MsgSignature, the leafs of the keys before
+ *    it, which connect the node N(H-1-chainSeqNo,0) to the root.
+ *
+ * The root of the first chain tree is signed by the RootSignature, and the
+ * root of every next chain tree by the GrowSignature of the one before.
+ *
+ * The RootSignature carries the root tree leaf it is made with, but not the
+ * channel. Channels take the leaves in order, so the leaf of a channel is its
+ * index, unless the key skipped leaves after a crash, see SetLookahead, in
+ * which case it is higher. A RootSignature with a leaf below the channel index
+ * thus never belongs to the channel. Neither does one with another leaf when
+ * the first chain tree does not hash up to its root. Both are rejected with
+ * ErrWrongChannel, see checkRootLeaf and firstTreeError.
+ */
+
+// Proof is a self-contained proof for a single MsgSignature in a channel.
+type Proof struct {
+	ctx    *Context
+	rtSig  *RootSignature   // Signs the root of the first chain tree.
+	grows  []*GrowSignature // GrowSignatures of the chain trees before the one of msgSig.
+	leaves [][]byte         // Per chain tree, the concatenated leafs needed to hash up to its root.
+	msgSig *MsgSignature    // The proven signature.
+}
+
+// RootSignature returns the RootSignature of the channel in the Proof.
+func (p *Proof) RootSignature() *RootSignature {
+	return p.rtSig
+}
+
+// MsgSignature returns the MsgSignature proven by the Proof.
+func (p *Proof) MsgSignature() *MsgSignature {
+	return p.msgSig
+}
+
+// String returns a description of the Proof for humans.
+func (p *Proof) String() string {
+	return fmt.Sprintf("Proof{channel: %d, layer: %d, chainSeqNo: %d, seqNo: %d}",
+		p.msgSig.chIdx, p.msgSig.layer, p.msgSig.chainSeqNo, p.msgSig.seqNo)
+}
+
+// Returns the amount of leafs a Proof holds for chain tree chLayer, if the
+// MsgSignature is in chain tree layer with the given chainSeqNo.
+func (ctx *Context) proofLeaves(chLayer, layer, chainSeqNo uint32) uint32 {
+	if chLayer == layer {
+		return chainSeqNo
+	}
+	return ctx.chainTreeHeight(chLayer) - 1
+}
+
+// Checks whether rtSig can be the RootSignature of channel chIdx.
+func checkRootLeaf(rtSig *RootSignature, chIdx uint32) error {
+	if uint32(rtSig.seqNo) < chIdx {
+		return verifyError(ErrWrongChannel, "RootSignature of root tree leaf %d does not sign channel %d", rtSig.seqNo, chIdx)
+	}
+	return nil
+}
+
+// Returns the error for the first chain tree of channel chIdx, which does not
+// hash up to the root signed by rtSig: ErrWrongChannel if the leaf of rtSig is
+// not the channel index, and err otherwise.
+func firstTreeError(rtSig *RootSignature, chIdx uint32, err error) error {
+	if uint32(rtSig.seqNo) != chIdx && errors.Is(err, ErrInvalidSignature) {
+		return verifyError(ErrWrongChannel, "RootSignature of root tree leaf %d does not sign channel %d", rtSig.seqNo, chIdx)
+	}
+	return err
+}
+
+// VerifyProof returns true if the Proof is valid for the message msg.
+func (pk *PublicKey) VerifyProof(p *Proof, msg []byte) (bool, error) {
+	return pk.VerifyProofReader(p, bytes.NewReader(msg))
+}
+
+// VerifyProofReader returns true if the Proof is valid for the message read
+// from msg. The message is streamed into the message hash.
+func (pk *PublicKey) VerifyProofReader(p *Proof, msg io.Reader) (bool, error) {
+	if p == nil || p.ctx == nil {
+		return false, verifyError(ErrMalformedSignature, "no proof to verify")
+	}
+	if *p.ctx.params != *pk.ctx.params {
+		return false, verifyError(ErrParamsMismatch, "proof is made with %s instead of %s", p.ctx.params, pk.ctx.params)
+	}
+	if err := pk.checkSignature(p.msgSig); err != nil {
+		return false, err
+	}
+	for _, gs := range p.grows {
+		if err := pk.checkSignature(gs); err != nil {
+			return false, err
+		}
+	}
+	ms := p.msgSig
+	if ms.layer != uint32(len(p.grows))+1 || len(p.leaves) != len(p.grows)+1 {
+		return false, verifyError(ErrMalformedSignature, "proof for chain tree %d has %d GrowSignatures", ms.layer, len(p.grows))
+	}
+	if ms.chainSeqNo >= pk.ctx.chainTreeHeight(ms.layer)-1 {
+		return false, verifyError(ErrOutOfOrder, "MsgSignature has chainSeqNo %d, which is reserved for a GrowSignature", ms.chainSeqNo)
+	}
+	n := pk.ctx.params.n
+	for i := range p.leaves {
+		l := uint32(i) + 1
+		if uint32(len(p.leaves[i])) != pk.ctx.proofLeaves(l, ms.layer, ms.chainSeqNo)*n {
+			return false, verifyError(ErrMalformedSignature, "proof has %d bytes of leafs for chain tree %d", len(p.leaves[i]), l)
+		}
+	}
+
+	if err := checkRootLeaf(p.rtSig, ms.chIdx); err != nil {
+		return false, err
+	}
+	accept, err := pk.VerifyChannel(p.rtSig)
+	if err != nil || !accept {
+		return accept, err
+	}
+	pad := pk.ctx.newScratchPad()
+	authRoot := p.rtSig.GetSignedRoot()
+	for i, gs := range p.grows {
+		l := uint32(i) + 1
+		if gs.chIdx != ms.chIdx {
+			return false, verifyError(ErrWrongChannel, "GrowSignature %d of the proof is for channel %d instead of %d", i, gs.chIdx, ms.chIdx)
+		}
+		if gs.layer != l || gs.chainSeqNo != pk.ctx.chainTreeHeight(l)-1 {
+			return false, verifyError(ErrWrongLayer, "GrowSignature %d of the proof is not the one of chain tree %d", i, l)
+		}
+		leaf := pk.growSigLeaf(pad, gs)
+		root := pk.hashChainTreeUp(pad, ms.chIdx, l, 0, leaf, p.leaves[i])
+		if subtle.ConstantTimeCompare(root, authRoot) != 1 {
+			err := verifyError(ErrInvalidSignature, "%s does not hash up to the root of chain tree %d", gs, l)
+			if l == 1 {
+				err = firstTreeError(p.rtSig, ms.chIdx, err)
+			}
+			return false, err
+		}
+		authRoot = gs.NextAuthNode()
+	}
+
+	_, node, err := pk.msgSigNode(pad, ms, msg)
+	if err != nil {
+		return false, err
+	}
+	height := pk.ctx.chainTreeHeight(ms.layer) - 1 - ms.chainSeqNo
+	root := pk.hashChainTreeUp(pad, ms.chIdx, ms.layer, height, node, p.leaves[ms.layer-1])
+	if subtle.ConstantTimeCompare(root, authRoot) != 1 {
+		err := verifyError(ErrInvalidSignature, "%s does not hash up to the root of chain tree %d", ms, ms.layer)
+		if ms.layer == 1 {
+			err = firstTreeError(p.rtSig, ms.chIdx, err)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ProofBuilder follows a channel like a ChannelVerifier, and keeps what is
+// needed to create a Proof for every MsgSignature it accepted.
+type ProofBuilder struct {
+	cv     *ChannelVerifier
+	rtSig  *RootSignature
+	grows  []*GrowSignature
+	msgs   []*MsgSignature // Accepted MsgSignatures, indexed by seqNo.
+	leaves [][]byte        // Per chain tree, the leafs of the accepted MsgSignatures.
+	mux    sync.Mutex      // Used when mutual exclusion for the builder is required.
+}
+
+// NewProofBuilder verifies the RootSignature of channel chIdx, and returns a
+// ProofBuilder which accepts the subsequent signatures in the channel.
+func (pk *PublicKey) NewProofBuilder(chIdx uint32, rtSig *RootSignature) (*ProofBuilder, error) {
+	cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+	if err != nil {
+		return nil, err
+	}
+	pb := &ProofBuilder{
+		cv:     cv,
+		rtSig:  rtSig,
+		leaves: [][]byte{nil},
+	}
+	cv.onAccept = pb.record
+	return pb, nil
+}
+
+// Add verifies the next signature in the channel, which is either a
+// MsgSignature over msg or a GrowSignature, and keeps it if it is accepted.
+func (pb *ProofBuilder) Add(sig Signature, msg []byte) (bool, error) {
+	pb.mux.Lock()
+	defer pb.mux.Unlock()
+	return pb.cv.Verify(sig, msg)
+}
+
+// Keeps an accepted signature. The lock of the builder should be held.
+func (pb *ProofBuilder) record(sig Signature, leaf []byte) {
+	switch t := sig.(type) {
+	case *MsgSignature:
+		pb.msgs = append(pb.msgs, t)
+		pb.leaves[t.layer-1] = append(pb.leaves[t.layer-1], leaf...)
+	case *GrowSignature:
+		pb.grows = append(pb.grows, t)
+		pb.leaves = append(pb.leaves, nil)
+	}
+}
+
+// Proof returns the Proof for the accepted MsgSignature with seqNo seqNo.
+func (pb *ProofBuilder) Proof(seqNo SignatureSeqNo) (*Proof, error) {
+	pb.mux.Lock()
+	defer pb.mux.Unlock()
+	if int(seqNo) >= len(pb.msgs) {
+		return nil, fmt.Errorf("no MsgSignature with seqNo %d is accepted", seqNo)
+	}
+	ms := pb.msgs[seqNo]
+	ctx := pb.cv.pk.ctx
+	p := &Proof{
+		ctx:    ctx,
+		rtSig:  pb.rtSig,
+		grows:  append([]*GrowSignature{}, pb.grows[:ms.layer-1]...),
+		leaves: make([][]byte, ms.layer),
+		msgSig: ms,
+	}
+	for i := range p.leaves {
+		l := uint32(i) + 1
+		size := ctx.proofLeaves(l, ms.layer, ms.chainSeqNo) * ctx.params.n
+		p.leaves[i] = append([]byte{}, pb.leaves[i][:size]...)
+	}
+	return p, nil
+}
@@ -0,0 +1,116 @@
+package mbpqs
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestProof(t *testing.T) {
+	var chanH uint32 = 3
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, chanH, 1, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	_, firstRtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	pb, err := pk.NewProofBuilder(chIdx, rtSig)
+	if err != nil {
+		t.Fatalf("Creating proof builder failed with error %s", err)
+	}
+
+	// Sign three chain trees, and the first message of the fourth one.
+	var msgs [][]byte
+	for layer := uint32(1); layer <= 4; layer++ {
+		for i := uint32(0); i < chanH+layer-2; i++ {
+			msg := []byte(fmt.Sprintf("Block %d", len(msgs)))
+			sig, err := sk.SignMsg(chIdx, msg)
+			if err != nil {
+				t.Fatalf("Signing message failed with error %s", err)
+			}
+			if accept, err := pb.Add(sig, msg); !accept || err != nil {
+				t.Fatalf("Correct MsgSignature %d not accepted: %v", sig.seqNo, err)
+			}
+			msgs = append(msgs, msg)
+			if layer == 4 {
+				break
+			}
+		}
+		if layer == 4 {
+			break
+		}
+		growSig, err := sk.GrowChannel(chIdx)
+		if err != nil {
+			t.Fatalf("Growing channel failed with error %s", err)
+		}
+		if accept, err := pb.Add(growSig, nil); !accept || err != nil {
+			t.Fatalf("Correct GrowSignature for layer %d not accepted: %v", layer, err)
+		}
+	}
+
+	for seqNo, msg := range msgs {
+		p, err := pb.Proof(SignatureSeqNo(seqNo))
+		if err != nil {
+			t.Fatalf("Creating proof failed with error %s", err)
+		}
+		buf, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Marshalling proof failed with error %s", err)
+		}
+		var p2 Proof
+		if err = p2.UnmarshalBinary(buf); err != nil {
+			t.Fatalf("Unmarshalling proof failed with error %s", err)
+		}
+		if accept, err := pk.VerifyProof(&p2, msg); !accept || err != nil {
+			t.Fatalf("Correct proof for seqNo %d not accepted: %v", seqNo, err)
+		}
+		if accept, _ := pk.VerifyProof(&p2, []byte("Another block")); accept {
+			t.Fatalf("Proof for seqNo %d accepted for another message", seqNo)
+		}
+		// Every byte of the leaves is needed to reach the root.
+		for i := range p2.leaves {
+			if len(p2.leaves[i]) == 0 {
+				continue
+			}
+			p2.leaves[i][0] ^= 1
+			if accept, _ := pk.VerifyProof(&p2, msg); accept {
+				t.Fatalf("Proof for seqNo %d with altered leafs of chain tree %d accepted", seqNo, i+1)
+			}
+			p2.leaves[i][0] ^= 1
+		}
+	}
+	if _, err = pb.Proof(SignatureSeqNo(len(msgs))); err == nil {
+		t.Fatal("Proof for a MsgSignature which is not added did not give an error")
+	}
+
+	// A proof without the GrowSignatures before the MsgSignature is rejected.
+	p, _ := pb.Proof(SignatureSeqNo(len(msgs) - 1))
+	p.grows = p.grows[1:]
+	if accept, err := pk.VerifyProof(p, msgs[len(msgs)-1]); accept || err == nil {
+		t.Fatal("Proof with missing GrowSignatures accepted")
+	}
+	buf, _ := p.MarshalBinary()
+	if err = new(Proof).UnmarshalBinary(buf[:len(buf)-1]); err == nil {
+		t.Fatal("Unmarshalling a truncated proof did not give an error")
+	}
+
+	// A proof with the RootSignature of another channel, before or after
+	// the one of the MsgSignature, is made in the wrong channel.
+	_, lastRtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	for _, other := range []*RootSignature{firstRtSig, lastRtSig} {
+		for _, seqNo := range []SignatureSeqNo{0, SignatureSeqNo(len(msgs) - 1)} {
+			p, _ := pb.Proof(seqNo)
+			p.rtSig = other
+			accept, err := pk.VerifyProof(p, msgs[seqNo])
+			expectReject(t, "Proof with the RootSignature of another channel", accept, err, ErrWrongChannel)
+		}
+	}
+}
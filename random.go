@@ -2,12 +2,16 @@ package mbpqs
 
 import (
 	"crypto/rand"
+	"io"
 )
 
+// The source of the random seeds, which tests replace by fixed bytes.
+var randReader io.Reader = rand.Reader
+
 // Create a n-byte slice of random bytes.
 func randomBytes(n uint32) ([]byte, error) {
 	r := make([]byte, n)
-	_, err := rand.Read(r)
+	_, err := io.ReadFull(randReader, r)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,249 @@
+package mbpqs
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sort"
+)
+
+/* The WOTS+ chains of a key are independent, so they can be advanced
+ * together. With SHA-256 and n = 32, a step of a chain is F with a key and a
+ * bitmask from the PRF, which takes four compressions of a single block:
+ *
+ *   key  = compress(pubSeedState, ADRS(keyAndMask = 0) || padding)
+ *   mask = compress(pubSeedState, ADRS(keyAndMask = 1) || padding)
+ *   out  = compress(compress(IV, toByte(0,32) || key), (in XOR mask) || padding)
+ *
+ * where pubSeedState is the state after the block toByte(3,32) || pubSeed.
+ * The multi-lane backend computes these compressions for sha256Lanes chains
+ * at once. The words of the lanes are interleaved, such that a word of all
+ * lanes fits in one AVX2 register, see sha256x8_amd64.s. The chain values
+ * are kept as words between the steps, and the padding is constant, so no
+ * hash.Hash is involved at all. Without AVX2, and for the other hash
+ * functions and values of n, every step is computed with fInto.
+ */
+
+// The amount of chains which are advanced together.
+const sha256Lanes = 8
+
+// The SHA-256 states of the lanes: word i of lane l is at [i][l].
+type sha256LaneState [8][sha256Lanes]uint32
+
+// A message block for each of the lanes: word i of lane l is at [i][l].
+type sha256LaneBlock [16][sha256Lanes]uint32
+
+// The initial SHA-256 state.
+var sha256IV = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
+	0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19,
+}
+
+// The SHA-256 round constants.
+var sha256K = [64]uint32{
+	0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1, 0x923f82a4, 0xab1c5ed5,
+	0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3, 0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174,
+	0xe49b69c1, 0xefbe4786, 0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+	0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147, 0x06ca6351, 0x14292967,
+	0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13, 0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85,
+	0xa2bfe8a1, 0xa81a664b, 0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+	0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a, 0x5b9cca4f, 0x682e6ff3,
+	0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208, 0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+}
+
+// The SHA-256 compression function of FIPS 180-4. The first 16 words of w
+// hold the message block, the others are used for the message schedule.
+func sha256Block(s *[8]uint32, w *[64]uint32) {
+	for t := 16; t < 64; t++ {
+		v1, v2 := w[t-2], w[t-15]
+		s1 := bits.RotateLeft32(v1, -17) ^ bits.RotateLeft32(v1, -19) ^ (v1 >> 10)
+		s0 := bits.RotateLeft32(v2, -7) ^ bits.RotateLeft32(v2, -18) ^ (v2 >> 3)
+		w[t] = s1 + w[t-7] + s0 + w[t-16]
+	}
+	a, b, c, d, e, f, g, h := s[0], s[1], s[2], s[3], s[4], s[5], s[6], s[7]
+	for t := 0; t < 64; t++ {
+		t1 := h + (bits.RotateLeft32(e, -6) ^ bits.RotateLeft32(e, -11) ^ bits.RotateLeft32(e, -25)) +
+			((e & f) ^ (^e & g)) + sha256K[t] + w[t]
+		t2 := (bits.RotateLeft32(a, -2) ^ bits.RotateLeft32(a, -13) ^ bits.RotateLeft32(a, -22)) +
+			((a & b) ^ (a & c) ^ (b & c))
+		h, g, f, e, d, c, b, a = g, f, e, d+t1, c, b, a, t1+t2
+	}
+	s[0] += a
+	s[1] += b
+	s[2] += c
+	s[3] += d
+	s[4] += e
+	s[5] += f
+	s[6] += g
+	s[7] += h
+}
+
+// Compresses the block of every lane into its state, one lane at a time.
+func block8Generic(st *sha256LaneState, blk *sha256LaneBlock) {
+	var s [8]uint32
+	var w [64]uint32
+	for l := 0; l < sha256Lanes; l++ {
+		for i := range s {
+			s[i] = st[i][l]
+		}
+		for i := 0; i < 16; i++ {
+			w[i] = blk[i][l]
+		}
+		sha256Block(&s, &w)
+		for i := range s {
+			st[i][l] = s[i]
+		}
+	}
+	clear(s[:])
+	clear(w[:])
+}
+
+// Returns the SHA-256 state after the block toByte(3,32) || pubSeed, which
+// is the prefix of the PRF keyed with pubSeed, or nil if the chains of the
+// Context are not advanced by the multi-lane backend.
+func (ctx *Context) pubSeedLaneState(pubSeed []byte) *[8]uint32 {
+	if !sha256LanesFast || ctx.params.hash != SHA2 || ctx.params.n != 32 {
+		return nil
+	}
+	var w [64]uint32
+	w[7] = hashPaddingPRF
+	for i := 0; i < 8; i++ {
+		w[8+i] = binary.BigEndian.Uint32(pubSeed[4*i:])
+	}
+	s := sha256IV
+	sha256Block(&s, &w)
+	return &s
+}
+
+// Sets the message blocks of the lanes to addr with the given keyAndMask,
+// followed by the padding of a 96-byte message. The chain and hash of the
+// address are set per lane by setChains.
+func (blk *sha256LaneBlock) setAddress(addr address, keyAndMask uint32) {
+	addr.setKeyAndMask(keyAndMask)
+	for i := 0; i < 8; i++ {
+		for l := 0; l < sha256Lanes; l++ {
+			blk[i][l] = addr[i]
+		}
+	}
+	blk.setPadding()
+}
+
+// Sets the chain and hash of the address in the message block of every lane.
+func (blk *sha256LaneBlock) setChains(chain *[sha256Lanes]int, hash *[sha256Lanes]uint16) {
+	for l := 0; l < sha256Lanes; l++ {
+		blk[5][l] = uint32(chain[l])
+		blk[6][l] = uint32(hash[l])
+	}
+}
+
+// Sets the second half of the message blocks of the lanes to the padding of
+// a 96-byte message, which ends in the first half of the block.
+func (blk *sha256LaneBlock) setPadding() {
+	for l := 0; l < sha256Lanes; l++ {
+		blk[8][l] = 0x80000000
+		blk[15][l] = 96 * 8
+	}
+}
+
+// Advances the WOTS+ chains like wotsGenChainsInto, sha256Lanes chains at a
+// time. pubSeedState is the state returned by pubSeedLaneState.
+func (ctx *Context) wotsGenChainsLanes(in []byte, starts, ends []uint16,
+	pubSeedState *[8]uint32, addr address, out []byte) {
+	var pubSt, iv, st, vals sha256LaneState
+	var keyBlk, maskBlk sha256LaneBlock // The address blocks of the PRF.
+	var first, second sha256LaneBlock   // The blocks of F.
+	var chain [sha256Lanes]int          // The chain in the lane, or -1 if it is idle.
+	var hash [sha256Lanes]uint16        // The position of the chain in the lane.
+	pubSt.broadcast(pubSeedState)
+	iv.broadcast(&sha256IV)
+	keyBlk.setAddress(addr, 0)
+	maskBlk.setAddress(addr, 1)
+	second.setPadding()
+	for l := range chain {
+		chain[l] = -1
+	}
+
+	// The lanes take the longest chains first, such that they all run out of
+	// chains at about the same time.
+	order := make([]int, 0, len(starts))
+	for c := range starts {
+		if starts[c] < ends[c] {
+			order = append(order, c)
+		} else {
+			copy(out[32*c:32*(c+1)], in[32*c:32*(c+1)])
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return ends[order[i]]-starts[order[i]] > ends[order[j]]-starts[order[j]]
+	})
+
+	next := 0
+	for {
+		// Give the idle lanes the next chains.
+		active := 0
+		for l := 0; l < sha256Lanes; l++ {
+			if chain[l] < 0 && next < len(order) {
+				c := order[next]
+				next++
+				chain[l], hash[l] = c, starts[c]
+				for i := 0; i < 8; i++ {
+					vals[i][l] = binary.BigEndian.Uint32(in[32*c+4*i:])
+				}
+			}
+			if chain[l] >= 0 {
+				active++
+			}
+		}
+		if active == 0 {
+			// The chain values are secret, so they are not left behind
+			// on the stack.
+			clear(vals[:])
+			clear(st[:])
+			clear(first[:])
+			clear(second[:])
+			return
+		}
+
+		// The key, from the PRF keyed with pubSeed, is the second half of
+		// the first block of F.
+		keyBlk.setChains(&chain, &hash)
+		st = pubSt
+		block8(&st, &keyBlk)
+		copy(first[8:], st[:])
+
+		// The bitmask is xored into the value in the second block of F.
+		maskBlk.setChains(&chain, &hash)
+		st = pubSt
+		block8(&st, &maskBlk)
+		for i := 0; i < 8; i++ {
+			for l := 0; l < sha256Lanes; l++ {
+				second[i][l] = vals[i][l] ^ st[i][l]
+			}
+		}
+		vals = iv
+		block8(&vals, &first)
+		block8(&vals, &second)
+
+		// Write out the chains which reached their end.
+		for l := 0; l < sha256Lanes; l++ {
+			if chain[l] < 0 {
+				continue
+			}
+			hash[l]++
+			if c := chain[l]; hash[l] == ends[c] {
+				for i := 0; i < 8; i++ {
+					binary.BigEndian.PutUint32(out[32*c+4*i:], vals[i][l])
+				}
+				chain[l] = -1
+			}
+		}
+	}
+}
+
+// Sets the state of every lane to s.
+func (st *sha256LaneState) broadcast(s *[8]uint32) {
+	for i := range st {
+		for l := 0; l < sha256Lanes; l++ {
+			st[i][l] = s[i]
+		}
+	}
+}
@@ -0,0 +1,23 @@
+//go:build amd64 && !purego
+
+package mbpqs
+
+import "golang.org/x/sys/cpu"
+
+// Whether the chains are advanced by the multi-lane backend, which only pays
+// off with the AVX2 implementation of block8.
+var sha256LanesFast = cpu.X86.HasAVX2
+
+// Compresses the block of every lane into its state, see sha256x8_amd64.s.
+//
+//go:noescape
+func block8AVX2(state *sha256LaneState, block *sha256LaneBlock)
+
+// Compresses the block of every lane into its state.
+func block8(st *sha256LaneState, blk *sha256LaneBlock) {
+	if cpu.X86.HasAVX2 {
+		block8AVX2(st, blk)
+		return
+	}
+	block8Generic(st, blk)
+}
@@ -0,0 +1,310 @@
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// The SHA-256 round constants.
+DATA sha256K<>+0(SB)/4, $0x428a2f98
+DATA sha256K<>+4(SB)/4, $0x71374491
+DATA sha256K<>+8(SB)/4, $0xb5c0fbcf
+DATA sha256K<>+12(SB)/4, $0xe9b5dba5
+DATA sha256K<>+16(SB)/4, $0x3956c25b
+DATA sha256K<>+20(SB)/4, $0x59f111f1
+DATA sha256K<>+24(SB)/4, $0x923f82a4
+DATA sha256K<>+28(SB)/4, $0xab1c5ed5
+DATA sha256K<>+32(SB)/4, $0xd807aa98
+DATA sha256K<>+36(SB)/4, $0x12835b01
+DATA sha256K<>+40(SB)/4, $0x243185be
+DATA sha256K<>+44(SB)/4, $0x550c7dc3
+DATA sha256K<>+48(SB)/4, $0x72be5d74
+DATA sha256K<>+52(SB)/4, $0x80deb1fe
+DATA sha256K<>+56(SB)/4, $0x9bdc06a7
+DATA sha256K<>+60(SB)/4, $0xc19bf174
+DATA sha256K<>+64(SB)/4, $0xe49b69c1
+DATA sha256K<>+68(SB)/4, $0xefbe4786
+DATA sha256K<>+72(SB)/4, $0x0fc19dc6
+DATA sha256K<>+76(SB)/4, $0x240ca1cc
+DATA sha256K<>+80(SB)/4, $0x2de92c6f
+DATA sha256K<>+84(SB)/4, $0x4a7484aa
+DATA sha256K<>+88(SB)/4, $0x5cb0a9dc
+DATA sha256K<>+92(SB)/4, $0x76f988da
+DATA sha256K<>+96(SB)/4, $0x983e5152
+DATA sha256K<>+100(SB)/4, $0xa831c66d
+DATA sha256K<>+104(SB)/4, $0xb00327c8
+DATA sha256K<>+108(SB)/4, $0xbf597fc7
+DATA sha256K<>+112(SB)/4, $0xc6e00bf3
+DATA sha256K<>+116(SB)/4, $0xd5a79147
+DATA sha256K<>+120(SB)/4, $0x06ca6351
+DATA sha256K<>+124(SB)/4, $0x14292967
+DATA sha256K<>+128(SB)/4, $0x27b70a85
+DATA sha256K<>+132(SB)/4, $0x2e1b2138
+DATA sha256K<>+136(SB)/4, $0x4d2c6dfc
+DATA sha256K<>+140(SB)/4, $0x53380d13
+DATA sha256K<>+144(SB)/4, $0x650a7354
+DATA sha256K<>+148(SB)/4, $0x766a0abb
+DATA sha256K<>+152(SB)/4, $0x81c2c92e
+DATA sha256K<>+156(SB)/4, $0x92722c85
+DATA sha256K<>+160(SB)/4, $0xa2bfe8a1
+DATA sha256K<>+164(SB)/4, $0xa81a664b
+DATA sha256K<>+168(SB)/4, $0xc24b8b70
+DATA sha256K<>+172(SB)/4, $0xc76c51a3
+DATA sha256K<>+176(SB)/4, $0xd192e819
+DATA sha256K<>+180(SB)/4, $0xd6990624
+DATA sha256K<>+184(SB)/4, $0xf40e3585
+DATA sha256K<>+188(SB)/4, $0x106aa070
+DATA sha256K<>+192(SB)/4, $0x19a4c116
+DATA sha256K<>+196(SB)/4, $0x1e376c08
+DATA sha256K<>+200(SB)/4, $0x2748774c
+DATA sha256K<>+204(SB)/4, $0x34b0bcb5
+DATA sha256K<>+208(SB)/4, $0x391c0cb3
+DATA sha256K<>+212(SB)/4, $0x4ed8aa4a
+DATA sha256K<>+216(SB)/4, $0x5b9cca4f
+DATA sha256K<>+220(SB)/4, $0x682e6ff3
+DATA sha256K<>+224(SB)/4, $0x748f82ee
+DATA sha256K<>+228(SB)/4, $0x78a5636f
+DATA sha256K<>+232(SB)/4, $0x84c87814
+DATA sha256K<>+236(SB)/4, $0x8cc70208
+DATA sha256K<>+240(SB)/4, $0x90befffa
+DATA sha256K<>+244(SB)/4, $0xa4506ceb
+DATA sha256K<>+248(SB)/4, $0xbef9a3f7
+DATA sha256K<>+252(SB)/4, $0xc67178f2
+GLOBL sha256K<>(SB), RODATA|NOPTR, $256
+
+/* Every Y register holds a word of the 8 lanes, see sha256x8.go. Y0-Y7 hold
+ * the working variables a-h, Y8-Y11 are scratch. The message schedule W of
+ * the 8 lanes is kept on the stack, word t at offset 32*t.
+ */
+
+// dst ^= x rotated right by r bits, with tmp as scratch.
+#define XOR_ROTR(r, x, tmp, dst) \
+	VPSRLD $r, x, tmp; \
+	VPXOR  tmp, dst, dst; \
+	VPSLLD $(32-r), x, tmp; \
+	VPXOR  tmp, dst, dst
+
+// Computes W[t] at offset wt from the words at offsets w2, w7, w15 and w16:
+// W[t] = sigma1(W[t-2]) + W[t-7] + sigma0(W[t-15]) + W[t-16].
+#define SCHED(w2, w7, w15, w16, wt) \
+	VMOVDQU w2(SP), Y8; \
+	VPSRLD  $10, Y8, Y10; \
+	XOR_ROTR(17, Y8, Y9, Y10); \
+	XOR_ROTR(19, Y8, Y9, Y10); \
+	VPADDD  w7(SP), Y10, Y10; \
+	VMOVDQU w15(SP), Y8; \
+	VPSRLD  $3, Y8, Y11; \
+	XOR_ROTR(7, Y8, Y9, Y11); \
+	XOR_ROTR(18, Y8, Y9, Y11); \
+	VPADDD  Y11, Y10, Y10; \
+	VPADDD  w16(SP), Y10, Y10; \
+	VMOVDQU Y10, wt(SP)
+
+// One round with the round constant at offset k and W[t] at offset wt. The
+// new a ends up in the register of h, and the new e in the one of d.
+#define ROUND(a, b, c, d, e, f, g, h, k, wt) \
+	VPSRLD       $6, e, Y10; \
+	VPSLLD       $26, e, Y9; \
+	VPXOR        Y9, Y10, Y10; \
+	XOR_ROTR(11, e, Y9, Y10); \
+	XOR_ROTR(25, e, Y9, Y10); \
+	VPADDD       Y10, h, h; \
+	VPAND        f, e, Y8; \
+	VPANDN       g, e, Y9; \
+	VPXOR        Y8, Y9, Y9; \
+	VPADDD       Y9, h, h; \
+	VPBROADCASTD sha256K<>+k(SB), Y8; \
+	VPADDD       Y8, h, h; \
+	VPADDD       wt(SP), h, h; \
+	VPADDD       h, d, d; \
+	VPSRLD       $2, a, Y10; \
+	VPSLLD       $30, a, Y9; \
+	VPXOR        Y9, Y10, Y10; \
+	XOR_ROTR(13, a, Y9, Y10); \
+	XOR_ROTR(22, a, Y9, Y10); \
+	VPADDD       Y10, h, h; \
+	VPXOR        a, b, Y8; \
+	VPAND        c, Y8, Y8; \
+	VPAND        a, b, Y9; \
+	VPXOR        Y8, Y9, Y9; \
+	VPADDD       Y9, h, h
+
+// func block8AVX2(state *sha256LaneState, block *sha256LaneBlock)
+TEXT ·block8AVX2(SB), 0, $2048-16
+	MOVQ state+0(FP), DI
+	MOVQ block+8(FP), SI
+
+	// The first 16 words of the schedule are the message block.
+	VMOVDQU 0(SI), Y0
+	VMOVDQU Y0, 0(SP)
+	VMOVDQU 32(SI), Y0
+	VMOVDQU Y0, 32(SP)
+	VMOVDQU 64(SI), Y0
+	VMOVDQU Y0, 64(SP)
+	VMOVDQU 96(SI), Y0
+	VMOVDQU Y0, 96(SP)
+	VMOVDQU 128(SI), Y0
+	VMOVDQU Y0, 128(SP)
+	VMOVDQU 160(SI), Y0
+	VMOVDQU Y0, 160(SP)
+	VMOVDQU 192(SI), Y0
+	VMOVDQU Y0, 192(SP)
+	VMOVDQU 224(SI), Y0
+	VMOVDQU Y0, 224(SP)
+	VMOVDQU 256(SI), Y0
+	VMOVDQU Y0, 256(SP)
+	VMOVDQU 288(SI), Y0
+	VMOVDQU Y0, 288(SP)
+	VMOVDQU 320(SI), Y0
+	VMOVDQU Y0, 320(SP)
+	VMOVDQU 352(SI), Y0
+	VMOVDQU Y0, 352(SP)
+	VMOVDQU 384(SI), Y0
+	VMOVDQU Y0, 384(SP)
+	VMOVDQU 416(SI), Y0
+	VMOVDQU Y0, 416(SP)
+	VMOVDQU 448(SI), Y0
+	VMOVDQU Y0, 448(SP)
+	VMOVDQU 480(SI), Y0
+	VMOVDQU Y0, 480(SP)
+
+	SCHED(448, 288, 32, 0, 512)
+	SCHED(480, 320, 64, 32, 544)
+	SCHED(512, 352, 96, 64, 576)
+	SCHED(544, 384, 128, 96, 608)
+	SCHED(576, 416, 160, 128, 640)
+	SCHED(608, 448, 192, 160, 672)
+	SCHED(640, 480, 224, 192, 704)
+	SCHED(672, 512, 256, 224, 736)
+	SCHED(704, 544, 288, 256, 768)
+	SCHED(736, 576, 320, 288, 800)
+	SCHED(768, 608, 352, 320, 832)
+	SCHED(800, 640, 384, 352, 864)
+	SCHED(832, 672, 416, 384, 896)
+	SCHED(864, 704, 448, 416, 928)
+	SCHED(896, 736, 480, 448, 960)
+	SCHED(928, 768, 512, 480, 992)
+	SCHED(960, 800, 544, 512, 1024)
+	SCHED(992, 832, 576, 544, 1056)
+	SCHED(1024, 864, 608, 576, 1088)
+	SCHED(1056, 896, 640, 608, 1120)
+	SCHED(1088, 928, 672, 640, 1152)
+	SCHED(1120, 960, 704, 672, 1184)
+	SCHED(1152, 992, 736, 704, 1216)
+	SCHED(1184, 1024, 768, 736, 1248)
+	SCHED(1216, 1056, 800, 768, 1280)
+	SCHED(1248, 1088, 832, 800, 1312)
+	SCHED(1280, 1120, 864, 832, 1344)
+	SCHED(1312, 1152, 896, 864, 1376)
+	SCHED(1344, 1184, 928, 896, 1408)
+	SCHED(1376, 1216, 960, 928, 1440)
+	SCHED(1408, 1248, 992, 960, 1472)
+	SCHED(1440, 1280, 1024, 992, 1504)
+	SCHED(1472, 1312, 1056, 1024, 1536)
+	SCHED(1504, 1344, 1088, 1056, 1568)
+	SCHED(1536, 1376, 1120, 1088, 1600)
+	SCHED(1568, 1408, 1152, 1120, 1632)
+	SCHED(1600, 1440, 1184, 1152, 1664)
+	SCHED(1632, 1472, 1216, 1184, 1696)
+	SCHED(1664, 1504, 1248, 1216, 1728)
+	SCHED(1696, 1536, 1280, 1248, 1760)
+	SCHED(1728, 1568, 1312, 1280, 1792)
+	SCHED(1760, 1600, 1344, 1312, 1824)
+	SCHED(1792, 1632, 1376, 1344, 1856)
+	SCHED(1824, 1664, 1408, 1376, 1888)
+	SCHED(1856, 1696, 1440, 1408, 1920)
+	SCHED(1888, 1728, 1472, 1440, 1952)
+	SCHED(1920, 1760, 1504, 1472, 1984)
+	SCHED(1952, 1792, 1536, 1504, 2016)
+
+	VMOVDQU 0(DI), Y0
+	VMOVDQU 32(DI), Y1
+	VMOVDQU 64(DI), Y2
+	VMOVDQU 96(DI), Y3
+	VMOVDQU 128(DI), Y4
+	VMOVDQU 160(DI), Y5
+	VMOVDQU 192(DI), Y6
+	VMOVDQU 224(DI), Y7
+
+	ROUND(Y0, Y1, Y2, Y3, Y4, Y5, Y6, Y7, 0, 0)
+	ROUND(Y7, Y0, Y1, Y2, Y3, Y4, Y5, Y6, 4, 32)
+	ROUND(Y6, Y7, Y0, Y1, Y2, Y3, Y4, Y5, 8, 64)
+	ROUND(Y5, Y6, Y7, Y0, Y1, Y2, Y3, Y4, 12, 96)
+	ROUND(Y4, Y5, Y6, Y7, Y0, Y1, Y2, Y3, 16, 128)
+	ROUND(Y3, Y4, Y5, Y6, Y7, Y0, Y1, Y2, 20, 160)
+	ROUND(Y2, Y3, Y4, Y5, Y6, Y7, Y0, Y1, 24, 192)
+	ROUND(Y1, Y2, Y3, Y4, Y5, Y6, Y7, Y0, 28, 224)
+	ROUND(Y0, Y1, Y2, Y3, Y4, Y5, Y6, Y7, 32, 256)
+	ROUND(Y7, Y0, Y1, Y2, Y3, Y4, Y5, Y6, 36, 288)
+	ROUND(Y6, Y7, Y0, Y1, Y2, Y3, Y4, Y5, 40, 320)
+	ROUND(Y5, Y6, Y7, Y0, Y1, Y2, Y3, Y4, 44, 352)
+	ROUND(Y4, Y5, Y6, Y7, Y0, Y1, Y2, Y3, 48, 384)
+	ROUND(Y3, Y4, Y5, Y6, Y7, Y0, Y1, Y2, 52, 416)
+	ROUND(Y2, Y3, Y4, Y5, Y6, Y7, Y0, Y1, 56, 448)
+	ROUND(Y1, Y2, Y3, Y4, Y5, Y6, Y7, Y0, 60, 480)
+	ROUND(Y0, Y1, Y2, Y3, Y4, Y5, Y6, Y7, 64, 512)
+	ROUND(Y7, Y0, Y1, Y2, Y3, Y4, Y5, Y6, 68, 544)
+	ROUND(Y6, Y7, Y0, Y1, Y2, Y3, Y4, Y5, 72, 576)
+	ROUND(Y5, Y6, Y7, Y0, Y1, Y2, Y3, Y4, 76, 608)
+	ROUND(Y4, Y5, Y6, Y7, Y0, Y1, Y2, Y3, 80, 640)
+	ROUND(Y3, Y4, Y5, Y6, Y7, Y0, Y1, Y2, 84, 672)
+	ROUND(Y2, Y3, Y4, Y5, Y6, Y7, Y0, Y1, 88, 704)
+	ROUND(Y1, Y2, Y3, Y4, Y5, Y6, Y7, Y0, 92, 736)
+	ROUND(Y0, Y1, Y2, Y3, Y4, Y5, Y6, Y7, 96, 768)
+	ROUND(Y7, Y0, Y1, Y2, Y3, Y4, Y5, Y6, 100, 800)
+	ROUND(Y6, Y7, Y0, Y1, Y2, Y3, Y4, Y5, 104, 832)
+	ROUND(Y5, Y6, Y7, Y0, Y1, Y2, Y3, Y4, 108, 864)
+	ROUND(Y4, Y5, Y6, Y7, Y0, Y1, Y2, Y3, 112, 896)
+	ROUND(Y3, Y4, Y5, Y6, Y7, Y0, Y1, Y2, 116, 928)
+	ROUND(Y2, Y3, Y4, Y5, Y6, Y7, Y0, Y1, 120, 960)
+	ROUND(Y1, Y2, Y3, Y4, Y5, Y6, Y7, Y0, 124, 992)
+	ROUND(Y0, Y1, Y2, Y3, Y4, Y5, Y6, Y7, 128, 1024)
+	ROUND(Y7, Y0, Y1, Y2, Y3, Y4, Y5, Y6, 132, 1056)
+	ROUND(Y6, Y7, Y0, Y1, Y2, Y3, Y4, Y5, 136, 1088)
+	ROUND(Y5, Y6, Y7, Y0, Y1, Y2, Y3, Y4, 140, 1120)
+	ROUND(Y4, Y5, Y6, Y7, Y0, Y1, Y2, Y3, 144, 1152)
+	ROUND(Y3, Y4, Y5, Y6, Y7, Y0, Y1, Y2, 148, 1184)
+	ROUND(Y2, Y3, Y4, Y5, Y6, Y7, Y0, Y1, 152, 1216)
+	ROUND(Y1, Y2, Y3, Y4, Y5, Y6, Y7, Y0, 156, 1248)
+	ROUND(Y0, Y1, Y2, Y3, Y4, Y5, Y6, Y7, 160, 1280)
+	ROUND(Y7, Y0, Y1, Y2, Y3, Y4, Y5, Y6, 164, 1312)
+	ROUND(Y6, Y7, Y0, Y1, Y2, Y3, Y4, Y5, 168, 1344)
+	ROUND(Y5, Y6, Y7, Y0, Y1, Y2, Y3, Y4, 172, 1376)
+	ROUND(Y4, Y5, Y6, Y7, Y0, Y1, Y2, Y3, 176, 1408)
+	ROUND(Y3, Y4, Y5, Y6, Y7, Y0, Y1, Y2, 180, 1440)
+	ROUND(Y2, Y3, Y4, Y5, Y6, Y7, Y0, Y1, 184, 1472)
+	ROUND(Y1, Y2, Y3, Y4, Y5, Y6, Y7, Y0, 188, 1504)
+	ROUND(Y0, Y1, Y2, Y3, Y4, Y5, Y6, Y7, 192, 1536)
+	ROUND(Y7, Y0, Y1, Y2, Y3, Y4, Y5, Y6, 196, 1568)
+	ROUND(Y6, Y7, Y0, Y1, Y2, Y3, Y4, Y5, 200, 1600)
+	ROUND(Y5, Y6, Y7, Y0, Y1, Y2, Y3, Y4, 204, 1632)
+	ROUND(Y4, Y5, Y6, Y7, Y0, Y1, Y2, Y3, 208, 1664)
+	ROUND(Y3, Y4, Y5, Y6, Y7, Y0, Y1, Y2, 212, 1696)
+	ROUND(Y2, Y3, Y4, Y5, Y6, Y7, Y0, Y1, 216, 1728)
+	ROUND(Y1, Y2, Y3, Y4, Y5, Y6, Y7, Y0, 220, 1760)
+	ROUND(Y0, Y1, Y2, Y3, Y4, Y5, Y6, Y7, 224, 1792)
+	ROUND(Y7, Y0, Y1, Y2, Y3, Y4, Y5, Y6, 228, 1824)
+	ROUND(Y6, Y7, Y0, Y1, Y2, Y3, Y4, Y5, 232, 1856)
+	ROUND(Y5, Y6, Y7, Y0, Y1, Y2, Y3, Y4, 236, 1888)
+	ROUND(Y4, Y5, Y6, Y7, Y0, Y1, Y2, Y3, 240, 1920)
+	ROUND(Y3, Y4, Y5, Y6, Y7, Y0, Y1, Y2, 244, 1952)
+	ROUND(Y2, Y3, Y4, Y5, Y6, Y7, Y0, Y1, 248, 1984)
+	ROUND(Y1, Y2, Y3, Y4, Y5, Y6, Y7, Y0, 252, 2016)
+
+	// Add the compressed block to the state.
+	VPADDD  0(DI), Y0, Y0
+	VMOVDQU Y0, 0(DI)
+	VPADDD  32(DI), Y1, Y1
+	VMOVDQU Y1, 32(DI)
+	VPADDD  64(DI), Y2, Y2
+	VMOVDQU Y2, 64(DI)
+	VPADDD  96(DI), Y3, Y3
+	VMOVDQU Y3, 96(DI)
+	VPADDD  128(DI), Y4, Y4
+	VMOVDQU Y4, 128(DI)
+	VPADDD  160(DI), Y5, Y5
+	VMOVDQU Y5, 160(DI)
+	VPADDD  192(DI), Y6, Y6
+	VMOVDQU Y6, 192(DI)
+	VPADDD  224(DI), Y7, Y7
+	VMOVDQU Y7, 224(DI)
+
+	VZEROUPPER
+	RET
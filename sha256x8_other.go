@@ -0,0 +1,12 @@
+//go:build !amd64 || purego
+
+package mbpqs
+
+// Without an assembly implementation of block8, advancing the chains one
+// lane at a time is no faster than computing every step with fInto.
+var sha256LanesFast = false
+
+// Compresses the block of every lane into its state.
+func block8(st *sha256LaneState, blk *sha256LaneBlock) {
+	block8Generic(st, blk)
+}
@@ -0,0 +1,142 @@
+package mbpqs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// Runs test with the multi-lane backend switched on, also if block8 has no
+// fast implementation on this machine.
+func withLanes(test func()) {
+	fast := sha256LanesFast
+	sha256LanesFast = true
+	defer func() { sha256LanesFast = fast }()
+	test()
+}
+
+func TestBlock8(t *testing.T) {
+	rng := rand.New(rand.NewSource(8))
+	var st, want sha256LaneState
+	var blk sha256LaneBlock
+	var msgs [sha256Lanes][64]byte
+	st.broadcast(&sha256IV)
+	for l := range msgs {
+		rng.Read(msgs[l][:55])
+		msgs[l][55] = 0x80
+		binary.BigEndian.PutUint64(msgs[l][56:], 55*8)
+		for i := 0; i < 16; i++ {
+			blk[i][l] = binary.BigEndian.Uint32(msgs[l][4*i:])
+		}
+	}
+	want = st
+	block8Generic(&want, &blk)
+	block8(&st, &blk)
+	if st != want {
+		t.Fatal("block8 differs from block8Generic")
+	}
+
+	// The lanes hold the SHA-256 digests of their messages.
+	for l := range msgs {
+		var digest [32]byte
+		for i := 0; i < 8; i++ {
+			binary.BigEndian.PutUint32(digest[4*i:], st[i][l])
+		}
+		if digest != sha256.Sum256(msgs[l][:55]) {
+			t.Fatalf("Lane %d holds digest %x instead of the one of its message", l, digest)
+		}
+	}
+}
+
+func TestWotsGenChainsLanes(t *testing.T) {
+	rng := rand.New(rand.NewSource(25))
+	for _, w := range []uint16{4, 16, 256} {
+		ctx, err := newContext(InitParam(32, 2, 2, 0, 0, w))
+		if err != nil {
+			t.Fatalf("Creating context failed with error %s", err)
+		}
+		pubSeed := make([]byte, 32)
+		rng.Read(pubSeed)
+		addr := address{0, 1, 2, 0, 7, 0, 0, 0}
+		in := make([]byte, ctx.wotsSigBytes)
+		rng.Read(in)
+		starts := make([]uint16, ctx.wotsLen)
+		ends := make([]uint16, ctx.wotsLen)
+		for i := range starts {
+			starts[i] = uint16(rng.Intn(int(w)))
+			ends[i] = starts[i] + uint16(rng.Intn(int(w-starts[i])))
+		}
+
+		pad := ctx.newScratchPad()
+		want := make([]byte, len(in))
+		ctx.wotsGenChainsInto(pad, in, starts, ends, ctx.precomputeHashes(pubSeed, nil), addr, want)
+		got := make([]byte, len(in))
+		withLanes(func() {
+			ph := ctx.precomputeHashes(pubSeed, nil)
+			if ph.pubSeedLanes == nil {
+				t.Fatal("Multi-lane backend is not used for SHA-256 with n = 32")
+			}
+			ctx.wotsGenChainsInto(pad, in, starts, ends, ph, addr, got)
+		})
+		if !bytes.Equal(got, want) {
+			t.Fatalf("Chains advanced together differ from the ones advanced one by one for w = %d", w)
+		}
+	}
+}
+
+// Runs bench without the multi-lane backend, and with it if it is fast on
+// this machine.
+func benchmarkLanes(b *testing.B, bench func(b *testing.B)) {
+	fast := sha256LanesFast
+	defer func() { sha256LanesFast = fast }()
+	sha256LanesFast = false
+	b.Run("sequential", bench)
+	if fast {
+		sha256LanesFast = true
+		b.Run("lanes", bench)
+	}
+}
+
+func BenchmarkBlock8(b *testing.B) {
+	var st sha256LaneState
+	var blk sha256LaneBlock
+	b.Run("generic", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			block8Generic(&st, &blk)
+		}
+	})
+	b.Run("block8", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			block8(&st, &blk)
+		}
+	})
+}
+
+func BenchmarkWotsSignLanes(b *testing.B) {
+	benchmarkLanes(b, func(b *testing.B) {
+		benchmarkWotsSign(b, 1)
+	})
+}
+
+func BenchmarkWotsGenPkLanes(b *testing.B) {
+	benchmarkLanes(b, func(b *testing.B) {
+		benchmarkWotsGenPk(NewContextFromOid(1), b)
+	})
+}
+
+func BenchmarkKeyGenLanes(b *testing.B) {
+	HCases := []uint32{10, 16}
+	if testing.Short() {
+		HCases = []uint32{10}
+	}
+	for _, H := range HCases {
+		b.Run(fmt.Sprintf("w16-H%d", H), func(b *testing.B) {
+			benchmarkLanes(b, func(b *testing.B) {
+				benchmarkKeyGen(H, 16, b)
+			})
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package mbpqs
+
+import (
+	"crypto/subtle"
+	"fmt"
+)
+
+/* The seeds skSeed, skPrf and pubSeed determine a key pair completely. For
+ * backups, SplitSeeds splits them into n KeyShares with Shamir's secret
+ * sharing over GF(2^8), such that any k of them recover the seeds, and fewer
+ * reveal nothing about them. Every byte of skSeed || skPrf || pubSeed is the
+ * constant term of its own random polynomial of degree k-1, and share x holds
+ * the evaluations of these polynomials at x.
+ *
+ * The seeds only recover the keys, not which of them are used. Therefore,
+ * RecoverPrivateKey combines the shares with the last known KeyStatus of the
+ * key, see KeyStatus.Observe, and continues after it.
+ */
+
+// KeyShare is a share of the seeds of a key pair.
+type KeyShare struct {
+	ctx       *Context
+	threshold uint8  // The amount of shares needed to recover the seeds.
+	index     uint8  // The x-coordinate of the share, which is never 0.
+	root      []byte // The root of the key pair, to check the recovered seeds.
+	value     []byte // The share of skSeed || skPrf || pubSeed.
+}
+
+// Index returns the index of the KeyShare, from 1 on.
+func (ks *KeyShare) Index() int {
+	return int(ks.index)
+}
+
+// Threshold returns the amount of KeyShares needed to recover the seeds.
+func (ks *KeyShare) Threshold() int {
+	return int(ks.threshold)
+}
+
+// String returns a description of the KeyShare for humans, without its value.
+func (ks *KeyShare) String() string {
+	return fmt.Sprintf("KeyShare{%s, root: %x, share %d, threshold: %d}",
+		ks.ctx.params, ks.root, ks.index, ks.threshold)
+}
+
+// Multiplies a and b in GF(2^8) with the AES polynomial, in constant time.
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return p
+}
+
+// Returns the multiplicative inverse of a in GF(2^8) as a^254, and 0 for 0.
+func gfInv(a byte) byte {
+	ret := byte(1)
+	for i := 0; i < 7; i++ {
+		a = gfMul(a, a)
+		ret = gfMul(ret, a)
+	}
+	return ret
+}
+
+// SplitSeeds splits the seeds of the PrivateKey into n KeyShares, of which
+// any k recover the key pair. At least 2 shares are needed, such that no
+// single share holder can recover the key pair.
+func (sk *PrivateKey) SplitSeeds(k, n int) ([]*KeyShare, error) {
+	if k < 2 || k > n || n > 255 {
+		return nil, fmt.Errorf("can not split the seeds into %d shares with threshold %d", n, k)
+	}
+	sk.mux.Lock()
+	if sk.destroyed {
+		sk.mux.Unlock()
+		return nil, fmt.Errorf("the private key is destroyed")
+	}
+	secret := make([]byte, 0, 3*sk.ctx.params.n)
+	secret = append(append(append(secret, sk.skSeed...), sk.skPrf...), sk.pubSeed...)
+	sk.mux.Unlock()
+	defer clear(secret)
+
+	// The coefficients of x^1 to x^(k-1) of the polynomial of every byte.
+	coeffs, err := randomBytes(uint32((k - 1) * len(secret)))
+	if err != nil {
+		return nil, err
+	}
+	defer clear(coeffs)
+
+	shares := make([]*KeyShare, n)
+	for i := range shares {
+		x := byte(i + 1)
+		value := make([]byte, len(secret))
+		for b := range secret {
+			// Horner's rule, from the highest coefficient down.
+			var y byte
+			for j := k - 2; j >= 0; j-- {
+				y = gfMul(y^coeffs[j*len(secret)+b], x)
+			}
+			value[b] = y ^ secret[b]
+		}
+		shares[i] = &KeyShare{
+			ctx:       sk.ctx,
+			threshold: uint8(k),
+			index:     x,
+			root:      append([]byte{}, sk.root...),
+			value:     value,
+		}
+	}
+	return shares, nil
+}
+
+// Recovers skSeed || skPrf || pubSeed from the shares by Lagrange
+// interpolation at 0.
+func combineShares(shares []*KeyShare) []byte {
+	secret := make([]byte, len(shares[0].value))
+	for i, si := range shares {
+		// The Lagrange basis polynomial of share i at 0.
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = gfMul(basis, gfMul(sj.index, gfInv(sj.index^si.index)))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(si.value[b], basis)
+		}
+	}
+	return secret
+}
+
+// RecoverPrivateKey recovers the PrivateKey from at least threshold of its
+// KeyShares, which uses t threads for its computations. The PrivateKey
+// continues after the last known KeyStatus st of the key, which must hold the
+// state of every channel. Use a KeyStatus which is at least as far as every
+// signature the key released: keys which are used again leak the key.
+func RecoverPrivateKey(shares []*KeyShare, st KeyStatus, t int) (*PrivateKey, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no key shares given")
+	}
+	first := shares[0]
+	if len(shares) < int(first.threshold) {
+		return nil, fmt.Errorf("%d key shares given, %d are needed", len(shares), first.threshold)
+	}
+	seen := make(map[uint8]bool)
+	for _, ks := range shares {
+		if *ks.ctx.params != *first.ctx.params || ks.threshold != first.threshold ||
+			subtle.ConstantTimeCompare(ks.root, first.root) != 1 {
+			return nil, fmt.Errorf("key share %d belongs to another key or split", ks.index)
+		}
+		if ks.index == 0 || seen[ks.index] {
+			return nil, fmt.Errorf("key share %d is given twice", ks.index)
+		}
+		seen[ks.index] = true
+	}
+
+	secret := combineShares(shares[:first.threshold])
+	defer clear(secret)
+	n := first.ctx.params.n
+	sk, _, err := DeriveKeyPair(first.ctx.params, t, secret[:n], secret[n:2*n], secret[2*n:])
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(sk.root, first.root) != 1 {
+		sk.Destroy()
+		return nil, fmt.Errorf("the key shares do not recover the key, one of them is corrupted")
+	}
+	if err = sk.restoreStatus(st); err != nil {
+		sk.Destroy()
+		return nil, err
+	}
+	return sk, nil
+}
+
+// Sets the state of the fresh PrivateKey to the KeyStatus st, and rebuilds
+// the internal node caches of its channels.
+func (sk *PrivateKey) restoreStatus(st KeyStatus) error {
+	rootLeaves := uint64(1) << sk.ctx.params.rootH
+	if uint64(len(st.Channels)) > rootLeaves || st.ChannelsUsed > rootLeaves {
+		return fmt.Errorf("key status holds more channels than the key has")
+	}
+	pad := sk.ctx.newScratchPad()
+	defer pad.wipe()
+	channels := make([]*Channel, len(st.Channels))
+	for i, cs := range st.Channels {
+		if cs.Index != uint32(i) || cs.Layer == 0 {
+			return fmt.Errorf("the state of channel %d is unknown", i)
+		}
+		// A closed channel may have used the last key of its chain tree.
+		if cs.ChainSeqNo > sk.ctx.chainTreeHeight(cs.Layer) || (cs.ChainSeqNo == sk.ctx.chainTreeHeight(cs.Layer) && !cs.Closed) {
+			return fmt.Errorf("channel %d has chainSeqNo %d in chain tree %d", i, cs.ChainSeqNo, cs.Layer)
+		}
+		ch := &Channel{
+			layers:     cs.Layer,
+			chainSeqNo: cs.ChainSeqNo,
+			seqNo:      cs.Signatures,
+			closed:     cs.Closed,
+		}
+		if sk.ctx.params.c > 0 {
+			ct := sk.genChainTree(pad, uint32(i), cs.Layer)
+			ch.cache = sk.ctx.chainTreeCache(ct, cs.Layer)
+		}
+		channels[i] = ch
+	}
+
+	sk.mux.Lock()
+	defer sk.mux.Unlock()
+	// Every channel used a root tree leaf.
+	sk.seqNo = SignatureSeqNo(st.ChannelsUsed)
+	if uint64(len(channels)) > st.ChannelsUsed {
+		sk.seqNo = SignatureSeqNo(len(channels))
+	}
+	sk.seqNoReserved = sk.seqNo
+	sk.Channels = channels
+	if st.SuccessorSigned {
+		sk.succession = successorReserved | successorSigned
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package mbpqs
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGF256(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if p := gfMul(byte(a), gfInv(byte(a))); p != 1 {
+			t.Fatalf("%d * %d^-1 = %d", a, a, p)
+		}
+	}
+	// 0x53 * 0xca = 0x01 in the AES field.
+	if p := gfMul(0x53, 0xca); p != 0x01 {
+		t.Fatalf("0x53 * 0xca = %#x instead of 0x01", p)
+	}
+}
+
+func TestSplitAndRecover(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 3, 1, 1, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	if _, err = sk.SplitSeeds(1, 3); err == nil {
+		t.Fatal("Splitting with threshold 1 did not give an error")
+	}
+	shares, err := sk.SplitSeeds(3, 5)
+	if err != nil {
+		t.Fatalf("Splitting seeds failed with error %s", err)
+	}
+
+	// Use the key, and observe every released signature.
+	var st KeyStatus
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+	if err != nil {
+		t.Fatalf("Creating verifier failed with error %s", err)
+	}
+	sigs := []Signature{rtSig}
+	msg := []byte("Block")
+	for i := 0; i < 3; i++ {
+		sig, err := sk.SignChannelMsgAutoGrow(chIdx, msg)
+		if err != nil {
+			t.Fatalf("Signing message failed with error %s", err)
+		}
+		for _, s := range sig.Signatures() {
+			if accept, err := cv.Verify(s, msg); !accept || err != nil {
+				t.Fatalf("Correct %s not accepted: %v", s, err)
+			}
+			sigs = append(sigs, s)
+		}
+	}
+	for _, sig := range sigs {
+		if err = st.Observe(sig); err != nil {
+			t.Fatalf("Observing %s failed with error %s", sig, err)
+		}
+	}
+	if want := sk.Status(); st.ChannelsUsed != want.ChannelsUsed ||
+		st.Channels[0].Layer != want.Channels[0].Layer ||
+		st.Channels[0].ChainSeqNo != want.Channels[0].ChainSeqNo ||
+		st.Channels[0].Signatures != want.Channels[0].Signatures {
+		t.Fatalf("Observed status %+v differs from the status %+v of the key", st, want)
+	}
+
+	for i, ks := range shares {
+		buf, err := ks.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Marshalling key share failed with error %s", err)
+		}
+		shares[i] = new(KeyShare)
+		if err = shares[i].UnmarshalBinary(buf); err != nil {
+			t.Fatalf("Unmarshalling key share failed with error %s", err)
+		}
+	}
+	if _, err = RecoverPrivateKey(shares[:2], st, 0); err == nil {
+		t.Fatal("Recovering from fewer shares than the threshold did not give an error")
+	}
+	if _, err = RecoverPrivateKey([]*KeyShare{shares[0], shares[1], shares[0]}, st, 0); err == nil {
+		t.Fatal("Recovering from a duplicated share did not give an error")
+	}
+	corrupted := *shares[2]
+	corrupted.value = append([]byte{}, shares[2].value...)
+	corrupted.value[0] ^= 1
+	if _, err = RecoverPrivateKey([]*KeyShare{shares[0], shares[1], &corrupted}, st, 0); err == nil {
+		t.Fatal("Recovering from a corrupted share did not give an error")
+	}
+	if _, err = RecoverPrivateKey(shares[2:], KeyStatus{Channels: []ChannelStatus{{}}}, 0); err == nil {
+		t.Fatal("Recovering with an unknown channel state did not give an error")
+	}
+
+	rec, err := RecoverPrivateKey([]*KeyShare{shares[4], shares[1], shares[3]}, st, 0)
+	if err != nil {
+		t.Fatalf("Recovering key failed with error %s", err)
+	}
+	if !bytes.Equal(rec.skSeed, sk.skSeed) || !bytes.Equal(rec.skPrf, sk.skPrf) {
+		t.Fatal("Recovered seeds differ")
+	}
+	// The recovered key continues the channel and the root tree.
+	sig, err := rec.SignChannelMsgAutoGrow(chIdx, msg)
+	if err != nil {
+		t.Fatalf("Signing with recovered key failed with error %s", err)
+	}
+	for _, s := range sig.Signatures() {
+		if accept, err := cv.Verify(s, msg); !accept || err != nil {
+			t.Fatalf("%s of recovered key not accepted: %v", s, err)
+		}
+	}
+	_, rtSig, err = rec.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel with recovered key failed with error %s", err)
+	}
+	if rtSig.seqNo != 1 {
+		t.Fatalf("Recovered key reuses root tree leaf %d", rtSig.seqNo)
+	}
+}
+
+// A channel of which only the RootSignature is released is recovered, such
+// that the recovered key does not add it again on another root tree leaf.
+func TestRecoverChannelWithoutMessages(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 3, 1, 1, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	shares, err := sk.SplitSeeds(2, 2)
+	if err != nil {
+		t.Fatalf("Splitting seeds failed with error %s", err)
+	}
+	var st KeyStatus
+	var rtSigs []*RootSignature
+	for i := 0; i < 2; i++ {
+		_, rtSig, err := sk.AddChannel()
+		if err != nil {
+			t.Fatalf("Adding channel failed with error %s", err)
+		}
+		if err = st.Observe(rtSig); err != nil {
+			t.Fatalf("Observing RootSignature failed with error %s", err)
+		}
+		rtSigs = append(rtSigs, rtSig)
+	}
+	if len(st.Channels) != 2 || st.Channels[1].Layer != 1 || st.Channels[1].ChainSeqNo != 0 {
+		t.Fatalf("Observed status %+v does not hold the channels", st)
+	}
+
+	rec, err := RecoverPrivateKey(shares, st, 0)
+	if err != nil {
+		t.Fatalf("Recovering key failed with error %s", err)
+	}
+	if len(rec.Channels) != 2 {
+		t.Fatalf("Recovered key has %d channels instead of 2", len(rec.Channels))
+	}
+	chIdx, rtSig, err := rec.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel with recovered key failed with error %s", err)
+	}
+	if chIdx != 2 || rtSig.seqNo != 2 {
+		t.Fatalf("Recovered key adds channel %d on root tree leaf %d instead of 2", chIdx, rtSig.seqNo)
+	}
+	// The recovered channel continues after its released RootSignature.
+	cv, err := pk.NewChannelVerifier(1, rtSigs[1])
+	if err != nil {
+		t.Fatalf("Creating verifier failed with error %s", err)
+	}
+	msg := []byte("Block")
+	sig, err := rec.SignMsg(1, msg)
+	if err != nil {
+		t.Fatalf("Signing with recovered key failed with error %s", err)
+	}
+	if accept, err := cv.VerifyMsg(sig, msg); !accept || err != nil {
+		t.Fatalf("MsgSignature of recovered key not accepted: %v", err)
+	}
+}
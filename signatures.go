@@ -1,5 +1,7 @@
 package mbpqs
 
+import "fmt"
+
 // SignatureSeqNo is the sequence number (index) of signatures and wotsKeys in channels and the root tree.
 type SignatureSeqNo uint32
 
@@ -72,3 +74,20 @@ func (ms *MsgSignature) lastMsgInChain() bool {
 	}
 	return false
 }
+
+// String returns a description of the RootSignature for humans.
+func (rtSig *RootSignature) String() string {
+	return fmt.Sprintf("RootSignature{seqNo: %d, root: %x}", rtSig.seqNo, rtSig.rootHash)
+}
+
+// String returns a description of the GrowSignature for humans.
+func (gs *GrowSignature) String() string {
+	return fmt.Sprintf("GrowSignature{channel: %d, layer: %d, chainSeqNo: %d, root: %x}",
+		gs.chIdx, gs.layer, gs.chainSeqNo, gs.rootHash)
+}
+
+// String returns a description of the MsgSignature for humans.
+func (ms *MsgSignature) String() string {
+	return fmt.Sprintf("MsgSignature{channel: %d, layer: %d, chainSeqNo: %d, seqNo: %d}",
+		ms.chIdx, ms.layer, ms.chainSeqNo, ms.seqNo)
+}
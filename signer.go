@@ -0,0 +1,293 @@
+package mbpqs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/* A signer daemon holds a PrivateKey in a separate process, and signs for
+ * clients over HTTP, served on a Unix socket or a TCP address. Requests and
+ * responses carry the binary encodings of marshal.go:
+ *
+ *   GET  /v1/public-key          -> PublicKey
+ *   POST /v1/channels            -> chIdx || RootSignature
+ *   POST /v1/channels/{ch}/grow  -> GrowSignature
+ *   POST /v1/channels/{ch}/sign  -> MsgSignature over the request body
+ *
+ * Failures are answered with a status other than 200 and the error as text.
+ * The protocol has no authentication: anyone who can connect can sign.
+ * Therefore, ListenSigner only listens on a Unix socket, which it makes
+ * accessible to its owner only, or on a loopback address. Web pages open in a
+ * browser on the host can reach a loopback address as well, so the daemon
+ * only answers requests with the X-Mbpqs-Signer header, which a browser does
+ * not send cross-origin without the consent of the daemon, and without an
+ * Origin header, which a browser does send.
+ */
+
+// Signer signs in the channels of a key pair. It is implemented by
+// PrivateKey, and by SignerClient for a PrivateKey in a signer daemon.
+type Signer interface {
+	// AddChannel returns the index of a new channel, and its RootSignature.
+	AddChannel() (uint32, *RootSignature, error)
+	// GrowChannel signs the next chain tree of channel chIdx.
+	GrowChannel(chIdx uint32) (*GrowSignature, error)
+	// SignMsg signs msg in channel chIdx.
+	SignMsg(chIdx uint32, msg []byte) (*MsgSignature, error)
+	// SignChannelReader signs the message read from msg in channel chIdx.
+	SignChannelReader(chIdx uint32, msg io.Reader) (*MsgSignature, error)
+	// PublicKey returns the PublicKey which verifies the signatures.
+	PublicKey() *PublicKey
+}
+
+// The header every request to a signer daemon carries.
+const signerHeader = "X-Mbpqs-Signer"
+
+// NewSignerHandler returns the http.Handler of a signer daemon which signs
+// with s. Requests without the header of SignerClient, and requests from web
+// pages, are refused.
+func NewSignerHandler(s Signer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/public-key", func(w http.ResponseWriter, r *http.Request) {
+		writeSignerResponse(w, s.PublicKey(), nil)
+	})
+	mux.HandleFunc("POST /v1/channels", func(w http.ResponseWriter, r *http.Request) {
+		chIdx, rtSig, err := s.AddChannel()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		var prefix [4]byte
+		binary.BigEndian.PutUint32(prefix[:], chIdx)
+		writeSignerResponse(w, rtSig, prefix[:])
+	})
+	mux.HandleFunc("POST /v1/channels/{ch}/grow", func(w http.ResponseWriter, r *http.Request) {
+		chIdx, ok := signerChannel(w, r)
+		if !ok {
+			return
+		}
+		growSig, err := s.GrowChannel(chIdx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeSignerResponse(w, growSig, nil)
+	})
+	mux.HandleFunc("POST /v1/channels/{ch}/sign", func(w http.ResponseWriter, r *http.Request) {
+		chIdx, ok := signerChannel(w, r)
+		if !ok {
+			return
+		}
+		// Stream the message into the message hash, as blocks can be large.
+		msgSig, err := s.SignChannelReader(chIdx, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeSignerResponse(w, msgSig, nil)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(signerHeader) == "" || r.Header.Get("Origin") != "" {
+			http.Error(w, "only signer clients may connect", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Parses the channel index in the path of the request r. Answers the request
+// and returns false if it is invalid.
+func signerChannel(w http.ResponseWriter, r *http.Request) (uint32, bool) {
+	chIdx, err := strconv.ParseUint(r.PathValue("ch"), 10, 32)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid channel %q", r.PathValue("ch")), http.StatusBadRequest)
+		return 0, false
+	}
+	return uint32(chIdx), true
+}
+
+// Answers with prefix followed by the binary encoding of obj.
+func writeSignerResponse(w http.ResponseWriter, obj interface{ MarshalBinary() ([]byte, error) }, prefix []byte) {
+	buf, err := obj.MarshalBinary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(append(prefix, buf...))
+}
+
+// Splits the address of a signer daemon into the network and address to
+// listen on or dial. Addresses starting with "unix:" are Unix sockets.
+func signerAddr(addr string) (string, string) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return "unix", path
+	}
+	return "tcp", strings.TrimPrefix(addr, "http://")
+}
+
+// ListenSigner listens for clients of a signer daemon on addr, which is either
+// "unix:" followed by the path of a Unix socket, or a TCP address on the
+// loopback interface. Only the owner may connect to the Unix socket.
+func ListenSigner(addr string) (net.Listener, error) {
+	network, address := signerAddr(addr)
+	if network == "tcp" && !loopbackAddr(address) {
+		return nil, fmt.Errorf("signer daemon may only listen on a Unix socket or a loopback address, not %s", address)
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		if err = os.Chmod(address, 0600); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Returns whether the TCP address addr is on the loopback interface.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// SignerClient is a Signer which signs with the PrivateKey in a signer
+// daemon, see NewSignerHandler.
+type SignerClient struct {
+	base string       // The URL of the signer daemon.
+	hc   *http.Client // The client, which dials the Unix socket of the daemon.
+	pk   *PublicKey   // The PublicKey of the daemon.
+}
+
+// DialSigner connects to the signer daemon at addr, see ListenSigner, and
+// retrieves its PublicKey.
+func DialSigner(addr string) (*SignerClient, error) {
+	network, address := signerAddr(addr)
+	sc := &SignerClient{base: "http://" + address, hc: &http.Client{}}
+	if network == "unix" {
+		// The host in the URL is not used to connect to the socket.
+		sc.base = "http://signer"
+		var d net.Dialer
+		sc.hc.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, "unix", address)
+			},
+		}
+	}
+	resp, err := sc.request(http.MethodGet, "/v1/public-key", nil)
+	if err != nil {
+		return nil, err
+	}
+	sc.pk = new(PublicKey)
+	if err = sc.pk.UnmarshalBinary(resp); err != nil {
+		return nil, fmt.Errorf("signer sent an invalid public key: %s", err)
+	}
+	return sc, nil
+}
+
+// Sends a request to the signer daemon, and returns the body of its answer.
+func (sc *SignerClient) request(method, path string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, sc.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(signerHeader, "1")
+	resp, err := sc.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signer: %s", strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// Checks the result err of decoding a signature sent by the daemon, and that
+// the signature is well-formed for the PublicKey of the daemon.
+func (sc *SignerClient) checkSignature(err error, sig Signature) error {
+	if err == nil {
+		err = sc.pk.checkSignature(sig)
+	}
+	if err != nil {
+		return fmt.Errorf("signer sent an invalid signature: %w", err)
+	}
+	return nil
+}
+
+// AddChannel adds a channel in the signer daemon, and returns its index and
+// RootSignature.
+func (sc *SignerClient) AddChannel() (uint32, *RootSignature, error) {
+	resp, err := sc.request(http.MethodPost, "/v1/channels", nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(resp) < 4 {
+		return 0, nil, fmt.Errorf("signer sent a truncated answer")
+	}
+	rtSig := new(RootSignature)
+	err = rtSig.UnmarshalBinary(resp[4:])
+	if err = sc.checkSignature(err, rtSig); err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint32(resp[:4]), rtSig, nil
+}
+
+// GrowChannel signs the next chain tree of channel chIdx in the signer daemon.
+func (sc *SignerClient) GrowChannel(chIdx uint32) (*GrowSignature, error) {
+	resp, err := sc.request(http.MethodPost, fmt.Sprintf("/v1/channels/%d/grow", chIdx), nil)
+	if err != nil {
+		return nil, err
+	}
+	growSig := new(GrowSignature)
+	err = growSig.UnmarshalBinary(resp)
+	if err = sc.checkSignature(err, growSig); err != nil {
+		return nil, err
+	}
+	return growSig, nil
+}
+
+// SignMsg signs msg in channel chIdx in the signer daemon.
+func (sc *SignerClient) SignMsg(chIdx uint32, msg []byte) (*MsgSignature, error) {
+	return sc.SignChannelReader(chIdx, bytes.NewReader(msg))
+}
+
+// SignChannelReader signs the message read from msg in channel chIdx in the
+// signer daemon. The message is streamed to the daemon.
+func (sc *SignerClient) SignChannelReader(chIdx uint32, msg io.Reader) (*MsgSignature, error) {
+	resp, err := sc.request(http.MethodPost, fmt.Sprintf("/v1/channels/%d/sign", chIdx), msg)
+	if err != nil {
+		return nil, err
+	}
+	msgSig := new(MsgSignature)
+	err = msgSig.UnmarshalBinary(resp)
+	if err = sc.checkSignature(err, msgSig); err != nil {
+		return nil, err
+	}
+	return msgSig, nil
+}
+
+// PublicKey returns the PublicKey of the signer daemon.
+func (sc *SignerClient) PublicKey() *PublicKey {
+	return sc.pk
+}
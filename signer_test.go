@@ -0,0 +1,114 @@
+package mbpqs
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Adds a channel with s, signs messages in it until it grows, and verifies
+// every signature.
+func signWithSigner(t *testing.T, s Signer) {
+	chIdx, rtSig, err := s.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	cv, err := s.PublicKey().NewChannelVerifier(chIdx, rtSig)
+	if err != nil {
+		t.Fatalf("Creating verifier failed with error %s", err)
+	}
+	msg := []byte("Block")
+	verify := func(sig Signature, msg []byte) {
+		if accept, err := cv.Verify(sig, msg); !accept || err != nil {
+			t.Fatalf("Correct %s not accepted: %v", sig, err)
+		}
+	}
+	msgSig, err := s.SignMsg(chIdx, msg)
+	if err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	verify(msgSig, msg)
+	if _, err = s.SignMsg(chIdx, msg); err == nil {
+		t.Fatal("Signing in a full chain tree did not give an error")
+	}
+	growSig, err := s.GrowChannel(chIdx)
+	if err != nil {
+		t.Fatalf("Growing channel failed with error %s", err)
+	}
+	verify(growSig, nil)
+	if msgSig, err = s.SignChannelReader(chIdx, bytes.NewReader(msg)); err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	verify(msgSig, msg)
+}
+
+func TestSigner(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 2, 1, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	signWithSigner(t, sk)
+
+	addr := "unix:" + filepath.Join(t.TempDir(), "signer.sock")
+	l, err := ListenSigner(addr)
+	if err != nil {
+		t.Fatalf("Listening failed with error %s", err)
+	}
+	srv := &http.Server{Handler: NewSignerHandler(sk)}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	sc, err := DialSigner(addr)
+	if err != nil {
+		t.Fatalf("Dialing signer failed with error %s", err)
+	}
+	if string(sc.PublicKey().root) != string(pk.root) {
+		t.Fatal("Signer client has another public key")
+	}
+	signWithSigner(t, sc)
+	if _, err = sc.GrowChannel(2); err == nil || !strings.Contains(err.Error(), "channel does not exist") {
+		t.Fatalf("Growing a channel which does not exist gave error %v", err)
+	}
+	// Requests without the header of the client, or from a web page, are
+	// refused before they are parsed.
+	for _, c := range []struct {
+		header map[string]string
+		status int
+	}{
+		{map[string]string{signerHeader: "1"}, http.StatusBadRequest},
+		{nil, http.StatusForbidden},
+		{map[string]string{signerHeader: "1", "Origin": "http://example.com"}, http.StatusForbidden},
+	} {
+		req, err := http.NewRequest(http.MethodPost, sc.base+"/v1/channels/x/sign", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range c.header {
+			req.Header.Set(k, v)
+		}
+		resp, err := sc.hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Fatalf("Request with header %v gave status %d instead of %d", c.header, resp.StatusCode, c.status)
+		}
+	}
+}
+
+func TestListenSigner(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:0", ":0", "example.com:0"} {
+		if l, err := ListenSigner(addr); err == nil {
+			l.Close()
+			t.Fatalf("Listening on %s did not give an error", addr)
+		}
+	}
+	l, err := ListenSigner("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listening on a loopback address failed with error %s", err)
+	}
+	l.Close()
+}
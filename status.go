@@ -0,0 +1,265 @@
+package mbpqs
+
+import (
+	"fmt"
+	"sync"
+)
+
+// KeyStatus describes how much of a PrivateKey is used.
+type KeyStatus struct {
+	RootLeaves   uint64          // The amount of leafs in the root tree, and thus channels.
+	ChannelsUsed uint64          // The amount of root tree leafs used.
+	ChannelsLeft uint64          // The amount of channels which can still be added.
+	Signatures   uint64          // The total amount of signatures made by the key.
+	Channels     []ChannelStatus // The status of every channel of the key.
+	// Whether the last root tree leaf has signed a successor, see SignSuccessor.
+	SuccessorSigned bool
+}
+
+// ChannelStatus describes where a channel stands in its current chain tree.
+type ChannelStatus struct {
+	Index           uint32         // The index of the channel.
+	Layer           uint32         // The layer of the current chain tree.
+	ChainTreeHeight uint32         // The height of the current chain tree.
+	ChainSeqNo      uint32         // The chainSeqNo of the next key in the chain tree.
+	KeysLeft        uint32         // The amount of messages which can be signed before the next grow.
+	Signatures      SignatureSeqNo // The amount of MsgSignatures made in the channel.
+	Closed          bool           // Whether the channel is closed.
+}
+
+// LayerStatus describes a (future) chain tree in a channel.
+type LayerStatus struct {
+	Layer           uint32 // The layer of the chain tree.
+	ChainTreeHeight uint32 // The height of the chain tree.
+	Messages        uint32 // The amount of messages signed with the chain tree.
+	SignatureSize   int    // The size of the encoding of a MsgSignature.
+	GrowSize        int    // The size of the encoding of the GrowSignature ending the chain tree.
+	MaxProofSize    uint64 // The size of the encoding of a Proof for the last message in the chain tree.
+}
+
+// Low-watermark callbacks of a PrivateKey, see OnRootLow and OnChannelLow.
+type watermarks struct {
+	rootLeft  uint64              // Threshold for the channels left.
+	rootFn    func(uint64)        // Called once when the channels left drop to rootLeft.
+	rootFired bool                // Whether rootFn is called.
+	chanLeft  uint32              // Threshold for the keys left in a chain tree.
+	chanFn    func(ChannelStatus) // Called once per chain tree when its keys left drop to chanLeft.
+	chanFired map[uint32]uint32   // Per channel, the last layer chanFn is called for.
+	mux       sync.Mutex          // Used when mutual exclusion for the watermarks is required.
+}
+
+// Status returns how much of the PrivateKey is used.
+func (sk *PrivateKey) Status() KeyStatus {
+	sk.mux.Lock()
+	st := KeyStatus{
+		RootLeaves:   uint64(1) << sk.ctx.params.rootH,
+		ChannelsUsed: uint64(sk.seqNo),
+		ChannelsLeft: sk.channelsLeft(),
+		Signatures:   uint64(sk.seqNo),
+	}
+	if sk.succession&successorSigned != 0 {
+		st.Signatures++
+		st.SuccessorSigned = true
+	}
+	channels := append([]*Channel{}, sk.Channels...)
+	sk.mux.Unlock()
+
+	for chIdx, ch := range channels {
+		ch.mux.Lock()
+		chSt := sk.channelStatus(uint32(chIdx), ch)
+		ch.mux.Unlock()
+		// Every chain tree but the current one is ended by a GrowSignature.
+		st.Signatures += uint64(chSt.Signatures) + uint64(chSt.Layer-1)
+		if chSt.Closed {
+			st.Signatures++
+		}
+		st.Channels = append(st.Channels, chSt)
+	}
+	return st
+}
+
+// Returns the amount of channels which can still be added.
+// The lock of the PrivateKey should be held.
+func (sk *PrivateKey) channelsLeft() uint64 {
+	if limit := sk.rootLeafLimit(); uint64(sk.seqNo) < limit {
+		return limit - uint64(sk.seqNo)
+	}
+	return 0
+}
+
+// ChannelStatus returns where channel chIdx stands in its current chain tree.
+func (sk *PrivateKey) ChannelStatus(chIdx uint32) (ChannelStatus, error) {
+	if chIdx >= uint32(len(sk.Channels)) {
+		return ChannelStatus{}, fmt.Errorf("channel does not exist, please create it first")
+	}
+	ch := sk.getChannel(chIdx)
+	ch.mux.Lock()
+	defer ch.mux.Unlock()
+	return sk.channelStatus(chIdx, ch), nil
+}
+
+// Returns the status of channel ch with index chIdx.
+// The lock of the channel should be held.
+func (sk *PrivateKey) channelStatus(chIdx uint32, ch *Channel) ChannelStatus {
+	cH := sk.ctx.chainTreeHeight(ch.layers)
+	st := ChannelStatus{
+		Index:           chIdx,
+		Layer:           ch.layers,
+		ChainTreeHeight: cH,
+		ChainSeqNo:      ch.chainSeqNo,
+		Signatures:      ch.seqNo,
+		Closed:          ch.closed,
+	}
+	if !ch.closed && !sk.chainTreeFull(ch) {
+		st.KeysLeft = cH - 1 - ch.chainSeqNo
+	}
+	return st
+}
+
+// Observe advances the KeyStatus past the signature sig, which is released by
+// the key, such that a PrivateKey recovered with it does not use the keys of
+// sig again, see RecoverPrivateKey. A signature in a channel which is not in
+// the KeyStatus yet adds it, and the channels before it with an unknown state.
+// Only ChannelsUsed and the positions of the channels are updated.
+//
+// A RootSignature adds the channel of its root tree leaf at its first key.
+// The leaf is the index of the channel, unless the key skipped leaves after
+// a crash, see SetLookahead. Then the channels before it can stay unknown,
+// and RecoverPrivateKey refuses the KeyStatus instead of guessing them.
+func (st *KeyStatus) Observe(sig Signature) error {
+	if signatureContext(sig) == nil {
+		return verifyError(ErrMalformedSignature, "can not observe a %T without context", sig)
+	}
+	switch s := sig.(type) {
+	case *RootSignature:
+		if used := uint64(s.seqNo) + 1; used > st.ChannelsUsed {
+			st.ChannelsUsed = used
+		}
+		return st.observeKey(s.ctx, uint32(s.seqNo), 1, 0, 0, false)
+	case *MsgSignature:
+		return st.observeKey(s.ctx, s.chIdx, s.layer, s.chainSeqNo+1, s.seqNo+1, false)
+	case *GrowSignature:
+		// The next chain tree is signed, but none of its keys is used.
+		return st.observeKey(s.ctx, s.chIdx, s.layer+1, 0, 0, false)
+	case *CloseSignature:
+		return st.observeKey(s.ctx, s.chIdx, s.layer, s.chainSeqNo+1, s.seqNo, true)
+	}
+	return fmt.Errorf("can not observe a %T", sig)
+}
+
+// Advances channel chIdx to at least the key next in chain tree chLayer, and
+// its channel seqNo to at least seqNo.
+func (st *KeyStatus) observeKey(ctx *Context, chIdx, chLayer, next uint32, seqNo SignatureSeqNo, closed bool) error {
+	if uint64(chIdx) >= uint64(1)<<ctx.params.rootH {
+		return fmt.Errorf("channel %d does not fit in the root tree", chIdx)
+	}
+	for uint32(len(st.Channels)) <= chIdx {
+		st.Channels = append(st.Channels, ChannelStatus{Index: uint32(len(st.Channels))})
+	}
+	cs := &st.Channels[chIdx]
+	if chLayer > cs.Layer || (chLayer == cs.Layer && next > cs.ChainSeqNo) {
+		cs.Layer, cs.ChainSeqNo = chLayer, next
+		cs.ChainTreeHeight = ctx.chainTreeHeight(chLayer)
+	}
+	if seqNo > cs.Signatures {
+		cs.Signatures = seqNo
+	}
+	cs.Closed = cs.Closed || closed
+	cs.KeysLeft = 0
+	if !cs.Closed && cs.ChainSeqNo < cs.ChainTreeHeight-1 {
+		cs.KeysLeft = cs.ChainTreeHeight - 1 - cs.ChainSeqNo
+	}
+	return nil
+}
+
+// ProjectLayers describes the next count chain trees of channel chIdx, which
+// grow by the growth factor of the parameters.
+func (sk *PrivateKey) ProjectLayers(chIdx uint32, count int) ([]LayerStatus, error) {
+	if count < 0 {
+		return nil, fmt.Errorf("cannot project %d layers", count)
+	}
+	st, err := sk.ChannelStatus(chIdx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]LayerStatus, count)
+	for i := range ret {
+		ret[i] = sk.ctx.layerStatus(st.Layer + uint32(i) + 1)
+	}
+	return ret, nil
+}
+
+// Returns the description of a chain tree at layer chLayer.
+func (ctx *Context) layerStatus(chLayer uint32) LayerStatus {
+	cH := ctx.chainTreeHeight(chLayer)
+	return LayerStatus{
+		Layer:           chLayer,
+		ChainTreeHeight: cH,
+		Messages:        cH - 1,
+		SignatureSize:   ctx.msgSignatureSize(),
+		GrowSize:        ctx.growSignatureSize(),
+		MaxProofSize:    ctx.proofSize(chLayer, cH-2),
+	}
+}
+
+// OnRootLow registers fn to be called once the amount of channels which can
+// still be added drops to left or below. It is called with the amount of
+// channels left, after the RootSignature which crossed the watermark is made.
+// A nil fn removes the callback.
+func (sk *PrivateKey) OnRootLow(left uint64, fn func(left uint64)) {
+	wm := &sk.watermarks
+	wm.mux.Lock()
+	wm.rootLeft = left
+	wm.rootFn = fn
+	wm.rootFired = false
+	wm.mux.Unlock()
+	sk.checkRootWatermark()
+}
+
+// OnChannelLow registers fn to be called once the amount of messages which
+// can be signed in the current chain tree of a channel drops to left or
+// below. It is called once per chain tree, after the MsgSignature which
+// crossed the watermark is made. A nil fn removes the callback.
+func (sk *PrivateKey) OnChannelLow(left uint32, fn func(ChannelStatus)) {
+	wm := &sk.watermarks
+	wm.mux.Lock()
+	defer wm.mux.Unlock()
+	wm.chanLeft = left
+	wm.chanFn = fn
+	wm.chanFired = make(map[uint32]uint32)
+}
+
+// Calls the root watermark callback if the watermark is crossed.
+func (sk *PrivateKey) checkRootWatermark() {
+	sk.mux.Lock()
+	left := sk.channelsLeft()
+	sk.mux.Unlock()
+
+	wm := &sk.watermarks
+	wm.mux.Lock()
+	fn := wm.rootFn
+	fire := fn != nil && !wm.rootFired && left <= wm.rootLeft
+	if fire {
+		wm.rootFired = true
+	}
+	wm.mux.Unlock()
+	if fire {
+		fn(left)
+	}
+}
+
+// Calls the channel watermark callback if the watermark is crossed in the
+// chain tree of st.
+func (sk *PrivateKey) checkChannelWatermark(st ChannelStatus) {
+	wm := &sk.watermarks
+	wm.mux.Lock()
+	fn := wm.chanFn
+	fire := fn != nil && st.KeysLeft <= wm.chanLeft && wm.chanFired[st.Index] < st.Layer
+	if fire {
+		wm.chanFired[st.Index] = st.Layer
+	}
+	wm.mux.Unlock()
+	if fire {
+		fn(st)
+	}
+}
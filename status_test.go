@@ -0,0 +1,68 @@
+package mbpqs
+
+import (
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	sk, _, err := GenerateKeyPair(InitParam(32, 3, 3, 2, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	var rootLeft []uint64
+	sk.OnRootLow(6, func(left uint64) { rootLeft = append(rootLeft, left) })
+	var chanLow []ChannelStatus
+	sk.OnChannelLow(1, func(st ChannelStatus) { chanLow = append(chanLow, st) })
+
+	for i := 0; i < 3; i++ {
+		if _, _, err = sk.AddChannel(); err != nil {
+			t.Fatalf("Adding channel failed with error %s", err)
+		}
+	}
+	if len(rootLeft) != 1 || rootLeft[0] != 6 {
+		t.Fatalf("Root watermark callback is called with %v instead of [6]", rootLeft)
+	}
+
+	// Sign the first chain tree of height 3 full, and one message in the next one of height 5.
+	for i := 0; i < 2; i++ {
+		if _, err = sk.SignMsg(1, []byte("Block")); err != nil {
+			t.Fatalf("Signing message failed with error %s", err)
+		}
+	}
+	if len(chanLow) != 1 || chanLow[0].KeysLeft != 1 || chanLow[0].Layer != 1 {
+		t.Fatalf("Channel watermark callback is called with %v", chanLow)
+	}
+	if _, err = sk.SignChannelMsgAutoGrow(1, []byte("Block")); err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+
+	st := sk.Status()
+	if st.RootLeaves != 8 || st.ChannelsUsed != 3 || st.ChannelsLeft != 5 {
+		t.Fatalf("Status has %d root leafs, %d channels used and %d left", st.RootLeaves, st.ChannelsUsed, st.ChannelsLeft)
+	}
+	// Three RootSignatures, three MsgSignatures and a GrowSignature.
+	if st.Signatures != 7 || len(st.Channels) != 3 {
+		t.Fatalf("Status has %d signatures and %d channels", st.Signatures, len(st.Channels))
+	}
+	want := ChannelStatus{Index: 1, Layer: 2, ChainTreeHeight: 5, ChainSeqNo: 1, KeysLeft: 3, Signatures: 3}
+	if st.Channels[1] != want {
+		t.Fatalf("Channel status is %+v instead of %+v", st.Channels[1], want)
+	}
+
+	layers, err := sk.ProjectLayers(1, 2)
+	if err != nil {
+		t.Fatalf("Projecting layers failed with error %s", err)
+	}
+	if len(layers) != 2 || layers[0].Layer != 3 || layers[0].ChainTreeHeight != 7 || layers[1].Messages != 8 {
+		t.Fatalf("Projected layers are %+v", layers)
+	}
+	if layers[1].MaxProofSize <= layers[0].MaxProofSize {
+		t.Fatal("Proofs do not grow with the chain trees")
+	}
+	if _, err = sk.ProjectLayers(1, -1); err == nil {
+		t.Fatal("Projecting a negative amount of layers did not give an error")
+	}
+	if _, err = sk.ChannelStatus(3); err == nil {
+		t.Fatal("Status of a channel which does not exist did not give an error")
+	}
+}
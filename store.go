@@ -0,0 +1,245 @@
+package mbpqs
+
+import (
+	"fmt"
+	"sync"
+)
+
+/* The state of a PrivateKey lives in a StateStore: a key file (Persist), an
+ * embedded database (package boltstore), or memory (MemoryStateStore). Every
+ * state transition, a reservation of indices, a new channel or chain tree, a
+ * closed channel or a signed successor, is saved to the store before the
+ * signature which depends on it is released, see keyfile.go. The traversal
+ * state of the root tree, see traversal.go, is saved along, and after every
+ * RootSignature. Save replaces the whole state, and must do so atomically: a
+ * crash during Save leaves either the old or the new state.
+ */
+
+// KeyState is the state of a PrivateKey, as saved in a StateStore. The
+// indices are those reserved in the store, see PrivateKey.SetLookahead.
+type KeyState struct {
+	Params     *Params
+	SkSeed     []byte
+	SkPrf      []byte
+	PubSeed    []byte
+	Root       []byte
+	SeqNo      SignatureSeqNo // The first root tree leaf which is not reserved.
+	Succession uint32         // Succession flags, see ReserveSuccessorLeaf.
+	Channels   []ChannelState
+	RootTree   *RootTreeState // Traversal state of the root tree, nil if it is not built.
+}
+
+// ChannelState is the state of a channel in a KeyState.
+type ChannelState struct {
+	Layer      uint32         // The current chain tree, from 1 on.
+	ChainSeqNo uint32         // The first key in the chain tree which is not reserved.
+	SeqNo      SignatureSeqNo // The first channel seqNo which is not reserved.
+	Cache      []byte         // The internal node cache of the chain tree.
+	Closed     bool
+}
+
+// StateStore saves the state of a PrivateKey.
+type StateStore interface {
+	// Save durably and atomically replaces the saved state by st. The
+	// store must not keep st or its slices, which the PrivateKey changes.
+	Save(st *KeyState) error
+	// Load returns a copy of the saved state, or nil if there is none.
+	Load() (*KeyState, error)
+}
+
+// MemoryStateStore is a StateStore which keeps the state in memory, for
+// tests and for keys which do not outlive the process. The zero
+// MemoryStateStore is empty.
+type MemoryStateStore struct {
+	state []byte // The encoded state, nil if none is saved.
+	mux   sync.Mutex
+}
+
+// Save replaces the state in the MemoryStateStore by a copy of st.
+func (ms *MemoryStateStore) Save(st *KeyState) error {
+	data := marshalKeyState(st)
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	clear(ms.state)
+	ms.state = data
+	return nil
+}
+
+// Load returns a copy of the state in the MemoryStateStore.
+func (ms *MemoryStateStore) Load() (*KeyState, error) {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	if ms.state == nil {
+		return nil, nil
+	}
+	return keyStateFromBytes(ms.state)
+}
+
+// Zeroes the saved state, which leaves the MemoryStateStore empty, see Destroy.
+func (ms *MemoryStateStore) wipe() {
+	ms.mux.Lock()
+	defer ms.mux.Unlock()
+	clear(ms.state)
+	ms.state = nil
+}
+
+// PersistTo saves the PrivateKey to store, which must be empty. From then on,
+// the PrivateKey saves every state change to the store, and reserves indices
+// in it before they are used, see Persist for the cost and the crashes this
+// implies. The state must not be loaded from the store by more than one
+// PrivateKey at a time.
+func (sk *PrivateKey) PersistTo(store StateStore) error {
+	st, err := store.Load()
+	if err != nil {
+		return err
+	}
+	if st != nil {
+		clear(st.SkSeed)
+		clear(st.SkPrf)
+		return fmt.Errorf("the state store already holds a key")
+	}
+	// Locking order is channel before PrivateKey, like in ChannelSeqNos.
+	channels := sk.Channels
+	for _, ch := range channels {
+		ch.mux.Lock()
+		defer ch.mux.Unlock()
+	}
+	sk.mux.Lock()
+	defer sk.mux.Unlock()
+
+	// Reservations start at the current state.
+	sk.seqNoReserved = sk.seqNo
+	for _, ch := range channels {
+		ch.chainSeqNoReserved = ch.chainSeqNo
+		ch.seqNoReserved = ch.seqNo
+	}
+	sk.store = store
+	if err := sk.saveState(); err != nil {
+		sk.store = nil
+		return err
+	}
+	return nil
+}
+
+// LoadPrivateKeyFrom loads a PrivateKey from store, which uses t threads for
+// its computations. The PrivateKey continues after the indices reserved in
+// the store, and saves its state changes to it.
+func LoadPrivateKeyFrom(store StateStore, t int) (*PrivateKey, error) {
+	st, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		return nil, fmt.Errorf("the state store holds no key")
+	}
+	sk, err := privateKeyFromState(st)
+	if err != nil {
+		clear(st.SkSeed)
+		clear(st.SkPrf)
+		return nil, err
+	}
+	sk.ctx.threads = t
+	sk.store = store
+	return sk, nil
+}
+
+// Returns the PrivateKey with the state st, which it takes over. The
+// PrivateKey continues after the reserved indices.
+func privateKeyFromState(st *KeyState) (*PrivateKey, error) {
+	if st.Params == nil {
+		return nil, fmt.Errorf("key state has no parameters")
+	}
+	params := *st.Params
+	ctx, err := newContext(&params)
+	if err != nil {
+		return nil, err
+	}
+	n := int(params.n)
+	if len(st.SkSeed) != n || len(st.SkPrf) != n || len(st.PubSeed) != n || len(st.Root) != n {
+		return nil, fmt.Errorf("key state has seeds or root of the wrong size")
+	}
+	if uint64(len(st.Channels)) > uint64(1)<<params.rootH {
+		return nil, fmt.Errorf("key state holds %d channels", len(st.Channels))
+	}
+	if st.Succession&^(successorReserved|successorSigned) != 0 {
+		return nil, fmt.Errorf("key state has unknown succession flags %x", st.Succession)
+	}
+	sk := &PrivateKey{
+		ctx:           ctx,
+		skSeed:        st.SkSeed,
+		skPrf:         st.SkPrf,
+		pubSeed:       st.PubSeed,
+		root:          st.Root,
+		seqNo:         st.SeqNo,
+		seqNoReserved: st.SeqNo,
+		succession:    st.Succession,
+		rootLookahead: defaultRootLookahead,
+	}
+	sk.ph = ctx.precomputeHashes(sk.pubSeed, sk.skSeed)
+	sk.rootCache.subH = ctx.defaultRootSubTreeHeight()
+	if st.RootTree != nil {
+		if err := sk.restoreRootTree(st.RootTree); err != nil {
+			return nil, err
+		}
+	}
+	for i, cs := range st.Channels {
+		if cs.Layer == 0 || cs.ChainSeqNo >= ctx.chainTreeHeight(cs.Layer) {
+			return nil, fmt.Errorf("channel %d has an invalid state", i)
+		}
+		if uint32(len(cs.Cache)) != ctx.chainTreeCacheSize(cs.Layer) {
+			return nil, fmt.Errorf("channel %d has a cache of %d bytes", i, len(cs.Cache))
+		}
+		sk.Channels = append(sk.Channels, &Channel{
+			layers:             cs.Layer,
+			chainSeqNo:         cs.ChainSeqNo,
+			seqNo:              cs.SeqNo,
+			cache:              cs.Cache,
+			closed:             cs.Closed,
+			chainSeqNoReserved: cs.ChainSeqNo,
+			seqNoReserved:      cs.SeqNo,
+		})
+	}
+	return sk, nil
+}
+
+// Returns the reserved state of the PrivateKey, which shares its slices.
+// The lock of the PrivateKey should be held.
+func (sk *PrivateKey) keyState() *KeyState {
+	st := &KeyState{
+		Params:     sk.ctx.params,
+		SkSeed:     sk.skSeed,
+		SkPrf:      sk.skPrf,
+		PubSeed:    sk.pubSeed,
+		Root:       sk.root,
+		SeqNo:      sk.seqNoReserved,
+		Succession: sk.succession,
+		Channels:   make([]ChannelState, len(sk.Channels)),
+		RootTree:   sk.rootCache.state(),
+	}
+	for i, ch := range sk.Channels {
+		st.Channels[i] = ChannelState{
+			Layer:      ch.layers,
+			ChainSeqNo: ch.chainSeqNoReserved,
+			SeqNo:      ch.seqNoReserved,
+			Cache:      ch.cache,
+			Closed:     ch.closed,
+		}
+	}
+	return st
+}
+
+// Saves the reserved state of the PrivateKey to its state store, if it has
+// one. The lock of the PrivateKey should be held.
+func (sk *PrivateKey) saveState() error {
+	if sk.store == nil {
+		return nil
+	}
+	// A destroyed PrivateKey would overwrite its seeds with zeroes.
+	if sk.destroyed {
+		return fmt.Errorf("the private key is destroyed")
+	}
+	if err := sk.store.Save(sk.keyState()); err != nil {
+		return fmt.Errorf("saving key state failed: %s", err)
+	}
+	return nil
+}
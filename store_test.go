@@ -0,0 +1,80 @@
+package mbpqs
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Persists a key to store, and checks that the reloaded key continues its
+// channels and root tree.
+func testStateStore(t *testing.T, store StateStore) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 2, 1, 1, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	if st, err := store.Load(); st != nil || err != nil {
+		t.Fatalf("Empty store holds %v, %v", st, err)
+	}
+	if _, err = LoadPrivateKeyFrom(store, 0); err == nil {
+		t.Fatal("Loading from an empty store did not give an error")
+	}
+	if err = sk.PersistTo(store); err != nil {
+		t.Fatalf("Persisting key failed with error %s", err)
+	}
+	if err = sk.PersistTo(store); err == nil {
+		t.Fatal("Persisting to a store which holds a key did not give an error")
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	if _, _, err = sk.AddChannel(); err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	authNode := signAndVerify(t, sk, pk, chIdx, rtSig.NextAuthNode())
+	growSig, err := sk.GrowChannel(chIdx)
+	if err != nil {
+		t.Fatalf("Growing channel failed with error %s", err)
+	}
+	authNode = growSig.NextAuthNode()
+	if _, err = sk.CloseChannel(1); err != nil {
+		t.Fatalf("Closing channel failed with error %s", err)
+	}
+
+	// Restart: the channel continues in its second chain tree, and the
+	// root tree with its saved traversal state.
+	sk, err = LoadPrivateKeyFrom(store, 0)
+	if err != nil {
+		t.Fatalf("Loading key failed with error %s", err)
+	}
+	if !sk.rootCache.built {
+		t.Fatal("Reloaded key does not continue with the traversal state of the root tree")
+	}
+	authNode = signAndVerify(t, sk, pk, chIdx, authNode)
+	sk, err = LoadPrivateKeyFrom(store, 0)
+	if err != nil {
+		t.Fatalf("Loading key failed with error %s", err)
+	}
+	signAndVerify(t, sk, pk, chIdx, authNode)
+	if _, err = sk.SignMsg(1, []byte("Block")); err == nil {
+		t.Fatal("Signing in a closed channel did not give an error")
+	}
+	_, rtSig, err = sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	if rtSig.seqNo != 2 {
+		t.Fatalf("Reloaded key uses root tree leaf %d instead of 2", rtSig.seqNo)
+	}
+	if accept, err := pk.VerifyChannel(rtSig); !accept || err != nil {
+		t.Fatalf("RootSignature of the reloaded key not accepted: %v", err)
+	}
+}
+
+func TestMemoryStateStore(t *testing.T) {
+	testStateStore(t, new(MemoryStateStore))
+}
+
+func TestFileStateStore(t *testing.T) {
+	testStateStore(t, &keyFile{path: filepath.Join(t.TempDir(), "orderer.key")})
+}
@@ -0,0 +1,210 @@
+package mbpqs
+
+import (
+	"fmt"
+	"sync"
+)
+
+/* Once all leafs of its root tree are used, a key pair can not add channels
+ * anymore. To rotate to a new key pair without distributing its PublicKey out
+ * of band, the last leaf of the root tree signs the successor PublicKey in a
+ * SuccessionCertificate. The leaf can be reserved for this purpose with
+ * ReserveSuccessorLeaf, such that AddChannel never uses it. The signed digest
+ * is H_msg over the encoding of the successor, keyed with the root of the
+ * signing key and the index of the last leaf.
+ */
+
+// Succession flags of a PrivateKey, saved in its key file.
+const (
+	successorReserved = 1 << 0 // The last root tree leaf is reserved for SignSuccessor.
+	successorSigned   = 1 << 1 // The last root tree leaf has signed the successor.
+)
+
+// SuccessionCertificate certifies the successor of a PublicKey.
+type SuccessionCertificate struct {
+	rtSig *RootSignature // Signature of the last root tree leaf over the digest of next.
+	next  *PublicKey     // The successor.
+}
+
+// Successor returns the PublicKey certified by the SuccessionCertificate.
+func (sc *SuccessionCertificate) Successor() *PublicKey {
+	return sc.next
+}
+
+// String returns a description of the SuccessionCertificate for humans.
+func (sc *SuccessionCertificate) String() string {
+	return fmt.Sprintf("SuccessionCertificate{seqNo: %d, successor: %s}", sc.rtSig.seqNo, sc.next)
+}
+
+// Returns the index of the last root tree leaf, which signs the successor.
+func (ctx *Context) successorLeaf() uint32 {
+	return uint32((uint64(1) << ctx.params.rootH) - 1)
+}
+
+// Returns the amount of root tree leafs which can be used for channels.
+// The lock of the PrivateKey should be held.
+func (sk *PrivateKey) rootLeafLimit() uint64 {
+	if sk.succession != 0 {
+		return uint64(sk.ctx.successorLeaf())
+	}
+	return uint64(1) << sk.ctx.params.rootH
+}
+
+// Computes the digest of the successor next, signed by the key with the given root.
+func (ctx *Context) successorDigest(pad scratchPad, root []byte, next *PublicKey) ([]byte, error) {
+	buf, err := next.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	// The signer chooses the successor, so the digest needs no randomness.
+	return ctx.hashMessage(pad, buf, make([]byte, ctx.params.n), root, uint64(ctx.successorLeaf()))
+}
+
+// ReserveSuccessorLeaf reserves the last leaf of the root tree to sign the
+// successor of the key pair with SignSuccessor, such that AddChannel does not
+// use it. The reservation is saved in the key file of the PrivateKey.
+func (sk *PrivateKey) ReserveSuccessorLeaf() error {
+	sk.mux.Lock()
+	defer sk.mux.Unlock()
+	if sk.succession&successorReserved != 0 {
+		return nil
+	}
+	last := sk.ctx.successorLeaf()
+	if uint64(sk.seqNo) > uint64(last) {
+		return fmt.Errorf("the last root tree leaf is already used for a channel")
+	}
+	oldSuccession, oldReserved := sk.succession, sk.seqNoReserved
+	sk.succession |= successorReserved
+	// Leafs reserved ahead in the key file are not used yet, so the
+	// reservation may be shortened to exclude the last leaf.
+	if uint64(sk.seqNoReserved) > uint64(last) {
+		sk.seqNoReserved = SignatureSeqNo(last)
+	}
+	if err := sk.saveState(); err != nil {
+		sk.succession, sk.seqNoReserved = oldSuccession, oldReserved
+		return err
+	}
+	return nil
+}
+
+// SignSuccessor signs the successor next of the key pair with the last leaf
+// of the root tree. This can only be done once, and only if the last leaf is
+// not used for a channel. The channels of the PrivateKey can still be used.
+func (sk *PrivateKey) SignSuccessor(next *PublicKey) (*SuccessionCertificate, error) {
+	if next == nil || next.ctx == nil {
+		return nil, fmt.Errorf("successor has no context")
+	}
+	pad := sk.ctx.newScratchPad()
+	defer pad.wipe()
+	digest, err := sk.ctx.successorDigest(pad, sk.root, next)
+	if err != nil {
+		return nil, err
+	}
+
+	last := sk.ctx.successorLeaf()
+	sk.mux.Lock()
+	if sk.destroyed {
+		sk.mux.Unlock()
+		return nil, fmt.Errorf("the private key is destroyed")
+	}
+	if sk.succession&successorSigned != 0 {
+		sk.mux.Unlock()
+		return nil, fmt.Errorf("the successor is already signed")
+	}
+	if uint64(sk.seqNo) > uint64(last) {
+		sk.mux.Unlock()
+		return nil, fmt.Errorf("the last root tree leaf is already used for a channel")
+	}
+	// Record the last leaf in the journal, and save that it is used, before
+	// the signature is released.
+	if err = sk.journalKey(JournalEntry{
+		Kind:   JournalSuccessor,
+		Key:    OTSKey{Root: true, Index: last},
+		Digest: digest,
+	}); err != nil {
+		sk.mux.Unlock()
+		return nil, err
+	}
+	oldSuccession := sk.succession
+	sk.succession |= successorSigned
+	if uint64(sk.seqNoReserved) > uint64(last) {
+		sk.seqNoReserved = SignatureSeqNo(last)
+	}
+	if err = sk.saveState(); err != nil {
+		sk.succession = oldSuccession
+		sk.mux.Unlock()
+		return nil, err
+	}
+	sk.mux.Unlock()
+
+	var otsAddr address
+	otsAddr.setOTS(last)
+	rtSig := &RootSignature{
+		ctx:      sk.ctx,
+		seqNo:    SignatureSeqNo(last),
+		wotsSig:  sk.ctx.wotsSign(pad, digest, sk.pubSeed, sk.skSeed, otsAddr),
+		authPath: sk.rootAuthPath(pad, last),
+		rootHash: digest,
+	}
+	return &SuccessionCertificate{rtSig: rtSig, next: next}, nil
+}
+
+// VerifySuccessor returns true if the SuccessionCertificate is signed by the
+// PublicKey.
+func (pk *PublicKey) VerifySuccessor(sc *SuccessionCertificate) (bool, error) {
+	if sc == nil || sc.rtSig == nil || sc.next == nil || sc.next.ctx == nil {
+		return false, verifyError(ErrMalformedSignature, "incomplete succession certificate")
+	}
+	if err := pk.checkSignature(sc.rtSig); err != nil {
+		return false, err
+	}
+	if sc.rtSig.seqNo != SignatureSeqNo(pk.ctx.successorLeaf()) {
+		return false, verifyError(ErrOutOfOrder, "succession certificate is signed by root tree leaf %d instead of the last one", sc.rtSig.seqNo)
+	}
+	digest, err := pk.ctx.successorDigest(pk.ctx.newScratchPad(), pk.root, sc.next)
+	if err != nil {
+		return false, err
+	}
+	return pk.VerifyChannelRoot(sc.rtSig, digest)
+}
+
+// KeyChain follows the succession of the PublicKeys of a signer, starting
+// at a trusted PublicKey. A successor is only accepted with a valid
+// SuccessionCertificate of the current PublicKey.
+type KeyChain struct {
+	keys []*PublicKey
+	mux  sync.Mutex // Used when mutual exclusion for the key chain is required.
+}
+
+// NewKeyChain returns a KeyChain starting at the trusted PublicKey pk.
+func NewKeyChain(pk *PublicKey) *KeyChain {
+	return &KeyChain{keys: []*PublicKey{pk}}
+}
+
+// Add verifies the SuccessionCertificate with the current PublicKey, and
+// makes its successor the current PublicKey if it is accepted.
+func (kc *KeyChain) Add(sc *SuccessionCertificate) (bool, error) {
+	kc.mux.Lock()
+	defer kc.mux.Unlock()
+	accept, err := kc.keys[len(kc.keys)-1].VerifySuccessor(sc)
+	if err != nil || !accept {
+		return accept, err
+	}
+	kc.keys = append(kc.keys, sc.next)
+	return true, nil
+}
+
+// Current returns the last accepted PublicKey in the KeyChain.
+func (kc *KeyChain) Current() *PublicKey {
+	kc.mux.Lock()
+	defer kc.mux.Unlock()
+	return kc.keys[len(kc.keys)-1]
+}
+
+// Keys returns the PublicKeys in the KeyChain, from the trusted one on.
+// Signatures made by earlier PublicKeys remain valid.
+func (kc *KeyChain) Keys() []*PublicKey {
+	kc.mux.Lock()
+	defer kc.mux.Unlock()
+	return append([]*PublicKey{}, kc.keys...)
+}
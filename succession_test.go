@@ -0,0 +1,101 @@
+package mbpqs
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSuccession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orderer.key")
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 2, 0, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	if err = sk.Persist(path); err != nil {
+		t.Fatalf("Persisting key failed with error %s", err)
+	}
+	if err = sk.ReserveSuccessorLeaf(); err != nil {
+		t.Fatalf("Reserving successor leaf failed with error %s", err)
+	}
+
+	// The reservation survives a restart, and leaves three channels.
+	if sk, err = LoadPrivateKey(path, 0); err != nil {
+		t.Fatalf("Loading key failed with error %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, _, err = sk.AddChannel(); err != nil {
+			t.Fatalf("Adding channel failed with error %s", err)
+		}
+	}
+	if _, _, err = sk.AddChannel(); err == nil {
+		t.Fatal("Adding a channel with the reserved leaf did not give an error")
+	}
+
+	_, next, err := GenerateKeyPair(InitParamHash(32, 3, 2, 0, 0, 4, SHAKE256), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	sc, err := sk.SignSuccessor(next)
+	if err != nil {
+		t.Fatalf("Signing successor failed with error %s", err)
+	}
+	if _, err = sk.SignSuccessor(next); err == nil {
+		t.Fatal("Signing a second successor did not give an error")
+	}
+	buf, err := sc.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Marshalling certificate failed with error %s", err)
+	}
+	var sc2 SuccessionCertificate
+	if err = sc2.UnmarshalBinary(buf); err != nil {
+		t.Fatalf("Unmarshalling certificate failed with error %s", err)
+	}
+
+	kc := NewKeyChain(pk)
+	// A certificate which is not signed by the current key is rejected.
+	if accept, _ := NewKeyChain(next).Add(&sc2); accept {
+		t.Fatal("Certificate accepted by another key")
+	}
+	if accept, err := kc.Add(&sc2); !accept || err != nil {
+		t.Fatalf("Correct certificate not accepted: %v", err)
+	}
+	if kc.Current().String() != next.String() || len(kc.Keys()) != 2 {
+		t.Fatalf("Key chain is at %s with %d keys", kc.Current(), len(kc.Keys()))
+	}
+
+	// The certificate is not valid for another successor.
+	_, other, err := GenerateKeyPair(InitParam(32, 2, 2, 0, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	sc2.next = other
+	if accept, _ := pk.VerifySuccessor(&sc2); accept {
+		t.Fatal("Certificate accepted for another successor")
+	}
+
+	// After a restart, the successor can not be signed again.
+	if sk, err = LoadPrivateKey(path, 0); err != nil {
+		t.Fatalf("Loading key failed with error %s", err)
+	}
+	if _, err = sk.SignSuccessor(other); err == nil {
+		t.Fatal("Signing a successor after a restart did not give an error")
+	}
+}
+
+func TestSuccessorLeafUsed(t *testing.T) {
+	sk, _, err := GenerateKeyPair(InitParam(32, 1, 2, 0, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err = sk.AddChannel(); err != nil {
+			t.Fatalf("Adding channel failed with error %s", err)
+		}
+	}
+	if err = sk.ReserveSuccessorLeaf(); err == nil {
+		t.Fatal("Reserving a used leaf did not give an error")
+	}
+	if _, err = sk.SignSuccessor(sk.PublicKey()); err == nil {
+		t.Fatal("Signing a successor with a used leaf did not give an error")
+	}
+}
@@ -0,0 +1,309 @@
+package mbpqs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+/* To avoid regenerating the whole root tree for every RootSignature, the
+ * PrivateKey keeps a traversal state of its root tree. The root tree is split
+ * in a top tree, holding all nodes from height subH up to the root, and
+ * subtrees of height subH below it. The state holds the top tree, the current
+ * subtree with the leaf to sign with, and the next subtree, which is built one
+ * leaf per RootSignature. Authentication paths are thus produced at the cost
+ * of a single extra leaf, and the state takes
+ *
+ *   n * ((2^(rootH-subH+1) - 1) + 2 * (2^(subH+1) - 1))
+ *
+ * bytes of memory. The state is built when the key pair is generated, which
+ * generates the full root tree once. It only holds public nodes, and is saved
+ * with the state of the PrivateKey, such that a loaded PrivateKey continues
+ * with it. A PrivateKey without saved state builds it the first time it makes
+ * a RootSignature.
+ */
+
+// rootTreeCache is the traversal state of the root tree of a PrivateKey.
+type rootTreeCache struct {
+	subH       uint32     // Height of the subtrees below the top tree.
+	built      bool       // Whether the state below is built.
+	top        rootTree   // Nodes from height subH up to the root.
+	cur        rootTree   // Subtree holding the leaf to sign with.
+	curIdx     uint32     // Index of the current subtree.
+	next       rootTree   // Subtree after the current one, built incrementally.
+	nextLeaves uint32     // The amount of leafs generated in next.
+	mux        sync.Mutex // Used when mutual exclusion for the cache is required.
+}
+
+// Returns the memory in bytes taken by a root tree cache with subtrees of height subH.
+func (ctx *Context) rootTreeCacheSize(subH uint32) uint64 {
+	rootH := uint64(ctx.params.rootH)
+	nodes := (uint64(1) << (rootH - uint64(subH) + 1)) - 1 + 2*((uint64(1)<<(subH+1))-1)
+	return uint64(ctx.params.n) * nodes
+}
+
+// Returns the subtree height which minimizes the memory of the root tree cache.
+func (ctx *Context) defaultRootSubTreeHeight() uint32 {
+	var best uint32
+	for subH := uint32(1); subH <= ctx.params.rootH; subH++ {
+		if ctx.rootTreeCacheSize(subH) < ctx.rootTreeCacheSize(best) {
+			best = subH
+		}
+	}
+	return best
+}
+
+// SetRootTreeMemory bounds the memory the PrivateKey uses to keep track of
+// its root tree to max bytes. The root tree is split in a stored top tree and
+// subtrees below it, of which only two are kept at a time. A larger bound
+// allows a larger top tree, and thus smaller subtrees, which are faster to
+// regenerate if leafs are skipped, for example after loading a key file.
+// The state is rebuilt on the next RootSignature.
+func (sk *PrivateKey) SetRootTreeMemory(max uint64) error {
+	subH := sk.ctx.defaultRootSubTreeHeight()
+	if sk.ctx.rootTreeCacheSize(subH) > max {
+		return fmt.Errorf("the root tree needs at least %d bytes of memory", sk.ctx.rootTreeCacheSize(subH))
+	}
+	for subH > 0 && sk.ctx.rootTreeCacheSize(subH-1) <= max {
+		subH--
+	}
+	rtc := &sk.rootCache
+	rtc.mux.Lock()
+	defer rtc.mux.Unlock()
+	rtc.subH = subH
+	rtc.built = false
+	rtc.top, rtc.cur, rtc.next = rootTree{}, rootTree{}, rootTree{}
+	return nil
+}
+
+// RootTreeMemory returns the memory in bytes the PrivateKey uses to keep
+// track of its root tree.
+func (sk *PrivateKey) RootTreeMemory() uint64 {
+	sk.rootCache.mux.Lock()
+	defer sk.rootCache.mux.Unlock()
+	return sk.ctx.rootTreeCacheSize(sk.rootCache.subH)
+}
+
+// Builds the root tree cache such that the current subtree holds leaf, and
+// returns the root of the root tree. Generates the full root tree, but only
+// keeps the nodes of the cache in memory.
+// The lock of the cache should be held.
+func (sk *PrivateKey) buildRootTreeCache(pad scratchPad, leaf uint32) []byte {
+	rtc := &sk.rootCache
+	n := sk.ctx.params.n
+	subH := rtc.subH
+	subTrees := uint32(1) << (sk.ctx.params.rootH - subH)
+	rtc.top = newRootTree(sk.ctx.params.rootH-subH+1, n)
+	rtc.cur = newRootTree(subH+1, n)
+	rtc.next = newRootTree(subH+1, n)
+	rtc.curIdx = leaf >> subH
+	rtc.nextLeaves = 0
+
+	// Generate the subtrees one by one, into the current and next subtree
+	// if they are kept.
+	tmp := newRootTree(subH+1, n)
+	for idx := uint32(0); idx < subTrees; idx++ {
+		st := tmp
+		if idx == rtc.curIdx {
+			st = rtc.cur
+		} else if idx == rtc.curIdx+1 {
+			st = rtc.next
+			rtc.nextLeaves = 1 << subH
+		}
+		sk.ctx.genRootSubTreeInto(pad, sk.ph, idx<<subH, st)
+		copy(rtc.top.node(0, idx), st.getRootNode())
+	}
+	sk.ctx.hashRootTreeInto(pad, sk.ph, subH, 0, rtc.top)
+	rtc.built = true
+	return rtc.top.getRootNode()
+}
+
+// Returns the authentication path of leaf in the root tree, from the root
+// tree cache, and advances the next subtree of the cache by one leaf.
+func (sk *PrivateKey) rootAuthPath(pad scratchPad, leaf uint32) []byte {
+	rtc := &sk.rootCache
+	rtc.mux.Lock()
+	defer rtc.mux.Unlock()
+	if !rtc.built {
+		sk.buildRootTreeCache(pad, leaf)
+	}
+	subH := rtc.subH
+	idx := leaf >> subH
+	st := rtc.cur
+	switch {
+	case idx == rtc.curIdx:
+	case idx == rtc.curIdx+1:
+		// Move on to the next subtree, and start building the one after it.
+		sk.finishNextRootSubTree(pad)
+		rtc.cur, rtc.next = rtc.next, rtc.cur
+		rtc.curIdx++
+		rtc.nextLeaves = 0
+		st = rtc.cur
+	case idx > rtc.curIdx:
+		// Leafs are skipped, regenerate the subtree of the leaf.
+		sk.ctx.genRootSubTreeInto(pad, sk.ph, idx<<subH, rtc.cur)
+		rtc.curIdx = idx
+		rtc.nextLeaves = 0
+	default:
+		// An earlier leaf, which is not kept.
+		st = newRootTree(subH+1, sk.ctx.params.n)
+		sk.ctx.genRootSubTreeInto(pad, sk.ph, idx<<subH, st)
+	}
+
+	n := sk.ctx.params.n
+	authPath := make([]byte, n*sk.ctx.params.rootH)
+	copy(authPath, st.AuthPath(leaf-idx<<subH))
+	copy(authPath[n*subH:], rtc.top.AuthPath(idx))
+	sk.stepNextRootSubTree(pad)
+	return authPath
+}
+
+// Generates the next leaf of the next subtree in the root tree cache.
+// The lock of the cache should be held.
+func (sk *PrivateKey) stepNextRootSubTree(pad scratchPad) {
+	rtc := &sk.rootCache
+	leafs := uint32(1) << rtc.subH
+	if rtc.nextLeaves >= leafs || (uint64(rtc.curIdx)+1)<<rtc.subH >= uint64(1)<<sk.ctx.params.rootH {
+		return
+	}
+	var otsAddr, lTreeAddr address
+	otsAddr.setType(otsAddrType)
+	lTreeAddr.setType(lTreeAddrType)
+	leaf := (rtc.curIdx+1)<<rtc.subH + rtc.nextLeaves
+	lTreeAddr.setLTree(leaf)
+	otsAddr.setOTS(leaf)
+	copy(rtc.next.node(0, rtc.nextLeaves), sk.ctx.genLeaf(pad, sk.ph, lTreeAddr, otsAddr))
+	rtc.nextLeaves++
+	if rtc.nextLeaves == leafs {
+		base := (rtc.curIdx + 1) << rtc.subH
+		sk.ctx.hashRootTreeInto(pad, sk.ph, 0, base, rtc.next)
+		// Leafs restored from a saved state may be damaged, see restoreRootTree.
+		if !bytes.Equal(rtc.next.getRootNode(), rtc.top.node(0, rtc.curIdx+1)) {
+			sk.ctx.genRootSubTreeInto(pad, sk.ph, base, rtc.next)
+		}
+	}
+}
+
+// Completes the next subtree in the root tree cache.
+// The lock of the cache should be held.
+func (sk *PrivateKey) finishNextRootSubTree(pad scratchPad) {
+	rtc := &sk.rootCache
+	for rtc.nextLeaves < 1<<rtc.subH {
+		sk.stepNextRootSubTree(pad)
+	}
+}
+
+// RootTreeState is the traversal state of the root tree in a KeyState, see
+// rootTreeCache. It only holds public nodes of the root tree.
+type RootTreeState struct {
+	SubH       uint32 // Height of the subtrees below the top tree.
+	CurIdx     uint32 // Index of the current subtree.
+	NextLeaves uint32 // The amount of leafs generated in the next subtree.
+	Top        []byte // Nodes from height SubH up to the root.
+	Cur        []byte // The current subtree.
+	Next       []byte // The next subtree, of which NextLeaves leafs are generated.
+}
+
+// Returns a copy of the root tree cache, or nil if it is not built.
+func (rtc *rootTreeCache) state() *RootTreeState {
+	rtc.mux.Lock()
+	defer rtc.mux.Unlock()
+	if !rtc.built {
+		return nil
+	}
+	return &RootTreeState{
+		SubH:       rtc.subH,
+		CurIdx:     rtc.curIdx,
+		NextLeaves: rtc.nextLeaves,
+		Top:        append([]byte{}, rtc.top.buf...),
+		Cur:        append([]byte{}, rtc.cur.buf...),
+		Next:       append([]byte{}, rtc.next.buf...),
+	}
+}
+
+// Restores the root tree cache of the PrivateKey from rs, which it takes
+// over, after checking that it belongs to the root of the PrivateKey.
+func (sk *PrivateKey) restoreRootTree(rs *RootTreeState) error {
+	rootH, n := sk.ctx.params.rootH, sk.ctx.params.n
+	subH := rs.SubH
+	if subH > rootH {
+		return fmt.Errorf("root tree state has subtrees of height %d", subH)
+	}
+	subTrees, leafs := uint64(1)<<(rootH-subH), uint64(1)<<subH
+	if uint64(rs.CurIdx) >= subTrees || uint64(rs.NextLeaves) > leafs {
+		return fmt.Errorf("root tree state has an invalid position")
+	}
+	topSize, subSize := (2*subTrees-1)*uint64(n), (2*leafs-1)*uint64(n)
+	if uint64(len(rs.Top)) != topSize || uint64(len(rs.Cur)) != subSize || uint64(len(rs.Next)) != subSize {
+		return fmt.Errorf("root tree state has the wrong size")
+	}
+	top := rootTreeFromBuf(rs.Top, rootH-subH+1, n)
+	cur := rootTreeFromBuf(rs.Cur, subH+1, n)
+	next := rootTreeFromBuf(rs.Next, subH+1, n)
+	// A complete next subtree is checked like the current one. The leafs
+	// of an incomplete one are checked once it is completed.
+	pad := sk.ctx.newScratchPad()
+	complete := uint64(rs.NextLeaves) == leafs && uint64(rs.CurIdx)+1 < subTrees
+	if !sk.checkRootSubTree(pad, subH, 0, top, sk.root) ||
+		!sk.checkRootSubTree(pad, 0, rs.CurIdx<<subH, cur, top.node(0, rs.CurIdx)) ||
+		(complete && !sk.checkRootSubTree(pad, 0, (rs.CurIdx+1)<<subH, next, top.node(0, rs.CurIdx+1))) {
+		return fmt.Errorf("root tree state does not match the root")
+	}
+	rtc := &sk.rootCache
+	rtc.mux.Lock()
+	defer rtc.mux.Unlock()
+	rtc.subH, rtc.curIdx, rtc.nextLeaves = subH, rs.CurIdx, rs.NextLeaves
+	rtc.top, rtc.cur, rtc.next = top, cur, next
+	rtc.built = true
+	return nil
+}
+
+// Returns whether the nodes of rt are the hashes of its leafs, which are the
+// nodes at height baseH in the root tree starting at index base, and whether
+// its root equals root.
+func (sk *PrivateKey) checkRootSubTree(pad scratchPad, baseH, base uint32, rt rootTree, root []byte) bool {
+	tmp := rootTreeFromBuf(append([]byte{}, rt.buf...), rt.height, rt.n)
+	sk.ctx.hashRootTreeInto(pad, sk.ph, baseH, base, tmp)
+	return bytes.Equal(tmp.buf, rt.buf) && bytes.Equal(tmp.getRootNode(), root)
+}
+
+// Encodes the root tree state rs as:
+//
+//	subH || curIdx || nextLeaves || top || cur || next
+func marshalRootTreeState(rs *RootTreeState) []byte {
+	buf := make([]byte, 12, 12+len(rs.Top)+len(rs.Cur)+len(rs.Next))
+	binary.BigEndian.PutUint32(buf[0:4], rs.SubH)
+	binary.BigEndian.PutUint32(buf[4:8], rs.CurIdx)
+	binary.BigEndian.PutUint32(buf[8:12], rs.NextLeaves)
+	buf = append(buf, rs.Top...)
+	buf = append(buf, rs.Cur...)
+	return append(buf, rs.Next...)
+}
+
+// Decodes the root tree state encoded by marshalRootTreeState. The sizes of
+// its trees follow from subH. The state is checked by restoreRootTree.
+func (ctx *Context) rootTreeStateFromBytes(buf []byte) (*RootTreeState, error) {
+	if len(buf) < 12 {
+		return nil, fmt.Errorf("root tree state too short")
+	}
+	rs := &RootTreeState{
+		SubH:       binary.BigEndian.Uint32(buf[0:4]),
+		CurIdx:     binary.BigEndian.Uint32(buf[4:8]),
+		NextLeaves: binary.BigEndian.Uint32(buf[8:12]),
+	}
+	rootH, n := uint64(ctx.params.rootH), uint64(ctx.params.n)
+	if uint64(rs.SubH) > rootH {
+		return nil, fmt.Errorf("root tree state has subtrees of height %d", rs.SubH)
+	}
+	topSize := ((uint64(1) << (rootH - uint64(rs.SubH) + 1)) - 1) * n
+	subSize := ((uint64(1) << (rs.SubH + 1)) - 1) * n
+	buf = buf[12:]
+	if uint64(len(buf)) != topSize+2*subSize {
+		return nil, fmt.Errorf("root tree state has the wrong size")
+	}
+	rs.Top = append([]byte{}, buf[:topSize]...)
+	rs.Cur = append([]byte{}, buf[topSize:topSize+subSize]...)
+	rs.Next = append([]byte{}, buf[topSize+subSize:]...)
+	return rs, nil
+}
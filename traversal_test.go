@@ -0,0 +1,111 @@
+package mbpqs
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The root tree cache gives the same authentication paths as the full root tree.
+func TestRootTreeCache(t *testing.T) {
+	var rootH uint32 = 5
+	sk, _, err := GenerateKeyPair(InitParam(32, rootH, 2, 0, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	pad := sk.ctx.newScratchPad()
+	rt := sk.ctx.genRootTree(pad, sk.ph)
+	if !bytes.Equal(rt.getRootNode(), sk.root) {
+		t.Fatal("Root of the root tree cache differs from the root of the root tree")
+	}
+
+	// The leafs to sign with: in order, skipping some, and going back.
+	leafs := []uint32{0, 1, 2, 3, 4, 5, 9, 10, 17, 6, 18, 31}
+	for subH := uint32(0); subH <= rootH; subH++ {
+		if err = sk.SetRootTreeMemory(sk.ctx.rootTreeCacheSize(subH)); err != nil {
+			continue
+		}
+		for _, leaf := range leafs {
+			if !bytes.Equal(sk.rootAuthPath(pad, leaf), rt.AuthPath(leaf)) {
+				t.Fatalf("Authentication path of leaf %d differs with subtree height %d", leaf, sk.rootCache.subH)
+			}
+		}
+	}
+}
+
+func TestSetRootTreeMemory(t *testing.T) {
+	sk, _, err := GenerateKeyPair(InitParam(32, 10, 2, 0, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	min := sk.RootTreeMemory()
+	if min > 32*3*(1<<6) {
+		t.Fatalf("Default root tree cache takes %d bytes", min)
+	}
+	if err = sk.SetRootTreeMemory(min - 1); err == nil {
+		t.Fatal("Root tree memory below the minimum did not give an error")
+	}
+	if err = sk.SetRootTreeMemory(1 << 20); err != nil {
+		t.Fatalf("Setting root tree memory failed with error %s", err)
+	}
+	if sk.rootCache.subH != 0 || sk.RootTreeMemory() > 1<<20 {
+		t.Fatalf("Root tree cache takes %d bytes with subtree height %d", sk.RootTreeMemory(), sk.rootCache.subH)
+	}
+}
+
+// A key loaded from a store continues with the saved root tree cache, which
+// is checked against the root.
+func TestRootTreeStateSaved(t *testing.T) {
+	var rootH uint32 = 5
+	sk, _, err := GenerateKeyPair(InitParam(32, rootH, 2, 0, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	pad := sk.ctx.newScratchPad()
+	rt := sk.ctx.genRootTree(pad, sk.ph)
+	if err = sk.SetRootTreeMemory(sk.ctx.rootTreeCacheSize(2)); err != nil {
+		t.Fatalf("Setting root tree memory failed with error %s", err)
+	}
+	store := new(MemoryStateStore)
+	if err = sk.PersistTo(store); err != nil {
+		t.Fatalf("Persisting key failed with error %s", err)
+	}
+	for i := 0; i < 6; i++ {
+		if _, err = sk.SignChannelRoot(rt.node(0, 0)); err != nil {
+			t.Fatalf("Signing channel root failed with error %s", err)
+		}
+	}
+
+	loaded, err := LoadPrivateKeyFrom(store, 0)
+	if err != nil {
+		t.Fatalf("Loading key failed with error %s", err)
+	}
+	rtc := &loaded.rootCache
+	if !rtc.built || rtc.subH != 2 || rtc.curIdx != 1 || rtc.nextLeaves != 2 {
+		t.Fatalf("Loaded root tree cache at subtree %d with %d leafs of the next one", rtc.curIdx, rtc.nextLeaves)
+	}
+	// A damaged leaf of the incomplete next subtree is regenerated once
+	// the subtree is complete.
+	clear(rtc.next.node(0, 0))
+	for leaf := uint32(6); leaf < 1<<rootH; leaf++ {
+		if !bytes.Equal(loaded.rootAuthPath(pad, leaf), rt.AuthPath(leaf)) {
+			t.Fatalf("Authentication path of leaf %d differs after loading", leaf)
+		}
+	}
+
+	// A state which does not belong to the root is rejected.
+	for _, damage := range []func(rs *RootTreeState){
+		func(rs *RootTreeState) { rs.Top[0] ^= 1 },
+		func(rs *RootTreeState) { rs.Top[len(rs.Top)-1] ^= 1 },
+		func(rs *RootTreeState) { rs.Cur[0] ^= 1 },
+		func(rs *RootTreeState) { rs.Cur[len(rs.Cur)-1] ^= 1 },
+	} {
+		st, err := store.Load()
+		if err != nil {
+			t.Fatalf("Loading state failed with error %s", err)
+		}
+		damage(st.RootTree)
+		if _, err = privateKeyFromState(st); err == nil {
+			t.Fatal("Loading a damaged root tree state did not give an error")
+		}
+	}
+}
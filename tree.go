@@ -61,8 +61,14 @@ func (ctx *Context) genRootTree(pad scratchPad, ph precomputedHashes) rootTree {
 
 // Generate a root tree into the allocated memory rt.
 func (ctx *Context) genRootTreeInto(pad scratchPad, ph precomputedHashes, rt rootTree) {
-	// Init address for OTS, LTree nodes, and Tree nodes.
-	var otsAddr, lTreeAddr, nodeAddr address
+	ctx.genRootSubTreeInto(pad, ph, 0, rt)
+}
+
+// Generate the subtree of the root tree with its leftmost leaf at index base
+// into the allocated memory rt. The height of the subtree is rt.height-1.
+func (ctx *Context) genRootSubTreeInto(pad scratchPad, ph precomputedHashes, base uint32, rt rootTree) {
+	// Init address for OTS and LTree nodes.
+	var otsAddr, lTreeAddr address
 	// Set subTreeAddress for the
 	sta := SubTreeAddress{
 		Layer: 0,
@@ -73,15 +79,14 @@ func (ctx *Context) genRootTreeInto(pad scratchPad, ph precomputedHashes, rt roo
 	otsAddr.setType(otsAddrType)
 	lTreeAddr.setSubTreeFrom(addr)
 	lTreeAddr.setType(lTreeAddrType)
-	nodeAddr.setSubTreeFrom(addr)
-	nodeAddr.setType(treeAddrType)
 
-	// First, compute the leafs of the root tree.
+	// First, compute the leafs of the subtree.
 	var idx uint32
+	leafs := uint32(1) << (rt.height - 1)
 	if ctx.threads == 1 {
-		for idx = 0; idx < (1 << ctx.params.rootH); idx++ {
-			lTreeAddr.setLTree(idx)
-			otsAddr.setOTS(idx)
+		for idx = 0; idx < leafs; idx++ {
+			lTreeAddr.setLTree(base + idx)
+			otsAddr.setOTS(base + idx)
 			copy(rt.node(0, idx), ctx.genLeaf(pad, ph, lTreeAddr, otsAddr))
 		}
 	} else {
@@ -104,16 +109,16 @@ func (ctx *Context) genRootTreeInto(pad scratchPad, ph precomputedHashes, rt roo
 					ourIdx = idx
 					idx += perBatch
 					mux.Unlock()
-					if ourIdx >= 1<<ctx.params.rootH {
+					if ourIdx >= leafs {
 						break
 					}
 					ourEnd := ourIdx + perBatch
-					if ourEnd > 1<<ctx.params.rootH {
-						ourEnd = 1 << ctx.params.rootH
+					if ourEnd > leafs {
+						ourEnd = leafs
 					}
 					for ; ourIdx < ourEnd; ourIdx++ {
-						lTreeAddr.setLTree(ourIdx)
-						otsAddr.setOTS(ourIdx)
+						lTreeAddr.setLTree(base + ourIdx)
+						otsAddr.setOTS(base + ourIdx)
 						copy(rt.node(0, ourIdx), ctx.genLeaf(
 							pad,
 							ph,
@@ -121,20 +126,29 @@ func (ctx *Context) genRootTreeInto(pad scratchPad, ph precomputedHashes, rt roo
 							otsAddr))
 					}
 				}
+				pad.wipe()
 				wg.Done()
 			}(lTreeAddr, otsAddr)
 		}
 		wg.Wait()
 	}
 	// Next, compute the internal nodes and the root node.
-	var height uint32
+	ctx.hashRootTreeInto(pad, ph, 0, base, rt)
+}
+
+// Computes the internal nodes of rt from its leafs. The leafs of rt are the
+// nodes at height baseH in the root tree, starting at index base.
+func (ctx *Context) hashRootTreeInto(pad scratchPad, ph precomputedHashes, baseH, base uint32, rt rootTree) {
+	var nodeAddr address
+	nodeAddr.setType(treeAddrType)
+	var height, idx uint32
 	// Looping through all the layers of the rootTree.
-	for height = 1; height <= ctx.params.rootH; height++ {
+	for height = 1; height < rt.height; height++ {
 		// Set tree height of the computed node
-		nodeAddr.setTreeHeight(height - 1)
+		nodeAddr.setTreeHeight(baseH + height - 1)
 		// Looping through al the nodes on a rootTree layer.
-		for idx = 0; idx < (1 << (ctx.params.rootH - height)); idx++ {
-			nodeAddr.setTreeIndex(idx)
+		for idx = 0; idx < (1 << (rt.height - 1 - height)); idx++ {
+			nodeAddr.setTreeIndex(base>>height + idx)
 			// Hashing pairs of nodes on a layer into eachother.
 			ctx.hInto(pad, rt.node(height-1, 2*idx),
 				rt.node(height-1, 2*idx+1),
@@ -223,6 +237,12 @@ func (ctx *Context) newScratchPad() scratchPad {
 	return pad
 }
 
+// Zeroes the scratchpad, which holds secrets after signing or key generation.
+func (pad scratchPad) wipe() {
+	clear(pad.buf)
+	pad.hashPad.wipe()
+}
+
 func (pad scratchPad) fBuf() []byte {
 	return pad.buf[:3*pad.n]
 }
@@ -0,0 +1,223 @@
+package mbpqs
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// ChannelVerifier verifies the signatures of a single channel in the order
+// they were created. It keeps track of the authentication node the next
+// signature is verified against, and rejects signatures which are replayed,
+// skip signatures, or belong to another channel or chain tree.
+type ChannelVerifier struct {
+	pk         *PublicKey
+	chIdx      uint32         // The channel the verifier follows.
+	layer      uint32         // The layer of the current chain tree.
+	chainSeqNo uint32         // The chainSeqNo of the next signature.
+	seqNo      SignatureSeqNo // The seqNo of the next MsgSignature.
+	authNode   []byte         // The node the next signature is verified against.
+	closed     bool           // Whether a CloseSignature is accepted.
+	mux        sync.Mutex     // Used when mutual exclusion for the verifier is required.
+
+	// Called with every accepted signature while the lock is held, together
+	// with the chain tree leaf of its key for a MsgSignature.
+	onAccept func(sig Signature, leaf []byte)
+}
+
+// NewChannelVerifier verifies the RootSignature of channel chIdx, and returns
+// a ChannelVerifier which accepts the subsequent signatures in the channel.
+func (pk *PublicKey) NewChannelVerifier(chIdx uint32, rtSig *RootSignature) (*ChannelVerifier, error) {
+	if _, err := pk.VerifyChannel(rtSig); err != nil {
+		return nil, err
+	}
+	if err := checkRootLeaf(rtSig, chIdx); err != nil {
+		return nil, err
+	}
+	return &ChannelVerifier{
+		pk:       pk,
+		chIdx:    chIdx,
+		layer:    1,
+		authNode: append([]byte{}, rtSig.GetSignedRoot()...),
+	}, nil
+}
+
+// Verify verifies the next signature in the channel, which is either a
+// MsgSignature over msg or a GrowSignature. The verifier only advances if the
+// signature is accepted.
+func (cv *ChannelVerifier) Verify(sig Signature, msg []byte) (bool, error) {
+	switch t := sig.(type) {
+	case *MsgSignature:
+		return cv.VerifyMsg(t, msg)
+	case *GrowSignature:
+		return cv.VerifyGrow(t)
+	case *CloseSignature:
+		return cv.VerifyClose(t)
+	case *RootSignature:
+		return false, verifyError(ErrOutOfOrder, "channel %d already has a verified RootSignature", cv.chIdx)
+	default:
+		return false, verifyError(ErrMalformedSignature, "unknown signature type %T", t)
+	}
+}
+
+// VerifyMsg verifies the next MsgSignature in the channel over msg.
+func (cv *ChannelVerifier) VerifyMsg(sig *MsgSignature, msg []byte) (bool, error) {
+	return cv.VerifyMsgReader(sig, bytes.NewReader(msg))
+}
+
+// VerifyMsgReader verifies the next MsgSignature in the channel over the
+// message read from msg.
+func (cv *ChannelVerifier) VerifyMsgReader(sig *MsgSignature, msg io.Reader) (bool, error) {
+	if err := cv.pk.checkSignature(sig); err != nil {
+		return false, err
+	}
+	cv.mux.Lock()
+	defer cv.mux.Unlock()
+	if err := cv.checkPosition(sig.chIdx, sig.layer, sig.chainSeqNo); err != nil {
+		return false, err
+	}
+	if sig.chainSeqNo == cv.lastKey() {
+		return false, verifyError(ErrOutOfOrder, "the last key of chain tree %d can only sign a GrowSignature", cv.layer)
+	}
+	if sig.seqNo < cv.seqNo {
+		return false, verifyError(ErrOutOfOrder, "replayed signature with seqNo %d, expected %d", sig.seqNo, cv.seqNo)
+	}
+	if sig.seqNo > cv.seqNo {
+		return false, verifyError(ErrOutOfOrder, "signature has seqNo %d, but %d is expected", sig.seqNo, cv.seqNo)
+	}
+	leaf, err := cv.pk.verifyMsgSig(cv.pk.ctx.newScratchPad(), sig, msg, cv.authNode)
+	if err != nil {
+		return false, err
+	}
+	cv.authNode = append([]byte{}, sig.NextAuthNode(cv.authNode)...)
+	cv.chainSeqNo++
+	cv.seqNo++
+	if cv.onAccept != nil {
+		cv.onAccept(sig, leaf)
+	}
+	return true, nil
+}
+
+// VerifyGrow verifies the GrowSignature which ends the current chain tree.
+func (cv *ChannelVerifier) VerifyGrow(sig *GrowSignature) (bool, error) {
+	if err := cv.pk.checkSignature(sig); err != nil {
+		return false, err
+	}
+	cv.mux.Lock()
+	defer cv.mux.Unlock()
+	if err := cv.checkPosition(sig.chIdx, sig.layer, sig.chainSeqNo); err != nil {
+		return false, err
+	}
+	if sig.chainSeqNo != cv.lastKey() {
+		return false, verifyError(ErrOutOfOrder, "chain tree %d is grown before all its keys are used", cv.layer)
+	}
+	accept, err := cv.pk.VerifyGrow(sig, cv.authNode)
+	if err != nil || !accept {
+		return accept, err
+	}
+	cv.authNode = append([]byte{}, sig.NextAuthNode()...)
+	cv.layer++
+	cv.chainSeqNo = 0
+	if cv.onAccept != nil {
+		cv.onAccept(sig, nil)
+	}
+	return true, nil
+}
+
+// VerifyClose verifies the CloseSignature which ends the channel. Once it is
+// accepted, every later signature in the channel is rejected.
+func (cv *ChannelVerifier) VerifyClose(sig *CloseSignature) (bool, error) {
+	if err := cv.pk.checkSignature(sig); err != nil {
+		return false, err
+	}
+	cv.mux.Lock()
+	defer cv.mux.Unlock()
+	if err := cv.checkPosition(sig.chIdx, sig.layer, sig.chainSeqNo); err != nil {
+		return false, err
+	}
+	if sig.seqNo != cv.seqNo {
+		return false, verifyError(ErrOutOfOrder, "channel is closed at seqNo %d, but %d is expected", sig.seqNo, cv.seqNo)
+	}
+	accept, err := cv.pk.VerifyClose(sig, cv.authNode)
+	if err != nil || !accept {
+		return accept, err
+	}
+	cv.closed = true
+	cv.authNode = nil
+	if cv.onAccept != nil {
+		cv.onAccept(sig, nil)
+	}
+	return true, nil
+}
+
+// Closed returns whether the channel is closed by an accepted CloseSignature.
+func (cv *ChannelVerifier) Closed() bool {
+	cv.mux.Lock()
+	defer cv.mux.Unlock()
+	return cv.closed
+}
+
+// VerifyAutoGrow verifies the signatures made by SignChannelMsgAutoGrow over
+// msg. If the GrowSignature is accepted, the verifier moves on to the next
+// chain tree, also if the MsgSignature is not accepted.
+func (cv *ChannelVerifier) VerifyAutoGrow(sig *AutoGrowSignature, msg []byte) (bool, error) {
+	if sig == nil {
+		return false, verifyError(ErrMalformedSignature, "no AutoGrowSignature to verify")
+	}
+	if sig.Grow != nil {
+		accept, err := cv.VerifyGrow(sig.Grow)
+		if err != nil || !accept {
+			return accept, err
+		}
+	}
+	if sig.Msg == nil {
+		return false, verifyError(ErrMalformedSignature, "no MsgSignature to verify")
+	}
+	return cv.VerifyMsg(sig.Msg, msg)
+}
+
+// AuthNode returns the authentication node the next signature is verified against.
+func (cv *ChannelVerifier) AuthNode() []byte {
+	cv.mux.Lock()
+	defer cv.mux.Unlock()
+	return append([]byte{}, cv.authNode...)
+}
+
+// SeqNo returns the seqNo of the next MsgSignature in the channel.
+func (cv *ChannelVerifier) SeqNo() SignatureSeqNo {
+	cv.mux.Lock()
+	defer cv.mux.Unlock()
+	return cv.seqNo
+}
+
+// Layer returns the layer of the current chain tree in the channel.
+func (cv *ChannelVerifier) Layer() uint32 {
+	cv.mux.Lock()
+	defer cv.mux.Unlock()
+	return cv.layer
+}
+
+// Checks whether a signature at the given position is the next one in the channel.
+func (cv *ChannelVerifier) checkPosition(chIdx, layer, chainSeqNo uint32) error {
+	if cv.closed {
+		return verifyError(ErrChannelClosed, "channel %d is closed at seqNo %d", cv.chIdx, cv.seqNo)
+	}
+	if chIdx != cv.chIdx {
+		return verifyError(ErrWrongChannel, "signature is for channel %d, but the verifier follows channel %d", chIdx, cv.chIdx)
+	}
+	if layer != cv.layer {
+		return verifyError(ErrWrongLayer, "signature is from chain tree %d, but the channel is at chain tree %d", layer, cv.layer)
+	}
+	if chainSeqNo < cv.chainSeqNo {
+		return verifyError(ErrOutOfOrder, "replayed signature with chainSeqNo %d, expected %d", chainSeqNo, cv.chainSeqNo)
+	}
+	if chainSeqNo > cv.chainSeqNo {
+		return verifyError(ErrOutOfOrder, "signature has chainSeqNo %d, but %d is expected", chainSeqNo, cv.chainSeqNo)
+	}
+	return nil
+}
+
+// Returns the chainSeqNo of the last key in the current chain tree.
+func (cv *ChannelVerifier) lastKey() uint32 {
+	return cv.pk.ctx.chainTreeHeight(cv.layer) - 1
+}
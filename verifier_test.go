@@ -0,0 +1,133 @@
+package mbpqs
+
+import (
+	"testing"
+)
+
+func TestChannelVerifier(t *testing.T) {
+	var chanH uint32 = 3
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, chanH, 1, 0, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	// Add a channel which is not followed, to have chIdx != 0.
+	if _, _, err = sk.AddChannel(); err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+	if err != nil {
+		t.Fatalf("Creating verifier failed with error %s", err)
+	}
+
+	// Sign and verify three chain trees with growing heights.
+	msg := []byte("Block in the channel")
+	for layer := uint32(1); layer <= 3; layer++ {
+		for i := uint32(0); i < chanH+layer-2; i++ {
+			sig, err := sk.SignMsg(chIdx, msg)
+			if err != nil {
+				t.Fatalf("Signing message failed with error %s", err)
+			}
+			if accept, err := cv.Verify(sig, msg); !accept || err != nil {
+				t.Fatalf("Correct MsgSignature %d not accepted: %v", sig.seqNo, err)
+			}
+			// The same signature can not be accepted twice.
+			if accept, err := cv.VerifyMsg(sig, msg); accept || err == nil {
+				t.Fatalf("Replayed MsgSignature %d accepted", sig.seqNo)
+			}
+		}
+		growSig, err := sk.GrowChannel(chIdx)
+		if err != nil {
+			t.Fatalf("Growing channel failed with error %s", err)
+		}
+		if accept, err := cv.Verify(growSig, nil); !accept || err != nil {
+			t.Fatalf("Correct GrowSignature for layer %d not accepted: %v", layer, err)
+		}
+		if cv.Layer() != layer+1 {
+			t.Fatalf("Verifier is at layer %d instead of %d", cv.Layer(), layer+1)
+		}
+	}
+	if cv.SeqNo() != 9 {
+		t.Fatalf("Verifier expects seqNo %d instead of 9", cv.SeqNo())
+	}
+
+	// A signature over another message is not accepted, and does not advance the verifier.
+	sig, err := sk.SignMsg(chIdx, msg)
+	if err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	if accept, _ := cv.VerifyMsg(sig, []byte("Another block")); accept {
+		t.Fatal("MsgSignature over another message accepted")
+	}
+	// Skipping a signature is not accepted.
+	sig2, err := sk.SignMsg(chIdx, msg)
+	if err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	if accept, err := cv.VerifyMsg(sig2, msg); accept || err == nil {
+		t.Fatal("MsgSignature after a gap accepted")
+	}
+	if accept, err := cv.VerifyMsg(sig, msg); !accept || err != nil {
+		t.Fatalf("Correct MsgSignature not accepted: %v", err)
+	}
+
+	// Signatures of another channel are not accepted.
+	otherSig, err := sk.SignMsg(0, msg)
+	if err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	if accept, err := cv.VerifyMsg(otherSig, msg); accept || err == nil {
+		t.Fatal("MsgSignature of another channel accepted")
+	}
+	// Neither are signatures from another layer.
+	sig2.layer--
+	if accept, err := cv.VerifyMsg(sig2, msg); accept || err == nil {
+		t.Fatal("MsgSignature from another layer accepted")
+	}
+}
+
+func TestChannelVerifierEarlyGrow(t *testing.T) {
+	sk, pk, err := GenerateKeyPair(InitParam(32, 2, 3, 0, 1, 4), 0)
+	if err != nil {
+		t.Fatalf("KeyGen failed with error %s", err)
+	}
+	chIdx, rtSig, err := sk.AddChannel()
+	if err != nil {
+		t.Fatalf("Adding channel failed with error %s", err)
+	}
+	cv, err := pk.NewChannelVerifier(chIdx, rtSig)
+	if err != nil {
+		t.Fatalf("Creating verifier failed with error %s", err)
+	}
+	msg := []byte("Block in the channel")
+	if _, err = sk.SignMsg(chIdx, msg); err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	sig, err := sk.SignMsg(chIdx, msg)
+	if err != nil {
+		t.Fatalf("Signing message failed with error %s", err)
+	}
+	growSig, err := sk.GrowChannel(chIdx)
+	if err != nil {
+		t.Fatalf("Growing channel failed with error %s", err)
+	}
+	// The verifier has not seen the MsgSignatures yet.
+	if accept, err := cv.VerifyGrow(growSig); accept || err == nil {
+		t.Fatal("GrowSignature accepted before the chain tree was used")
+	}
+	if accept, err := cv.VerifyMsg(sig, msg); accept || err == nil {
+		t.Fatal("MsgSignature after a gap accepted")
+	}
+	if _, err = pk.NewChannelVerifier(chIdx, &RootSignature{
+		ctx:      rtSig.ctx,
+		seqNo:    rtSig.seqNo + 1,
+		wotsSig:  rtSig.wotsSig,
+		authPath: rtSig.authPath,
+		rootHash: rtSig.rootHash,
+	}); err == nil {
+		t.Fatal("Verifier created from an invalid RootSignature")
+	}
+}
@@ -77,6 +77,24 @@ func (ctx *Context) wotsGenChainInto(pad scratchPad, in []byte,
 	}
 }
 
+// Advances WOTS+ chain i from position starts[i] to ends[i], for all chains.
+// The chains are read from in, and written into out. With the multi-lane
+// backend, several chains are advanced together, see sha256x8.go.
+func (ctx *Context) wotsGenChainsInto(pad scratchPad, in []byte, starts, ends []uint16,
+	ph precomputedHashes, addr address, out []byte) {
+	if ph.pubSeedLanes != nil {
+		ctx.wotsGenChainsLanes(in, starts, ends, ph.pubSeedLanes, addr, out)
+		return
+	}
+	n := ctx.params.n
+	var i uint32
+	for i = 0; i < ctx.wotsLen; i++ {
+		addr.setChain(i)
+		ctx.wotsGenChainInto(pad, in[n*i:n*(i+1)], starts[i], ends[i]-starts[i],
+			ph, addr, out[n*i:n*(i+1)])
+	}
+}
+
 // Generate a WOTS+ public key from secret key seed.
 func (ctx *Context) wotsPkGen(pad scratchPad, ph precomputedHashes,
 	addr address) []byte {
@@ -89,13 +107,12 @@ func (ctx *Context) wotsPkGen(pad scratchPad, ph precomputedHashes,
 func (ctx *Context) wotsPkGenInto(pad scratchPad, ph precomputedHashes,
 	addr address, out []byte) {
 	ctx.genWotsSk(pad, ph, addr, out)
-	var i uint32
-	for i = 0; i < ctx.wotsLen; i++ {
-		addr.setChain(uint32(i))
-		ctx.wotsGenChainInto(pad, out[ctx.params.n*i:ctx.params.n*(i+1)],
-			0, ctx.params.w-1, ph, addr,
-			out[ctx.params.n*i:ctx.params.n*(i+1)])
+	starts := make([]uint16, ctx.wotsLen)
+	ends := make([]uint16, ctx.wotsLen)
+	for i := range ends {
+		ends[i] = ctx.params.w - 1
 	}
+	ctx.wotsGenChainsInto(pad, out, starts, ends, ph, addr, out)
 }
 
 // Create a WOTS+ signature of a n-byte message
@@ -111,13 +128,12 @@ func (ctx *Context) wotsSignInto(pad scratchPad, msg []byte,
 	ph precomputedHashes, addr address, wotsSig []byte) {
 	lengths := ctx.wotsChainLengths(msg)
 	ctx.genWotsSk(pad, ph, addr, wotsSig)
-	var i uint32
-	for i = 0; i < ctx.wotsLen; i++ {
-		addr.setChain(uint32(i))
-		ctx.wotsGenChainInto(pad, wotsSig[ctx.params.n*i:ctx.params.n*(i+1)],
-			0, uint16(lengths[i]), ph, addr,
-			wotsSig[ctx.params.n*i:ctx.params.n*(i+1)])
+	starts := make([]uint16, ctx.wotsLen)
+	ends := make([]uint16, ctx.wotsLen)
+	for i, l := range lengths {
+		ends[i] = uint16(l)
 	}
+	ctx.wotsGenChainsInto(pad, wotsSig, starts, ends, ph, addr, wotsSig)
 }
 
 // Computes the public key from a message and its WOTS+ signature and
@@ -125,13 +141,12 @@ func (ctx *Context) wotsSignInto(pad scratchPad, msg []byte,
 func (ctx *Context) wotsPkFromSigInto(pad scratchPad, sig, msg []byte,
 	ph precomputedHashes, addr address, pk []byte) {
 	lengths := ctx.wotsChainLengths(msg)
-	var i uint32
-	for i = 0; i < ctx.wotsLen; i++ {
-		addr.setChain(uint32(i))
-		ctx.wotsGenChainInto(pad, sig[ctx.params.n*i:ctx.params.n*(i+1)],
-			uint16(lengths[i]), ctx.params.w-1-uint16(lengths[i]),
-			ph, addr, pk[ctx.params.n*i:ctx.params.n*(i+1)])
+	starts := make([]uint16, ctx.wotsLen)
+	ends := make([]uint16, ctx.wotsLen)
+	for i, l := range lengths {
+		starts[i], ends[i] = uint16(l), ctx.params.w-1
 	}
+	ctx.wotsGenChainsInto(pad, sig, starts, ends, ph, addr, pk)
 }
 
 // Returns the public key from a message and its WOTS+ signature.
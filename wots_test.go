@@ -5,7 +5,6 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"math/rand"
-	"reflect"
 	"testing"
 )
 
@@ -207,18 +206,14 @@ func BenchmarkWotsGenPk(b *testing.B) {
 func benchmarkCompression(ctx *Context, b *testing.B) {
 	var addr address
 	pad := ctx.newScratchPad()
-	hashPrfSk := sha256.New()
-	hashPrfSk.Write(encodeUint64(hashPaddingPRF, int(ctx.params.n)))
-	hashValPrfSk := reflect.ValueOf(hashPrfSk).Elem()
-	out := make([]byte, 32)
-	pad.hashPad.hVal.Set(hashValPrfSk)
+	prf, _ := ctx.hash.precompute(encodeUint64(hashPaddingPRF, int(ctx.hashPadLen)))
+	out := make([]byte, ctx.params.n)
 	addrBuf := pad.prfAddrBuf()
 	addr.writeInto(addrBuf)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		pad.hashPad.h.Write(addrBuf)
-		pad.hashPad.h.Sum(out[:0])
+		prf(pad.hashPad.h, addrBuf, out)
 	}
 }
 